@@ -0,0 +1,10 @@
+// Package api holds the types the HTTP API exchanges that are shared by the
+// server and the client.
+package api
+
+// LockStats summarizes the locks held in a namespace.
+type LockStats struct {
+	Locks    int            `json:"locks"`
+	Prefixes map[string]int `json:"prefixes"`
+	Owners   map[string]int `json:"owners"`
+}
@@ -0,0 +1,98 @@
+package application
+
+import (
+	"context"
+	"time"
+
+	"github.com/tentens-tech/shared-lock/internal/application/authz"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/application/namespace"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/audit"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+)
+
+// ForceRelease releases key whichever lease holds it and returns the ID of
+// that lease, or storage.ErrLeaseNotFound when the key is not held. It
+// requires the admin operation on key.
+func (a *Application) ForceRelease(ctx context.Context, key string) (leaseID int64, err error) {
+	ctx, span := tracing.Start(ctx, "application.ForceRelease",
+		tracing.NamespaceAttribute.String(namespace.FromContext(ctx)), tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	var labels map[string]string
+	defer func() { a.recordLeaseEvent(ctx, audit.EventForceRelease, key, leaseID, labels, err) }()
+
+	if err = leasemanagement.ValidateKey(key); err != nil {
+		return 0, err
+	}
+	if err := a.authorize(ctx, authz.OperationAdmin, key); err != nil {
+		return 0, err
+	}
+
+	holder, err := leasemanagement.GetLease(ctx, a.storageConnection, namespace.FromContext(ctx), key)
+	if err != nil {
+		return 0, err
+	}
+	leaseID = holder.ID
+
+	labels, err = a.release(ctx, key, leaseID)
+	return leaseID, err
+}
+
+// TransferLease hands key over to owner without it becoming free in between,
+// and returns the ID of the new lease. The new lease is granted the TTL of
+// the previous one and must be kept alive by the new owner from now on; the
+// previous lease is revoked. It requires the admin operation on key.
+func (a *Application) TransferLease(ctx context.Context, key, owner string) (leaseID int64, err error) {
+	ctx, span := tracing.Start(ctx, "application.TransferLease",
+		tracing.NamespaceAttribute.String(namespace.FromContext(ctx)), tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	var labels map[string]string
+	defer func() {
+		if event, ok := a.leaseEvent(audit.EventTransfer, key, leaseID, labels, err); ok {
+			event.Owner = owner
+			a.record(ctx, event)
+		}
+	}()
+
+	if err = leasemanagement.ValidateKey(key); err != nil {
+		return 0, err
+	}
+	if err := a.authorize(ctx, authz.OperationAdmin, key); err != nil {
+		return 0, err
+	}
+
+	ns := namespace.FromContext(ctx)
+	holder, err := leasemanagement.GetLease(ctx, a.storageConnection, ns, key)
+	if err != nil {
+		return 0, err
+	}
+	labels = holder.Labels
+
+	leaseTTL := time.Duration(holder.GrantedTTL) * time.Second
+	if leaseTTL <= 0 {
+		leaseTTL = a.Config().Lease.DefaultTTL
+	}
+	lease := holder.Lease
+	lease.Owner = owner
+	lease.CreatedAt = time.Now().UTC()
+
+	leaseID, err = leasemanagement.TransferLease(ctx, a.storageConnection, ns, holder.ID, leaseTTL, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	// The per-principal quota usage follows on the next reconciliation.
+	a.removeLeaseFromCache(leaseCacheKey(ns, key))
+	a.markLeaseDead(holder.ID)
+	a.waits.Delete(waitKey(ns, key, holder.Owner))
+	a.recordHistory(ctx, key, leasemanagement.HistoryEvent{
+		Type:    leasemanagement.HistoryTransferred,
+		LeaseID: leaseID,
+		Owner:   owner,
+		Labels:  lease.Labels,
+	})
+
+	return leaseID, nil
+}
@@ -2,84 +2,943 @@ package application
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/api"
+	"github.com/tentens-tech/shared-lock/internal/application/authz"
 	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/application/namespace"
+	"github.com/tentens-tech/shared-lock/internal/application/quota"
 	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/audit"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/cache"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+	"golang.org/x/sync/singleflight"
 )
 
+// leaseWatchRetryInterval is the pause before re-establishing a lost lease
+// watch.
+const leaseWatchRetryInterval = time.Second
+
+// cacheStatusFree marks a cached key that is known not to be held.
+const cacheStatusFree = "free"
+
+const (
+	// maxTrackedWaits bounds the number of callers whose wait for a key is
+	// tracked for the lock wait time metric.
+	maxTrackedWaits = 10000
+	// maxTrackedWait is how long a refused request is remembered. Callers
+	// that get the key later are not counted as having waited for it.
+	maxTrackedWait = time.Hour
+)
+
+// leaseIDRecord is what the cache knows about a lease ID: the key it holds,
+// or that it has expired or was revoked.
+type leaseIDRecord struct {
+	cacheKey string
+	dead     bool
+}
+
 type Application struct {
-	config            *config.Config
-	leaseCache        *cache.Cache
+	config            atomic.Pointer[config.Config]
+	leaseCache        *cache.LeaseCache
+	leaseIDs          *cache.Cache[int64, leaseIDRecord]
+	quotas            *quota.Tracker
+	keyPrefixes       *metrics.KeyPrefixes
+	namespaces        *metrics.Namespaces
+	waits             *cache.Cache[string, time.Time]
+	auditLog          *audit.Logger
+	history           *historyWriter
+	acquisitions      singleflight.Group
+	cacheWatched      atomic.Bool
 	ctx               context.Context
 	storageConnection storage.Storage
 }
 
-func New(ctx context.Context, config *config.Config, storageConnection storage.Storage, leaseCache *cache.Cache) *Application {
-	return &Application{
-		config:            config,
+func New(ctx context.Context, config *config.Config, storageConnection storage.Storage, leaseCache *cache.LeaseCache) *Application {
+	namespaces := metrics.NewNamespaces(config.Namespaces.Names()...)
+	app := &Application{
 		leaseCache:        leaseCache,
+		quotas:            quota.NewTracker(namespaces),
+		keyPrefixes:       metrics.NewKeyPrefixes(config.Metrics.KeyPrefixSeparator, config.Metrics.MaxKeyPrefixes),
+		namespaces:        namespaces,
+		waits:             cache.New[string, time.Time]("waits", maxTrackedWaits, cache.StringHasher),
 		storageConnection: storageConnection,
 		ctx:               ctx,
 	}
+	if leaseCache != nil {
+		app.leaseIDs = cache.New[int64, leaseIDRecord]("lease_ids", config.Cache.Size, cache.Int64Hasher)
+	}
+	app.config.Store(config)
+
+	return app
+}
+
+// Config returns the configuration the application is currently running with.
+func (a *Application) Config() *config.Config {
+	return a.config.Load()
+}
+
+// ApplyConfig switches the application to a reloaded configuration. Only
+// settings that are safe to change at runtime are expected to differ, see
+// config.Reload.
+func (a *Application) ApplyConfig(cfg *config.Config) {
+	a.config.Store(cfg)
+	a.namespaces.Set(cfg.Namespaces.Names()...)
+	a.quotas.Relabel()
+
+	if a.leaseCache != nil {
+		a.leaseCache.SetMaxSize(cfg.Cache.Size)
+		a.leaseIDs.SetMaxSize(cfg.Cache.Size)
+	}
+}
+
+// SetAuditLog makes the application record lock operations in logger. The
+// logger is closed with the application.
+func (a *Application) SetAuditLog(logger *audit.Logger) {
+	a.auditLog = logger
+}
+
+// Close releases the resources held by the application.
+func (a *Application) Close() {
+	a.closeHistory()
+	if a.auditLog != nil {
+		if err := a.auditLog.Close(); err != nil {
+			log.Warnf("Failed to close the audit log: %v", err)
+		}
+	}
+	a.waits.Close()
+	if a.leaseCache != nil {
+		a.leaseCache.Close()
+		a.leaseIDs.Close()
+	}
 }
 
 func (a *Application) CreateLease(
+	ctx context.Context,
 	leaseTTL time.Duration,
 	lease leasemanagement.Lease,
 ) (leaseStatus string, leaseID int64, err error) {
+	ctx, span := tracing.Start(ctx, "application.CreateLease",
+		tracing.NamespaceAttribute.String(namespace.FromContext(ctx)), tracing.KeyAttribute.String(lease.Key))
+	defer func() {
+		span.SetAttributes(tracing.LeaseStatusAttribute.String(leaseStatus), tracing.LeaseIDAttribute.Int64(leaseID))
+		tracing.End(span, err)
+	}()
+	var replayed bool
 	defer func() {
-		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationGet, leaseStatus).Inc()
+		status := leaseStatus
+		if replayed {
+			status = statusReplayed
+		}
+		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationGet, status, a.namespaces.Label(namespace.FromContext(ctx))).Inc()
+		if err == nil && !replayed {
+			a.trackWait(namespace.FromContext(ctx), lease, leaseStatus)
+		}
+		if err == nil && !replayed && leaseStatus == storage.StatusCreated {
+			a.recordHistory(ctx, lease.Key, leasemanagement.HistoryEvent{
+				Type:    leasemanagement.HistoryGranted,
+				LeaseID: leaseID,
+				Owner:   lease.Owner,
+				Labels:  lease.Labels,
+			})
+		}
+		a.recordAcquire(ctx, lease, status, leaseID, err)
 	}()
 
-	cachedLeaseID := a.checkLeasePresenceInCache(lease.Key)
-	if cachedLeaseID == 0 {
-		leaseStatus, leaseID, err = leasemanagement.CreateLease(a.ctx, a.storageConnection, leaseTTL, lease)
+	if err = leasemanagement.ValidateKey(lease.Key); err != nil {
+		return "", 0, err
+	}
+	if err = a.authorize(ctx, authz.OperationAcquire, lease.Key); err != nil {
+		leaseStatus = "denied"
+		return "", 0, err
+	}
+
+	leaseTTL = a.LeaseTTL(leaseTTL)
+	ns := namespace.FromContext(ctx)
+	cacheKey := leaseCacheKey(ns, lease.Key)
+
+	// The owner is always the authenticated caller, never what the client claims.
+	lease.Owner = ""
+	if principal := auth.PrincipalFromContext(ctx); principal != nil {
+		lease.Owner = principal.Name
+	}
+
+	// A retry of a request that was granted the key gets the same answer.
+	replayedLeaseID, err := a.replayAcquisition(ctx, ns, lease)
+	if err != nil {
+		return "", 0, err
+	}
+	if replayedLeaseID != 0 {
+		replayed = true
+		leaseStatus = storage.StatusCreated
+		return leaseStatus, replayedLeaseID, nil
+	}
+
+	cachedLeaseID := a.checkLeasePresenceInCache(cacheKey)
+	if cachedLeaseID != 0 {
+		log.Debugf("Lease already created with ID: %d", cachedLeaseID)
+		span.AddEvent("lease cache hit")
+		leaseStatus = storage.StatusAccepted
+
+		return leaseStatus, cachedLeaseID, nil
+	}
+
+	// Concurrent requests for the same key share a single storage round
+	// trip. Only the request that performed it can have created the lease,
+	// the others are answered as accepted. The round trip must not be cut
+	// short when the request that started it goes away.
+	leader := false
+	acquisition := a.acquisitions.DoChan(cacheKey, func() (any, error) {
+		leader = true
+		return a.acquire(context.WithoutCancel(ctx), ns, cacheKey, leaseTTL, lease)
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", 0, ctx.Err()
+	case result := <-acquisition:
+		acquired := result.Val.(acquireResult)
+		leaseStatus = acquired.status
+		if result.Err != nil {
+			return "", acquired.leaseID, result.Err
+		}
+
+		if !leader {
+			// The concurrent request may be the one this request retries.
+			if replayedLeaseID, err := a.replayAcquisition(ctx, ns, lease); err == nil && replayedLeaseID != 0 {
+				replayed = true
+				leaseStatus = storage.StatusCreated
+				return leaseStatus, replayedLeaseID, nil
+			}
+
+			log.Debugf("Lease acquisition for %v shared with a concurrent request", lease.Key)
+			metrics.AcquireCoalesced.WithLabelValues(a.namespaces.Label(ns)).Inc()
+			span.AddEvent("acquisition shared with a concurrent request")
+			leaseStatus = storage.StatusAccepted
+		}
+
+		return leaseStatus, acquired.leaseID, nil
+	}
+}
+
+type acquireResult struct {
+	status  string
+	leaseID int64
+}
+
+// acquire creates the lease for a key that is not in the cache.
+func (a *Application) acquire(
+	ctx context.Context,
+	ns, cacheKey string,
+	leaseTTL time.Duration,
+	lease leasemanagement.Lease,
+) (acquireResult, error) {
+	admitted, err := a.admit(ctx, ns, leaseTTL, lease)
+	if err != nil {
+		return acquireResult{status: "quota_exceeded"}, err
+	}
+
+	leaseStatus, leaseID, err := a.claim(ctx, ns, cacheKey, leaseTTL, lease)
+	if admitted && (err != nil || leaseStatus != storage.StatusCreated) {
+		a.quotas.Release(ns, lease.Owner, leaseSeconds(leaseTTL))
+	}
+	if err != nil {
+		log.Errorf("%v", err)
+		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationGet, "error", a.namespaces.Label(ns)).Inc()
+
+		return acquireResult{leaseID: leaseID}, err
+	}
+
+	if leaseStatus == storage.StatusCreated {
+		a.rememberAcquisition(ctx, ns, lease, leaseID)
+	}
+
+	log.Debugf("Adding to cache: %d", leaseID)
+	a.addLeaseToCache(cacheKey, leaseStatus, leaseID, leaseTTL)
+
+	return acquireResult{status: leaseStatus, leaseID: leaseID}, nil
+}
+
+// claim creates the lease unless the key is held. For a key known to be free
+// the presence check is skipped; storage still reports the holder if it was
+// taken in the meantime. A key taken between the check and the creation is
+// counted as a lost race.
+func (a *Application) claim(
+	ctx context.Context,
+	ns, cacheKey string,
+	leaseTTL time.Duration,
+	lease leasemanagement.Lease,
+) (string, int64, error) {
+	if a.keyKnownFree(cacheKey) {
+		metrics.CacheNegativeHits.WithLabelValues("key").Inc()
+	} else {
+		leaseID, err := leasemanagement.CheckLease(ctx, a.storageConnection, ns, lease.Key)
 		if err != nil {
-			log.Errorf("%v", err)
-			metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationGet, "error").Inc()
+			return "", 0, fmt.Errorf("failed to check lease presence: %w", err)
+		}
+		if leaseID != 0 {
+			return storage.StatusAccepted, leaseID, nil
+		}
+	}
+
+	leaseStatus, leaseID, err := leasemanagement.ClaimLease(ctx, a.storageConnection, ns, leaseTTL, lease)
+	if err == nil && leaseStatus != storage.StatusCreated {
+		metrics.LeaseRacesLost.WithLabelValues(a.namespaces.Label(ns), a.keyPrefixes.Label(lease.Key)).Inc()
+	}
+
+	return leaseStatus, leaseID, err
+}
+
+// trackWait remembers when a caller was first refused a key and reports how
+// long it waited once the key is granted to it. Callers granted a key at
+// once are reported as not having waited.
+func (a *Application) trackWait(ns string, lease leasemanagement.Lease, leaseStatus string) {
+	key := waitKey(ns, lease.Key, lease.Owner)
+
+	switch leaseStatus {
+	case storage.StatusAccepted:
+		if _, waiting := a.waits.Get(key); !waiting {
+			a.waits.Set(key, time.Now(), maxTrackedWait)
+		}
+	case storage.StatusCreated:
+		var waited time.Duration
+		if since, waiting := a.waits.Get(key); waiting {
+			waited = time.Since(since)
+			a.waits.Delete(key)
+		}
+		metrics.LockWaitDuration.WithLabelValues(a.namespaces.Label(ns), a.keyPrefixes.Label(lease.Key)).Observe(waited.Seconds())
+	}
+}
+
+// waitKey identifies a caller waiting for a key.
+func waitKey(ns, key, owner string) string {
+	return leaseCacheKey(ns, key) + "\x00" + owner
+}
+
+// LeaseTTL returns the TTL a lease requested for requested is granted with.
+func (a *Application) LeaseTTL(requested time.Duration) time.Duration {
+	return a.Config().Lease.ClampTTL(requested)
+}
+
+// ReviveLease prolongs leaseID.
+func (a *Application) ReviveLease(ctx context.Context, leaseID int64) error {
+	return a.revive(ctx, "", leaseID)
+}
+
+// ReviveLock prolongs leaseID like ReviveLease, provided that it holds key;
+// otherwise it returns storage.ErrLeaseNotFound.
+func (a *Application) ReviveLock(ctx context.Context, key string, leaseID int64) error {
+	return a.revive(ctx, key, leaseID)
+}
+
+// revive prolongs leaseID, which must hold expectedKey unless that is "".
+func (a *Application) revive(ctx context.Context, expectedKey string, leaseID int64) (err error) {
+	ns := namespace.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "application.ReviveLease",
+		tracing.NamespaceAttribute.String(ns), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	var key string
+	defer func() { a.recordLeaseEvent(ctx, audit.EventKeepalive, key, leaseID, nil, err) }()
+
+	if a.leaseKnownDead(leaseID) {
+		log.Debugf("Lease %v is known to have expired or been revoked", leaseID)
+		metrics.CacheNegativeHits.WithLabelValues("lease").Inc()
+		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationProlong, "failure", a.namespaces.Label(ns)).Inc()
+		return storage.ErrLeaseNotFound
+	}
+
+	key, err = a.leaseKey(ctx, ns, leaseID, expectedKey != "")
+	if err == nil && expectedKey != "" && key != expectedKey {
+		key, err = expectedKey, storage.ErrLeaseNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, storage.ErrLeaseNotFound) {
+			log.Errorf("Failed to look up the key of lease %v: %v", leaseID, err)
+		}
+		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationProlong, "failure", a.namespaces.Label(ns)).Inc()
+		return err
+	}
+
+	if err := a.authorize(ctx, authz.OperationKeepalive, key); err != nil {
+		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationProlong, "denied", a.namespaces.Label(ns)).Inc()
+		return err
+	}
+
+	leaseTTL, err := leasemanagement.ReviveLease(ctx, a.storageConnection, leaseID)
+	if err != nil {
+		if errors.Is(err, storage.ErrLeaseNotFound) {
+			a.markLeaseDead(leaseID)
+			a.leaseExpired(ctx, key, leaseID)
+		}
+		log.Errorf("Failed to prolong lease %v of key %q: %v", leaseID, key, err)
+		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationProlong, "failure", a.namespaces.Label(ns)).Inc()
+		return err
+	}
+
+	a.refreshCachedLease(leaseID, leaseTTL)
+
+	log.Debugf("Lease %v of key %q prolonged, %v left", leaseID, key, leaseTTL)
+	metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationProlong, "success", a.namespaces.Label(ns)).Inc()
+	return nil
+}
+
+// LeaseByID returns the lease leaseID and the key it holds, or
+// storage.ErrLeaseNotFound.
+func (a *Application) LeaseByID(ctx context.Context, leaseID int64) (_ *leasemanagement.LeaseDetails, err error) {
+	ns := namespace.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "application.LeaseByID",
+		tracing.NamespaceAttribute.String(ns), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	key, err := leasemanagement.LeaseKey(ctx, a.storageConnection, ns, leaseID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.authorize(ctx, authz.OperationInspect, key); err != nil {
+		return nil, err
+	}
+
+	lease, err := leasemanagement.GetLease(ctx, a.storageConnection, ns, key)
+	if err != nil {
+		return nil, err
+	}
+	// The key may have been taken by another lease in the meantime.
+	if lease.ID != leaseID {
+		return nil, storage.ErrLeaseNotFound
+	}
+
+	return lease, nil
+}
+
+// leaseKey returns the key in namespace ns held by leaseID, taken from the
+// cache when known. Storage is only asked when the key decides the outcome,
+// that is when required or with authorization or namespaces enabled;
+// otherwise an unknown key is returned as "".
+func (a *Application) leaseKey(ctx context.Context, ns string, leaseID int64, required bool) (string, error) {
+	if key, ok := a.cachedLeaseKey(ns, leaseID); ok {
+		return key, nil
+	}
+
+	cfg := a.Config()
+	if !required && !cfg.Authz.Enabled && !cfg.Namespaces.Enabled {
+		return "", nil
+	}
+
+	key, err := leasemanagement.LeaseKey(ctx, a.storageConnection, ns, leaseID)
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		// Candidates of leader elections are kept alive with the same
+		// keepalives and authorized by the name of their election.
+		if name, electionErr := leasemanagement.CandidateElection(ctx, a.storageConnection, ns, leaseID); electionErr == nil {
+			return name, nil
+		}
+	}
+
+	return key, err
+}
+
+// ReleaseLease releases key if it is held by leaseID.
+func (a *Application) ReleaseLease(ctx context.Context, key string, leaseID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "application.ReleaseLease", tracing.NamespaceAttribute.String(namespace.FromContext(ctx)),
+		tracing.KeyAttribute.String(key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	var labels map[string]string
+	defer func() { a.recordLeaseEvent(ctx, audit.EventRelease, key, leaseID, labels, err) }()
+
+	if err = leasemanagement.ValidateKey(key); err != nil {
+		return err
+	}
+	if err := a.authorize(ctx, authz.OperationRelease, key); err != nil {
+		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationRelease, "denied", a.namespaces.Label(namespace.FromContext(ctx))).Inc()
+		return err
+	}
+
+	labels, err = a.release(ctx, key, leaseID)
+	return err
+}
+
+// release releases key held by leaseID and returns the labels of the lock.
+func (a *Application) release(ctx context.Context, key string, leaseID int64) (labels map[string]string, err error) {
+	ns := namespace.FromContext(ctx)
+
+	// The quota usage to give back, the time the lock was held and its labels
+	// are only known from the stored record.
+	released, _ := leasemanagement.GetLease(ctx, a.storageConnection, ns, key)
+	if released != nil && released.ID == leaseID {
+		labels = released.Labels
+	}
+
+	err = leasemanagement.ReleaseLease(ctx, a.storageConnection, ns, key, leaseID)
+	if err != nil {
+		if errors.Is(err, storage.ErrLeaseNotFound) {
+			if released == nil || released.ID != leaseID {
+				a.leaseExpired(ctx, key, leaseID)
+			}
+		} else {
+			log.Errorf("Failed to release lease: %v", err)
+		}
+		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationRelease, "failure", a.namespaces.Label(ns)).Inc()
+		return labels, err
+	}
+
+	a.markKeyFree(leaseCacheKey(ns, key))
+	a.markLeaseDead(leaseID)
+	releasedEvent := leasemanagement.HistoryEvent{Type: leasemanagement.HistoryReleased, LeaseID: leaseID, Labels: labels}
+	if released != nil && released.ID == leaseID {
+		releasedEvent.Owner = released.Owner
+		if a.Config().Namespaces.Enabled {
+			a.quotas.Release(ns, released.Owner, released.GrantedTTL)
+		}
+		// The holder asking again for its own key is not waiting for it.
+		a.waits.Delete(waitKey(ns, key, released.Owner))
+		if !released.CreatedAt.IsZero() {
+			metrics.LockHoldDuration.WithLabelValues(a.namespaces.Label(ns), a.keyPrefixes.Label(key)).Observe(time.Since(released.CreatedAt).Seconds())
+		}
+	}
+	a.recordHistory(ctx, key, releasedEvent)
+	metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationRelease, "success", a.namespaces.Label(ns)).Inc()
+	return labels, nil
+}
+
+// InspectLease returns the current holder of key, or storage.ErrLeaseNotFound.
+func (a *Application) InspectLease(ctx context.Context, key string) (_ *leasemanagement.LeaseDetails, err error) {
+	ctx, span := tracing.Start(ctx, "application.InspectLease",
+		tracing.NamespaceAttribute.String(namespace.FromContext(ctx)), tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	if err = leasemanagement.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := a.authorize(ctx, authz.OperationInspect, key); err != nil {
+		return nil, err
+	}
+
+	return leasemanagement.GetLease(ctx, a.storageConnection, namespace.FromContext(ctx), key)
+}
+
+// ListLeases returns the held leases with keys under prefix that the caller
+// is allowed to list.
+func (a *Application) ListLeases(ctx context.Context, prefix string) (_ []leasemanagement.LeaseDetails, err error) {
+	ctx, span := tracing.Start(ctx, "application.ListLeases", tracing.NamespaceAttribute.String(namespace.FromContext(ctx)))
+	defer func() { tracing.End(span, err) }()
+
+	if err := a.authorize(ctx, authz.OperationList, prefix); err != nil {
+		return nil, err
+	}
+
+	leases, err := leasemanagement.ListLeases(ctx, a.storageConnection, namespace.FromContext(ctx), prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := leases[:0]
+	for _, lease := range leases {
+		if a.authorize(ctx, authz.OperationList, lease.Key) == nil {
+			visible = append(visible, lease)
+		}
+	}
+
+	return visible, nil
+}
+
+// WatchKeys streams the changes to the keys starting with prefix that the
+// caller may list, until ctx is done or the watch breaks.
+func (a *Application) WatchKeys(ctx context.Context, prefix string) (<-chan leasemanagement.WatchEvent, error) {
+	if err := a.authorize(ctx, authz.OperationList, prefix); err != nil {
+		return nil, err
+	}
+
+	changes, err := leasemanagement.WatchLeases(ctx, a.storageConnection, namespace.FromContext(ctx), prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan leasemanagement.WatchEvent)
+	go func() {
+		defer close(events)
+
+		for event := range changes {
+			if a.authorize(ctx, authz.OperationList, event.Key) != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// Stats counts the locks the caller may list by key prefix and by owner.
+// Keys without a prefix are counted under "", as are locks without owner.
+func (a *Application) Stats(ctx context.Context) (*api.LockStats, error) {
+	leases, err := a.ListLeases(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	separator := a.Config().Metrics.KeyPrefixSeparator
+	stats := &api.LockStats{
+		Locks:    len(leases),
+		Prefixes: make(map[string]int),
+		Owners:   make(map[string]int),
+	}
+	for _, lease := range leases {
+		prefix := ""
+		if separator != "" {
+			prefix, _, _ = strings.Cut(lease.Key, separator)
+			if prefix == lease.Key {
+				prefix = ""
+			}
+		}
+		stats.Prefixes[prefix]++
+		stats.Owners[lease.Owner]++
+	}
+
+	return stats, nil
+}
+
+// admit checks the namespace quotas for a new lock and reserves it. Keys
+// that are already held pass without a reservation, the caller gets the
+// usual "accepted" answer for them.
+func (a *Application) admit(ctx context.Context, ns string, leaseTTL time.Duration, lease leasemanagement.Lease) (bool, error) {
+	admitted, err := a.reserve(ns, lease.Owner, leaseTTL, lease.Value)
+	if err == nil {
+		return admitted, nil
+	}
+
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) && exceeded.Quota != quota.MaxValueBytes {
+		held, checkErr := leasemanagement.CheckLease(ctx, a.storageConnection, ns, lease.Key)
+		if checkErr == nil && held != 0 {
+			return false, nil
+		}
+	}
+	a.rejectQuota(ns, "Lock "+lease.Key, err)
+
+	return false, err
+}
+
+// reserve checks the namespace quotas for a new lease of owner storing value
+// and reserves it. It reports whether it reserved anything: nothing is
+// tracked while namespaces are disabled.
+func (a *Application) reserve(ns, owner string, leaseTTL time.Duration, value string) (bool, error) {
+	cfg := a.Config().Namespaces
+	if !cfg.Enabled {
+		return false, nil
+	}
+
+	limits := cfg.LimitsFor(ns)
+	if err := quota.CheckValueSize(ns, len(value), limits); err != nil {
+		return false, err
+	}
+	if err := a.quotas.Admit(ns, owner, leaseSeconds(leaseTTL), limits); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// rejectQuota counts and logs the rejection of subject by err.
+func (a *Application) rejectQuota(ns, subject string, err error) {
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		metrics.QuotaRejections.WithLabelValues(a.namespaces.Label(ns), exceeded.Quota).Inc()
+	}
+	log.Warnf("%v rejected: %v", subject, err)
+}
+
+// ReconcileQuotas recounts the usage of every namespace from storage until
+// ctx is done. This corrects the tracked usage for locks that expired and
+// for locks taken through other instances.
+func (a *Application) ReconcileQuotas(ctx context.Context) {
+	cfg := a.Config().Namespaces
+	if !cfg.Enabled {
+		return
+	}
+
+	ticker := time.NewTicker(cfg.QuotaReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.reconcileQuotas(ctx); err != nil {
+			log.Warnf("Failed to reconcile namespace quotas: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Application) reconcileQuotas(ctx context.Context) error {
+	// Listing the whole key space yields keys as "<namespace>/<key>".
+	leases, err := leasemanagement.ScanLeases(ctx, a.storageConnection, "", "")
+	if err != nil {
+		return err
+	}
+
+	candidates, err := leasemanagement.ScanCandidates(ctx, a.storageConnection)
+	if err != nil {
+		return err
+	}
+
+	snapshot := make(quota.Snapshot)
+	for _, lease := range leases {
+		if ns, _, found := strings.Cut(lease.Key, "/"); found {
+			snapshot.Add(ns, lease.Owner, lease.GrantedTTL)
+		}
+	}
+	for _, candidate := range candidates {
+		snapshot.Add(candidate.Namespace, candidate.Owner, candidate.GrantedTTL)
+	}
+	a.quotas.Replace(snapshot)
+
+	return nil
+}
+
+// leaseExpired counts a lease that was found gone instead of being released.
+// The key is "" when it is not known.
+func (a *Application) leaseExpired(ctx context.Context, key string, leaseID int64) {
+	metrics.LeasesExpired.WithLabelValues(a.namespaces.Label(namespace.FromContext(ctx)), a.keyPrefixes.Label(key)).Inc()
+	a.record(ctx, audit.Event{Type: audit.EventExpire, Outcome: "expired", Key: key, LeaseID: leaseID})
+	a.recordHistory(ctx, key, leasemanagement.HistoryEvent{Type: leasemanagement.HistoryExpired, LeaseID: leaseID})
+}
+
+// recordAcquire records the outcome of a lock request. Denials by policy are
+// recorded by authorize.
+func (a *Application) recordAcquire(ctx context.Context, lease leasemanagement.Lease, leaseStatus string, leaseID int64, err error) {
+	var denied *authz.DeniedError
+	var exceeded *quota.ExceededError
+	event := audit.Event{Type: audit.EventAcquire, Outcome: leaseStatus, Key: lease.Key, LeaseID: leaseID, Labels: lease.Labels}
+	switch {
+	case errors.As(err, &denied):
+		return
+	case errors.As(err, &exceeded):
+		event.Type = audit.EventDeny
+		event.Outcome = "quota_exceeded"
+		event.Operation = string(authz.OperationAcquire)
+	case err != nil:
+		event.Outcome = "error"
+	}
+	if err != nil {
+		event.Reason = err.Error()
+	}
+
+	a.record(ctx, event)
+}
+
+// recordLeaseEvent records the outcome of an operation on a lease. Denials
+// are recorded by authorize, successful keepalives only as sampled.
+func (a *Application) recordLeaseEvent(ctx context.Context, eventType, key string, leaseID int64, labels map[string]string, err error) {
+	if event, ok := a.leaseEvent(eventType, key, leaseID, labels, err); ok {
+		a.record(ctx, event)
+	}
+}
 
-			return "", leaseID, err
+// leaseEvent builds the audit event for recordLeaseEvent and reports whether
+// it should be recorded.
+func (a *Application) leaseEvent(eventType, key string, leaseID int64, labels map[string]string, err error) (audit.Event, bool) {
+	var denied *authz.DeniedError
+	if errors.As(err, &denied) {
+		return audit.Event{}, false
+	}
+
+	event := audit.Event{Type: eventType, Outcome: "success", Key: key, LeaseID: leaseID, Labels: labels}
+	switch {
+	case errors.Is(err, storage.ErrLeaseNotFound):
+		event.Outcome = "not_found"
+	case err != nil:
+		event.Outcome = "error"
+		event.Reason = err.Error()
+	case eventType == audit.EventKeepalive:
+		if ratio := a.Config().Audit.KeepaliveSampleRatio; ratio < 1 && rand.Float64() >= ratio {
+			return audit.Event{}, false
 		}
+	}
 
-		log.Debugf("Adding to cache: %d", leaseID)
-		a.addLeaseToCache(lease.Key, leaseStatus, leaseID, leaseTTL)
+	return event, true
+}
+
+// record adds the caller of the request in ctx to event and logs it.
+func (a *Application) record(ctx context.Context, event audit.Event) {
+	if a.auditLog == nil {
+		return
+	}
+
+	event.Namespace = namespace.FromContext(ctx)
+	if principal := auth.PrincipalFromContext(ctx); principal != nil {
+		event.Principal = principal.Name
+	}
+	event.ClientAddress = audit.ClientAddressFromContext(ctx)
+
+	a.auditLog.Log(event)
+}
 
-		return leaseStatus, leaseID, nil
+// ReportActiveLocks counts the locks held in storage every
+// metrics.active_locks_interval until ctx is done.
+func (a *Application) ReportActiveLocks(ctx context.Context) {
+	interval := a.Config().Metrics.ActiveLocksInterval
+	if interval <= 0 {
+		return
 	}
 
-	log.Debugf("Lease already created with ID: %d", cachedLeaseID)
-	leaseStatus = storage.StatusAccepted
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.reportActiveLocks(ctx); err != nil {
+			log.Warnf("Failed to count active locks: %v", err)
+		}
 
-	return leaseStatus, cachedLeaseID, nil
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
-func (a *Application) ReviveLease(leaseID int64) error {
-	err := leasemanagement.ReviveLease(a.ctx, a.storageConnection, leaseID)
+func (a *Application) reportActiveLocks(ctx context.Context) error {
+	leases, err := leasemanagement.ScanLeases(ctx, a.storageConnection, "", "")
 	if err != nil {
-		log.Errorf("Failed to prolong lease: %v", err)
-		metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationProlong, "failure").Inc()
 		return err
 	}
 
-	metrics.LeaseOperations.WithLabelValues(metrics.LeaseOperationProlong, "success").Inc()
+	type series struct{ namespace, prefix string }
+	counts := make(map[series]int)
+	namespaced := a.Config().Namespaces.Enabled
+	for _, lease := range leases {
+		ns, key := "", lease.Key
+		if namespaced {
+			var found bool
+			if ns, key, found = strings.Cut(lease.Key, "/"); !found {
+				continue
+			}
+		}
+		counts[series{a.namespaces.Label(ns), a.keyPrefixes.Label(key)}]++
+	}
+
+	// Prefixes without locks left are dropped rather than reported as 0.
+	metrics.ActiveLocks.Reset()
+	for s, count := range counts {
+		metrics.ActiveLocks.WithLabelValues(s.namespace, s.prefix).Set(float64(count))
+	}
+
 	return nil
 }
 
+// WatchLeases keeps the lease cache coherent with storage until ctx is done,
+// evicting entries for keys that were released, expired or taken by another
+// lease. While the watch is down, entries are cached for at most
+// cache.fallback_ttl.
+func (a *Application) WatchLeases(ctx context.Context) {
+	if a.leaseCache == nil {
+		return
+	}
+
+	for {
+		events, err := a.storageConnection.WatchLeases(ctx, leasemanagement.DefaultPrefix)
+		if err == nil {
+			log.Info("Watching leases to keep the cache coherent")
+			a.cacheWatched.Store(true)
+			for event := range events {
+				a.invalidateCachedLease(event)
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Changes made while the watch is down are missed, so nothing cached
+		// so far can be trusted.
+		a.cacheWatched.Store(false)
+		a.leaseCache.Clear()
+		metrics.CacheInvalidations.WithLabelValues("watch_lost").Inc()
+		log.Warnf("Lease watch lost, caching leases for at most %v: %v", a.Config().Cache.FallbackTTL, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(leaseWatchRetryInterval):
+		}
+	}
+}
+
+func (a *Application) invalidateCachedLease(event storage.LeaseEvent) {
+	// Cache keys are the storage keys without the common prefix, which is
+	// "<namespace>/<key>" when namespaces are enabled.
+	cacheKey := strings.TrimPrefix(event.Key, leasemanagement.DefaultPrefix)
+
+	cached, exists := a.leaseCache.Get(cacheKey)
+	if !exists {
+		return
+	}
+
+	if event.Deleted {
+		if cached.Status != cacheStatusFree {
+			a.markKeyFree(cacheKey)
+			metrics.CacheInvalidations.WithLabelValues("deleted").Inc()
+		}
+		return
+	}
+
+	if cached.ID != event.LeaseID {
+		a.removeLeaseFromCache(cacheKey)
+		metrics.CacheInvalidations.WithLabelValues("replaced").Inc()
+	}
+}
+
+func leaseSeconds(leaseTTL time.Duration) int64 {
+	return int64(leaseTTL.Seconds())
+}
+
+func (a *Application) authorize(ctx context.Context, operation authz.Operation, key string) error {
+	err := authz.Authorize(&a.Config().Authz, auth.PrincipalFromContext(ctx), operation, key)
+	if err != nil {
+		log.Warnf("Authorization denied: %v", err)
+		metrics.AuthzDenials.WithLabelValues(string(operation)).Inc()
+		a.record(ctx, audit.Event{Type: audit.EventDeny, Outcome: "denied", Operation: string(operation), Key: key, Reason: err.Error()})
+	}
+
+	return err
+}
+
+// leaseCacheKey keeps the cache entries of equal keys in different
+// namespaces apart. Namespace names cannot contain '/'.
+func leaseCacheKey(ns, key string) string {
+	if ns == "" {
+		return key
+	}
+
+	return ns + "/" + key
+}
+
 func (a *Application) checkLeasePresenceInCache(key string) int64 {
 	if a.leaseCache == nil {
 		return 0
 	}
 
-	if cachedValue, exists := a.leaseCache.Get(key); exists {
-		if cachedLease, ok := cachedValue.(cache.LeaseCacheRecord); ok {
-			log.Debugf("Cache hit for lease key: %v", key)
-			return cachedLease.ID
-		}
+	if cachedLease, exists := a.leaseCache.Get(key); exists {
+		log.Debugf("Cache hit for lease key: %v", key)
+		return cachedLease.ID
 	}
 
 	return 0
@@ -90,8 +949,103 @@ func (a *Application) addLeaseToCache(key string, status string, id int64, ttl t
 		return
 	}
 
+	if fallbackTTL := a.Config().Cache.FallbackTTL; !a.cacheWatched.Load() && fallbackTTL > 0 && ttl > fallbackTTL {
+		ttl = fallbackTTL
+	}
+
 	a.leaseCache.Set(key, cache.LeaseCacheRecord{
 		Status: status,
 		ID:     id,
 	}, ttl)
+	a.leaseIDs.Set(id, leaseIDRecord{cacheKey: key}, ttl)
+}
+
+// refreshCachedLease extends the cache entry of the key held by leaseID to
+// the remaining TTL reported by a keepalive.
+func (a *Application) refreshCachedLease(leaseID int64, ttl time.Duration) {
+	if a.leaseCache == nil || ttl <= 0 {
+		return
+	}
+
+	record, exists := a.leaseIDs.Get(leaseID)
+	if !exists || record.dead {
+		return
+	}
+	if cached, exists := a.leaseCache.Get(record.cacheKey); exists && cached.ID == leaseID {
+		a.addLeaseToCache(record.cacheKey, cached.Status, leaseID, ttl)
+	}
+}
+
+func (a *Application) cachedLeaseKey(ns string, leaseID int64) (string, bool) {
+	if a.leaseCache == nil {
+		return "", false
+	}
+
+	record, exists := a.leaseIDs.Get(leaseID)
+	if !exists || record.dead {
+		return "", false
+	}
+	if ns == "" {
+		return record.cacheKey, true
+	}
+
+	return strings.CutPrefix(record.cacheKey, ns+"/")
+}
+
+func (a *Application) keyKnownFree(key string) bool {
+	if a.leaseCache == nil {
+		return false
+	}
+
+	cached, exists := a.leaseCache.Get(key)
+	return exists && cached.Status == cacheStatusFree
+}
+
+func (a *Application) markKeyFree(key string) {
+	if a.leaseCache == nil {
+		return
+	}
+
+	if negativeTTL := a.Config().Cache.NegativeTTL; negativeTTL > 0 {
+		a.leaseCache.Set(key, cache.LeaseCacheRecord{Status: cacheStatusFree}, negativeTTL)
+		return
+	}
+	a.leaseCache.Delete(key)
+}
+
+func (a *Application) leaseKnownDead(leaseID int64) bool {
+	if a.leaseCache == nil {
+		return false
+	}
+
+	record, exists := a.leaseIDs.Get(leaseID)
+	return exists && record.dead
+}
+
+// markLeaseDead remembers that leaseID expired or was revoked, which is
+// final, and frees the key it was known to hold.
+func (a *Application) markLeaseDead(leaseID int64) {
+	if a.leaseCache == nil {
+		return
+	}
+
+	if record, exists := a.leaseIDs.Get(leaseID); exists && record.cacheKey != "" {
+		if a.checkLeasePresenceInCache(record.cacheKey) == leaseID {
+			a.markKeyFree(record.cacheKey)
+		}
+	}
+
+	if negativeTTL := a.Config().Cache.NegativeTTL; negativeTTL > 0 {
+		a.leaseIDs.Set(leaseID, leaseIDRecord{dead: true}, negativeTTL)
+		return
+	}
+	a.leaseIDs.Delete(leaseID)
+}
+
+func (a *Application) removeLeaseFromCache(key string) {
+	if a.leaseCache == nil {
+		return
+	}
+
+	a.leaseCache.Delete(key)
 }
@@ -0,0 +1,302 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/application/namespace"
+	"github.com/tentens-tech/shared-lock/internal/application/quota"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/cache"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/etcd"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/etcd/etcdtest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func newEtcdApplication(t *testing.T, leaseCache *cache.LeaseCache, opts ...etcdtest.Option) (*Application, *etcd.Etcd) {
+	t.Helper()
+
+	server := etcdtest.Start(t, opts...)
+	cfg := server.Config()
+
+	etcdStorage, err := etcd.New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = etcdStorage.Client.Close()
+	})
+
+	return New(context.Background(), cfg, etcdStorage, leaseCache), etcdStorage
+}
+
+func TestApplicationEtcd_CreateAndReviveLease(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []etcdtest.Option
+	}{
+		{name: "plain"},
+		{name: "tls", opts: []etcdtest.Option{etcdtest.WithTLS()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			app, etcdStorage := newEtcdApplication(t, nil, tc.opts...)
+			lease := leasemanagement.Lease{Key: "integration-key", Value: "holder"}
+
+			status, leaseID, err := app.CreateLease(ctx, time.Minute, lease)
+			require.NoError(t, err)
+			assert.Equal(t, storage.StatusCreated, status)
+			assert.NotZero(t, leaseID)
+
+			status, sameLeaseID, err := app.CreateLease(ctx, time.Minute, lease)
+			require.NoError(t, err)
+			assert.Equal(t, storage.StatusAccepted, status)
+			assert.Equal(t, leaseID, sameLeaseID)
+
+			assert.NoError(t, app.ReviveLease(ctx, leaseID))
+
+			_, err = etcdStorage.Client.Revoke(context.Background(), clientv3.LeaseID(leaseID))
+			require.NoError(t, err)
+
+			assert.Error(t, app.ReviveLease(ctx, leaseID))
+
+			status, newLeaseID, err := app.CreateLease(ctx, time.Minute, lease)
+			require.NoError(t, err)
+			assert.Equal(t, storage.StatusCreated, status)
+			assert.NotEqual(t, leaseID, newLeaseID)
+		})
+	}
+}
+
+func TestApplicationEtcd_CacheServesHeldLease(t *testing.T) {
+	leaseCache := cache.NewLeaseCache(100)
+	ctx := context.Background()
+	app, etcdStorage := newEtcdApplication(t, leaseCache)
+	lease := leasemanagement.Lease{Key: "cached-key"}
+
+	_, leaseID, err := app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+
+	_, err = etcdStorage.Client.Delete(context.Background(), leasemanagement.DefaultPrefix+lease.Key)
+	require.NoError(t, err)
+
+	status, cachedLeaseID, err := app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusAccepted, status)
+	assert.Equal(t, leaseID, cachedLeaseID)
+}
+
+func TestApplicationEtcd_WatchKeepsCacheCoherent(t *testing.T) {
+	ctx := context.Background()
+	app, etcdStorage := newEtcdApplication(t, nil)
+	replica := New(ctx, app.Config(), etcdStorage, cache.NewLeaseCache(100))
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		replica.WatchLeases(watchCtx)
+	}()
+	t.Cleanup(func() {
+		stopWatch()
+		<-watchDone
+	})
+	require.Eventually(t, replica.cacheWatched.Load, 5*time.Second, 10*time.Millisecond)
+
+	lease := leasemanagement.Lease{Key: "watched-key"}
+	_, leaseID, err := app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+
+	status, cachedLeaseID, err := replica.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusAccepted, status)
+	assert.Equal(t, leaseID, cachedLeaseID)
+
+	require.NoError(t, app.ReleaseLease(ctx, lease.Key, leaseID))
+	require.Eventually(t, func() bool {
+		return replica.checkLeasePresenceInCache(lease.Key) == 0
+	}, 5*time.Second, 10*time.Millisecond, "released lease must be evicted from the replica cache")
+
+	status, newLeaseID, err := replica.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status)
+	assert.NotEqual(t, leaseID, newLeaseID)
+}
+
+func TestApplicationEtcd_RecordsOwner(t *testing.T) {
+	app, etcdStorage := newEtcdApplication(t, nil)
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "billing-worker", Method: auth.MethodToken})
+
+	_, _, err := app.CreateLease(ctx, time.Minute, leasemanagement.Lease{Key: "owned-key", Value: "v", Owner: "spoofed"})
+	require.NoError(t, err)
+
+	resp, err := etcdStorage.Client.Get(ctx, leasemanagement.DefaultPrefix+"owned-key")
+	require.NoError(t, err)
+	require.Len(t, resp.Kvs, 1)
+
+	var record leasemanagement.Lease
+	require.NoError(t, json.Unmarshal(resp.Kvs[0].Value, &record))
+	assert.Equal(t, "billing-worker", record.Owner)
+	assert.Equal(t, "v", record.Value)
+	assert.False(t, record.CreatedAt.IsZero())
+}
+
+func TestApplicationEtcd_Namespaces(t *testing.T) {
+	app, etcdStorage := newEtcdApplication(t, cache.NewLeaseCache(100))
+	cfg := *app.Config()
+	cfg.Namespaces.Enabled = true
+	cfg.Namespaces.Limits = map[string]config.NamespaceLimits{"team-a": {MaxLocks: 1}}
+	app.ApplyConfig(&cfg)
+
+	teamA := namespace.WithNamespace(context.Background(), "team-a")
+	teamB := namespace.WithNamespace(context.Background(), "team-b")
+	lease := leasemanagement.Lease{Key: "migrations"}
+
+	status, leaseA, err := app.CreateLease(teamA, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status)
+
+	status, leaseB, err := app.CreateLease(teamB, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status, "equal keys in different namespaces must not collide")
+	assert.NotEqual(t, leaseA, leaseB)
+
+	resp, err := etcdStorage.Client.Get(context.Background(), "/shared-lock/team-b/migrations")
+	require.NoError(t, err)
+	require.Len(t, resp.Kvs, 1)
+	assert.Equal(t, leaseB, resp.Kvs[0].Lease)
+
+	leases, err := app.ListLeases(teamA, "")
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+	assert.Equal(t, "migrations", leases[0].Key)
+	assert.Equal(t, leaseA, leases[0].ID)
+
+	details, err := app.InspectLease(teamB, "migrations")
+	require.NoError(t, err)
+	assert.Equal(t, leaseB, details.ID)
+
+	_, _, err = app.CreateLease(teamA, time.Minute, leasemanagement.Lease{Key: "backfill"})
+	var exceeded *quota.ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, &quota.ExceededError{Namespace: "team-a", Quota: quota.MaxLocks, Limit: 1, Used: 1, Requested: 1}, exceeded)
+
+	app.removeLeaseFromCache(leaseCacheKey("team-a", "migrations"))
+	status, sameLease, err := app.CreateLease(teamA, time.Minute, lease)
+	require.NoError(t, err, "a held key must still be answered when the quota is exhausted")
+	assert.Equal(t, storage.StatusAccepted, status)
+	assert.Equal(t, leaseA, sameLease)
+
+	require.NoError(t, app.ReleaseLease(teamA, "migrations", leaseA))
+	_, _, err = app.CreateLease(teamA, time.Minute, leasemanagement.Lease{Key: "backfill"})
+	assert.NoError(t, err)
+}
+
+func TestApplicationEtcd_KeepaliveResolvesKey(t *testing.T) {
+	app, _ := newEtcdApplication(t, nil)
+	cfg := *app.Config()
+	cfg.Namespaces.Enabled = true
+	app.ApplyConfig(&cfg)
+
+	teamA := namespace.WithNamespace(context.Background(), "team-a")
+	teamB := namespace.WithNamespace(context.Background(), "team-b")
+
+	_, leaseID, err := app.CreateLease(teamA, time.Minute, leasemanagement.Lease{Key: "job", Value: "holder"})
+	require.NoError(t, err)
+
+	require.NoError(t, app.ReviveLease(teamA, leaseID))
+	assert.ErrorIs(t, app.ReviveLease(teamB, leaseID), storage.ErrLeaseNotFound, "leases of other namespaces must not be kept alive")
+
+	lease, err := app.LeaseByID(teamA, leaseID)
+	require.NoError(t, err)
+	assert.Equal(t, "job", lease.Key)
+	assert.Equal(t, "holder", lease.Value)
+
+	_, err = app.LeaseByID(teamB, leaseID)
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound)
+}
+
+func TestApplicationEtcd_QuotaReconcile(t *testing.T) {
+	app, etcdStorage := newEtcdApplication(t, nil)
+	cfg := *app.Config()
+	cfg.Namespaces.Enabled = true
+	cfg.Namespaces.Limits = map[string]config.NamespaceLimits{
+		"*": {MaxLocks: 2, MaxLocksPerPrincipal: 1, MaxLeaseSeconds: 90, MaxValueBytes: 8},
+	}
+	app.ApplyConfig(&cfg)
+
+	ctx := namespace.WithNamespace(context.Background(), "batch")
+	worker := auth.WithPrincipal(ctx, &auth.Principal{Name: "worker"})
+	exceededQuota := func(err error) string {
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			return exceeded.Quota
+		}
+		return ""
+	}
+
+	_, _, err := app.CreateLease(worker, time.Minute, leasemanagement.Lease{Key: "a", Value: "too large value"})
+	assert.Equal(t, quota.MaxValueBytes, exceededQuota(err))
+
+	_, leaseA, err := app.CreateLease(worker, time.Minute, leasemanagement.Lease{Key: "a"})
+	require.NoError(t, err)
+
+	_, _, err = app.CreateLease(worker, time.Second, leasemanagement.Lease{Key: "b"})
+	assert.Equal(t, quota.MaxLocksPerPrincipal, exceededQuota(err))
+
+	_, _, err = app.CreateLease(ctx, time.Minute, leasemanagement.Lease{Key: "b"})
+	assert.Equal(t, quota.MaxLeaseSeconds, exceededQuota(err))
+
+	_, _, err = app.CreateLease(ctx, 10*time.Second, leasemanagement.Lease{Key: "b"})
+	require.NoError(t, err)
+
+	_, _, err = app.CreateLease(ctx, time.Second, leasemanagement.Lease{Key: "c"})
+	assert.Equal(t, quota.MaxLocks, exceededQuota(err))
+
+	// An expired lock is only noticed by the reconciliation.
+	_, err = etcdStorage.Client.Revoke(context.Background(), clientv3.LeaseID(leaseA))
+	require.NoError(t, err)
+	require.NoError(t, app.reconcileQuotas(context.Background()))
+	assert.Equal(t, quota.Usage{Locks: 1, LeaseSeconds: 10, Principals: map[string]int64{}}, app.quotas.Usage("batch"))
+
+	_, _, err = app.CreateLease(worker, time.Minute, leasemanagement.Lease{Key: "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), app.quotas.Usage("batch").Principals["worker"])
+}
+
+func TestApplicationEtcd_CampaignQuota(t *testing.T) {
+	app, _ := newEtcdApplication(t, nil)
+	cfg := *app.Config()
+	cfg.Namespaces.Enabled = true
+	cfg.Namespaces.Limits = map[string]config.NamespaceLimits{"*": {MaxLocks: 1}}
+	app.ApplyConfig(&cfg)
+
+	ctx := auth.WithPrincipal(namespace.WithNamespace(context.Background(), "batch"), &auth.Principal{Name: "worker"})
+
+	first, _, err := app.Campaign(ctx, "reporter", time.Minute, "pod-a")
+	require.NoError(t, err)
+
+	_, _, err = app.Campaign(ctx, "reporter", time.Minute, "pod-b")
+	var exceeded *quota.ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, quota.MaxLocks, exceeded.Quota)
+
+	_, _, err = app.CreateLease(ctx, time.Minute, leasemanagement.Lease{Key: "job"})
+	assert.ErrorAs(t, err, &exceeded, "candidates and locks share the namespace quota")
+
+	// Candidates are counted by the reconciliation as well.
+	require.NoError(t, app.reconcileQuotas(context.Background()))
+	assert.Equal(t, quota.Usage{Locks: 1, LeaseSeconds: 60, Principals: map[string]int64{"worker": 1}}, app.quotas.Usage("batch"))
+
+	require.NoError(t, app.Resign(ctx, "reporter", first))
+	assert.Equal(t, int64(0), app.quotas.Usage("batch").Locks, "resigning gives the reservation back")
+
+	_, _, err = app.Campaign(ctx, "reporter", time.Minute, "pod-b")
+	assert.NoError(t, err)
+}
@@ -1,16 +1,27 @@
 package application
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/application/authz"
 	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
 	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/audit"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/cache"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/mock"
 )
@@ -62,7 +73,7 @@ func TestApplication_CreateLease(t *testing.T) {
 			ctx := context.Background()
 			cfg := createTestConfig()
 			storageConnection := mock.New()
-			leaseCache := cache.New(1000)
+			leaseCache := cache.NewLeaseCache(1000)
 
 			if tt.name == "Lease already exists" {
 				leaseCache.Set(tt.lease.Key, cache.LeaseCacheRecord{
@@ -73,7 +84,7 @@ func TestApplication_CreateLease(t *testing.T) {
 
 			app := New(ctx, cfg, storageConnection, leaseCache)
 
-			status, id, err := app.CreateLease(tt.leaseTTL, tt.lease)
+			status, id, err := app.CreateLease(ctx, tt.leaseTTL, tt.lease)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -112,7 +123,7 @@ func TestApplication_ReviveLease(t *testing.T) {
 
 			app := New(ctx, cfg, storageConnection, nil)
 
-			err := app.ReviveLease(tt.leaseID)
+			err := app.ReviveLease(ctx, tt.leaseID)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -127,7 +138,7 @@ func TestApplication_ConcurrentLeaseOperations(t *testing.T) {
 	ctx := context.Background()
 	cfg := createTestConfig()
 	storageConnection := mock.New()
-	leaseCache := cache.New(1000)
+	leaseCache := cache.NewLeaseCache(1000)
 
 	app := New(ctx, cfg, storageConnection, leaseCache)
 
@@ -144,12 +155,12 @@ func TestApplication_ConcurrentLeaseOperations(t *testing.T) {
 				Value: fmt.Sprintf("value-%d", index),
 			}
 
-			status, id, err := app.CreateLease(time.Minute, lease)
+			status, id, err := app.CreateLease(ctx, time.Minute, lease)
 			assert.NoError(t, err)
 			assert.NotEmpty(t, status)
 			assert.NotZero(t, id)
 
-			err = app.ReviveLease(id)
+			err = app.ReviveLease(ctx, id)
 			assert.NoError(t, err)
 		}(i)
 	}
@@ -169,12 +180,12 @@ func TestApplication_CacheDisabled(t *testing.T) {
 		Value: "test-value",
 	}
 
-	status, id, err := app.CreateLease(time.Minute, lease)
+	status, id, err := app.CreateLease(ctx, time.Minute, lease)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, status)
 	assert.NotZero(t, id)
 
-	err = app.ReviveLease(id)
+	err = app.ReviveLease(ctx, id)
 	assert.NoError(t, err)
 }
 
@@ -182,7 +193,7 @@ func TestApplication_ErrorHandling(t *testing.T) {
 	ctx := context.Background()
 	cfg := createTestConfig()
 	storageConnection := mock.New()
-	leaseCache := cache.New(1000)
+	leaseCache := cache.NewLeaseCache(1000)
 
 	app := New(ctx, cfg, storageConnection, leaseCache)
 
@@ -191,11 +202,466 @@ func TestApplication_ErrorHandling(t *testing.T) {
 		Value: "error-value",
 	}
 
-	status, id, err := app.CreateLease(time.Minute, lease)
+	status, id, err := app.CreateLease(ctx, time.Minute, lease)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, status)
 	assert.NotZero(t, id)
 
-	err = app.ReviveLease(123)
+	err = app.ReviveLease(ctx, 123)
 	assert.NoError(t, err)
 }
+
+// blockingStorage holds CreateLease until release is closed.
+type blockingStorage struct {
+	*mock.Storage
+	started chan struct{}
+	release chan struct{}
+	creates atomic.Int32
+}
+
+func (s *blockingStorage) CreateLease(ctx context.Context, key string, leaseTTL int64, data []byte) (string, int64, error) {
+	if s.creates.Add(1) == 1 {
+		close(s.started)
+	}
+	<-s.release
+	return s.Storage.CreateLease(ctx, key, leaseTTL, data)
+}
+
+func TestApplication_CoalescedAcquisitions(t *testing.T) {
+	ctx := context.Background()
+	storageConnection := &blockingStorage{
+		Storage: mock.New(),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	app := New(ctx, createTestConfig(), storageConnection, cache.NewLeaseCache(1000))
+	lease := leasemanagement.Lease{Key: "coalesced-key", Value: "value"}
+
+	type result struct {
+		status string
+		id     int64
+		err    error
+	}
+
+	numRequests := 10
+	results := make(chan result, numRequests)
+	acquire := func() {
+		status, id, err := app.CreateLease(ctx, time.Minute, lease)
+		results <- result{status, id, err}
+	}
+
+	go acquire()
+	<-storageConnection.started
+	for i := 1; i < numRequests; i++ {
+		go acquire()
+	}
+
+	// Give the followers time to join the in-flight acquisition.
+	time.Sleep(50 * time.Millisecond)
+	close(storageConnection.release)
+
+	statuses := map[string]int{}
+	ids := map[int64]bool{}
+	for i := 0; i < numRequests; i++ {
+		r := <-results
+		assert.NoError(t, r.err)
+		statuses[r.status]++
+		ids[r.id] = true
+	}
+
+	assert.Equal(t, int32(1), storageConnection.creates.Load())
+	assert.Equal(t, map[string]int{storage.StatusCreated: 1, storage.StatusAccepted: numRequests - 1}, statuses)
+	assert.Len(t, ids, 1)
+}
+
+func TestApplication_CoalescedAcquisitionCanceled(t *testing.T) {
+	storageConnection := &blockingStorage{
+		Storage: mock.New(),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	defer close(storageConnection.release)
+
+	app := New(context.Background(), createTestConfig(), storageConnection, cache.NewLeaseCache(1000))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-storageConnection.started
+		cancel()
+	}()
+
+	_, _, err := app.CreateLease(ctx, time.Minute, leasemanagement.Lease{Key: "canceled-key"})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// countingStorage counts the storage round trips and reports keepTTL as the
+// remaining TTL of kept alive leases.
+type countingStorage struct {
+	*mock.Storage
+	keepTTL    int64
+	presences  atomic.Int32
+	keepalives atomic.Int32
+}
+
+func (s *countingStorage) CheckLeasePresence(ctx context.Context, key string) (int64, error) {
+	s.presences.Add(1)
+	return s.Storage.CheckLeasePresence(ctx, key)
+}
+
+func (s *countingStorage) KeepLeaseOnce(ctx context.Context, leaseID int64) (int64, error) {
+	s.keepalives.Add(1)
+	if _, err := s.Storage.KeepLeaseOnce(ctx, leaseID); err != nil {
+		return 0, err
+	}
+	return s.keepTTL, nil
+}
+
+func TestApplication_DeadLeaseNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	storageConnection := &countingStorage{Storage: mock.New()}
+	app := New(ctx, createTestConfig(), storageConnection, cache.NewLeaseCache(1000))
+
+	// The mock storage reports lease 999 as expired.
+	assert.ErrorIs(t, app.ReviveLease(ctx, 999), storage.ErrLeaseNotFound)
+	assert.ErrorIs(t, app.ReviveLease(ctx, 999), storage.ErrLeaseNotFound)
+	assert.Equal(t, int32(1), storageConnection.keepalives.Load())
+
+	cfg := *app.Config()
+	cfg.Cache.NegativeTTL = 0
+	app.ApplyConfig(&cfg)
+	app.markLeaseDead(998)
+	assert.False(t, app.leaseKnownDead(998), "a zero negative TTL disables negative caching")
+}
+
+func TestApplication_ApplyConfigNamespaces(t *testing.T) {
+	app := New(context.Background(), createTestConfig(), mock.New(), nil)
+	assert.Equal(t, metrics.NamespaceOther, app.namespaces.Label("team-new"))
+
+	cfg := *app.Config()
+	cfg.Namespaces.Limits = map[string]config.NamespaceLimits{"team-new": {MaxLocks: 10}}
+	app.ApplyConfig(&cfg)
+
+	assert.Equal(t, "team-new", app.namespaces.Label("team-new"), "a namespace added by a reload gets its own series")
+}
+
+func TestApplication_KeepaliveRefreshesCache(t *testing.T) {
+	ctx := context.Background()
+	storageConnection := &countingStorage{Storage: mock.New(), keepTTL: 60}
+	app := New(ctx, createTestConfig(), storageConnection, cache.NewLeaseCache(1000))
+	app.cacheWatched.Store(true)
+
+	lease := leasemanagement.Lease{Key: "kept-key"}
+	_, leaseID, err := app.CreateLease(ctx, time.Second, lease)
+	require.NoError(t, err)
+
+	require.NoError(t, app.ReviveLease(ctx, leaseID))
+	time.Sleep(1100 * time.Millisecond)
+
+	assert.Equal(t, leaseID, app.checkLeasePresenceInCache(lease.Key), "keepalive must extend the cache entry to the remaining TTL")
+}
+
+func TestApplication_ReleasedKeyKnownFree(t *testing.T) {
+	ctx := context.Background()
+	storageConnection := &countingStorage{Storage: mock.New()}
+	app := New(ctx, createTestConfig(), storageConnection, cache.NewLeaseCache(1000))
+
+	lease := leasemanagement.Lease{Key: "free-key"}
+	status, leaseID, err := app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status)
+	assert.Equal(t, int32(1), storageConnection.presences.Load())
+
+	require.NoError(t, app.ReleaseLease(ctx, lease.Key, leaseID))
+	assert.True(t, app.keyKnownFree(lease.Key))
+	assert.True(t, app.leaseKnownDead(leaseID))
+
+	status, _, err = app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status)
+	assert.Equal(t, int32(1), storageConnection.presences.Load(), "a key known to be free must not be checked for presence")
+}
+
+// histogram returns the sample count and sum of a histogram series.
+func histogram(t *testing.T, observer prometheus.Observer) (uint64, float64) {
+	t.Helper()
+
+	var metric dto.Metric
+	require.NoError(t, observer.(prometheus.Metric).Write(&metric))
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestApplication_ContentionMetrics(t *testing.T) {
+	storageConnection := mock.New()
+	app := New(context.Background(), createTestConfig(), storageConnection, nil)
+	defer app.Close()
+
+	holder := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "holder"})
+	waiter := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "waiter"})
+	lease := leasemanagement.Lease{Key: "contention/job"}
+	waits := metrics.LockWaitDuration.WithLabelValues("", "contention")
+	holds := metrics.LockHoldDuration.WithLabelValues("", "contention")
+
+	status, leaseID, err := app.CreateLease(holder, time.Minute, lease)
+	require.NoError(t, err)
+	require.Equal(t, storage.StatusCreated, status)
+	waitCount, _ := histogram(t, waits)
+	assert.Equal(t, uint64(1), waitCount, "an uncontended lock is observed as not waited for")
+
+	// The mock storage never reports a key as held to the presence check,
+	// so the second request loses the race for it in storage.
+	status, _, err = app.CreateLease(waiter, time.Minute, lease)
+	require.NoError(t, err)
+	require.Equal(t, storage.StatusAccepted, status)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.LeaseRacesLost.WithLabelValues("", "contention")))
+
+	require.NoError(t, app.reportActiveLocks(context.Background()))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ActiveLocks.WithLabelValues("", "contention")))
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, app.ReleaseLease(holder, lease.Key, leaseID))
+	holdCount, _ := histogram(t, holds)
+	assert.Equal(t, uint64(1), holdCount)
+
+	status, _, err = app.CreateLease(waiter, time.Minute, lease)
+	require.NoError(t, err)
+	require.Equal(t, storage.StatusCreated, status)
+	waitCount, waited := histogram(t, waits)
+	assert.Equal(t, uint64(2), waitCount)
+	assert.GreaterOrEqual(t, waited, (20 * time.Millisecond).Seconds())
+
+	// Other tests expire leases of unknown keys as well.
+	expiredUnknown := metrics.LeasesExpired.WithLabelValues("", metrics.PrefixUnknown)
+	before := testutil.ToFloat64(expiredUnknown)
+	assert.ErrorIs(t, app.ReleaseLease(waiter, lease.Key, leaseID+1), storage.ErrLeaseNotFound)
+	assert.ErrorIs(t, app.ReviveLease(context.Background(), 999), storage.ErrLeaseNotFound)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.LeasesExpired.WithLabelValues("", "contention")))
+	assert.Equal(t, before+1, testutil.ToFloat64(expiredUnknown))
+}
+
+func TestApplication_AuditLog(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Authz = config.AuthzCfg{
+		Enabled: true,
+		Policies: []config.PolicyCfg{
+			{Name: "holder", Principals: []string{"holder"}, Prefixes: []string{"audit/"}, Operations: []string{"*"}},
+		},
+	}
+	cfg.Audit.KeepaliveSampleRatio = 0
+	var out bytes.Buffer
+	app := New(context.Background(), cfg, mock.New(), nil)
+	app.SetAuditLog(audit.New(16, &out))
+
+	holder := audit.WithClientAddress(auth.WithPrincipal(context.Background(), &auth.Principal{Name: "holder"}), "10.0.0.1")
+	intruder := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "intruder"})
+	lease := leasemanagement.Lease{Key: "audit/job", Labels: map[string]string{"owner": "worker-1"}}
+
+	_, leaseID, err := app.CreateLease(holder, time.Minute, lease)
+	require.NoError(t, err)
+	require.NoError(t, app.ReviveLease(holder, leaseID))
+	_, _, err = app.CreateLease(intruder, time.Minute, lease)
+	require.Error(t, err)
+	require.NoError(t, app.ReleaseLease(holder, lease.Key, leaseID))
+	app.Close()
+
+	var events []audit.Event
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var event audit.Event
+		require.NoError(t, decoder.Decode(&event))
+		events = append(events, event)
+	}
+
+	require.Len(t, events, 3, "successful keepalives are not sampled")
+	assert.Equal(t, audit.EventAcquire, events[0].Type)
+	assert.Equal(t, storage.StatusCreated, events[0].Outcome)
+	assert.Equal(t, leaseID, events[0].LeaseID)
+	assert.Equal(t, "holder", events[0].Principal)
+	assert.Equal(t, "10.0.0.1", events[0].ClientAddress)
+	assert.Equal(t, lease.Labels, events[0].Labels)
+
+	assert.Equal(t, audit.EventDeny, events[1].Type)
+	assert.Equal(t, "intruder", events[1].Principal)
+	assert.Equal(t, "acquire", events[1].Operation)
+	assert.NotEmpty(t, events[1].Reason)
+
+	assert.Equal(t, audit.EventRelease, events[2].Type)
+	assert.Equal(t, "success", events[2].Outcome)
+	assert.Equal(t, lease.Labels, events[2].Labels)
+}
+
+func TestApplication_LeaseHistory(t *testing.T) {
+	app := New(context.Background(), createTestConfig(), mock.New(), nil)
+
+	_, err := app.LeaseHistory(context.Background(), "nightly-billing", time.Time{}, time.Time{}, 0)
+	assert.ErrorIs(t, err, ErrHistoryDisabled)
+
+	storageConnection := mock.New()
+	app = New(context.Background(), createTestConfig(), storageConnection, nil)
+	app.SetHistory(storageConnection)
+
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "billing"})
+	lease := leasemanagement.Lease{Key: "nightly-billing"}
+	_, leaseID, err := app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	_, _, err = app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	require.NoError(t, app.ReleaseLease(ctx, lease.Key, leaseID))
+	assert.ErrorIs(t, app.ReleaseLease(context.Background(), lease.Key, leaseID), storage.ErrLeaseNotFound)
+	app.Close()
+
+	events, err := app.LeaseHistory(ctx, lease.Key, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 3, "only the holder changes are kept")
+	assert.Equal(t, leasemanagement.HistoryGranted, events[0].Type)
+	assert.Equal(t, leaseID, events[0].LeaseID)
+	assert.Equal(t, "billing", events[0].Owner)
+	assert.Equal(t, "billing", events[0].Principal)
+	assert.Equal(t, leasemanagement.HistoryReleased, events[1].Type)
+	assert.Equal(t, "billing", events[1].Owner)
+	assert.Equal(t, leasemanagement.HistoryExpired, events[2].Type)
+	assert.Empty(t, events[2].Principal)
+
+	events, err = app.LeaseHistory(ctx, lease.Key, events[1].Time, time.Time{}, 1)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, leasemanagement.HistoryReleased, events[0].Type)
+}
+
+func TestApplication_PruneHistory(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.History.MaxAge = time.Hour
+	storageConnection := mock.New()
+	app := New(context.Background(), cfg, storageConnection, nil)
+	app.SetHistory(storageConnection)
+	defer app.Close()
+
+	old := leasemanagement.HistoryEvent{Time: time.Now().Add(-2 * time.Hour), Type: leasemanagement.HistoryGranted, LeaseID: 1}
+	recent := leasemanagement.HistoryEvent{Time: time.Now(), Type: leasemanagement.HistoryGranted, LeaseID: 2}
+	require.NoError(t, leasemanagement.RecordHistory(context.Background(), storageConnection, "", "stale", old, storage.HistoryRetention{}))
+	require.NoError(t, leasemanagement.RecordHistory(context.Background(), storageConnection, "", "active", old, storage.HistoryRetention{}))
+	require.NoError(t, leasemanagement.RecordHistory(context.Background(), storageConnection, "", "active", recent, storage.HistoryRetention{}))
+
+	require.NoError(t, app.pruneHistory(context.Background()))
+
+	events, err := app.LeaseHistory(context.Background(), "stale", time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Empty(t, events, "the history of a key no longer locked is pruned too")
+	events, err = app.LeaseHistory(context.Background(), "active", time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, int64(2), events[0].LeaseID)
+}
+
+func TestApplication_AdminOperations(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Authz = config.AuthzCfg{
+		Enabled: true,
+		Policies: []config.PolicyCfg{
+			{Name: "holders", Principals: []string{"holder"}, Prefixes: []string{"*"}, Operations: []string{"*"}},
+			{Name: "oncall", Principals: []string{"oncall"}, Prefixes: []string{"*"}, Operations: []string{"admin"}},
+		},
+	}
+	storageConnection := mock.New()
+	var out bytes.Buffer
+	app := New(context.Background(), cfg, storageConnection, nil)
+	app.SetAuditLog(audit.New(16, &out))
+	app.SetHistory(storageConnection)
+
+	holder := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "holder"})
+	oncall := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "oncall"})
+	lease := leasemanagement.Lease{Key: "nightly-billing", Labels: map[string]string{"job": "billing"}}
+	_, leaseID, err := app.CreateLease(holder, time.Minute, lease)
+	require.NoError(t, err)
+
+	_, err = app.TransferLease(holder, lease.Key, "worker-2")
+	var denied *authz.DeniedError
+	require.ErrorAs(t, err, &denied, "the wildcard does not grant admin")
+
+	newLeaseID, err := app.TransferLease(oncall, lease.Key, "worker-2")
+	require.NoError(t, err)
+	assert.NotEqual(t, leaseID, newLeaseID)
+	details, err := app.InspectLease(holder, lease.Key)
+	require.NoError(t, err)
+	assert.Equal(t, newLeaseID, details.ID)
+	assert.Equal(t, "worker-2", details.Owner)
+	assert.Equal(t, lease.Labels, details.Labels)
+	assert.ErrorIs(t, app.ReleaseLease(holder, lease.Key, leaseID), storage.ErrLeaseNotFound)
+
+	released, err := app.ForceRelease(oncall, lease.Key)
+	require.NoError(t, err)
+	assert.Equal(t, newLeaseID, released)
+	_, err = app.ForceRelease(oncall, lease.Key)
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound)
+	app.Close()
+
+	var events []audit.Event
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var event audit.Event
+		require.NoError(t, decoder.Decode(&event))
+		events = append(events, event)
+	}
+	var transfer, forceRelease *audit.Event
+	for i := range events {
+		switch events[i].Type {
+		case audit.EventTransfer:
+			transfer = &events[i]
+		case audit.EventForceRelease:
+			if forceRelease == nil {
+				forceRelease = &events[i]
+			}
+		}
+	}
+	require.NotNil(t, transfer)
+	assert.Equal(t, "success", transfer.Outcome)
+	assert.Equal(t, "oncall", transfer.Principal)
+	assert.Equal(t, "worker-2", transfer.Owner)
+	assert.Equal(t, newLeaseID, transfer.LeaseID)
+	require.NotNil(t, forceRelease)
+	assert.Equal(t, "success", forceRelease.Outcome)
+	assert.Equal(t, newLeaseID, forceRelease.LeaseID)
+
+	history, err := app.LeaseHistory(holder, lease.Key, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	var types []string
+	for _, event := range history {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{leasemanagement.HistoryGranted, leasemanagement.HistoryTransferred, leasemanagement.HistoryExpired, leasemanagement.HistoryReleased}, types)
+}
+
+func TestApplication_IdempotencyKey(t *testing.T) {
+	storageConnection := mock.New()
+	app := New(context.Background(), createTestConfig(), storageConnection, nil)
+	app.SetHistory(storageConnection)
+
+	ctx := WithIdempotencyKey(auth.WithPrincipal(context.Background(), &auth.Principal{Name: "billing"}), "run-42")
+	lease := leasemanagement.Lease{Key: "nightly-billing"}
+
+	status, leaseID, err := app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status)
+
+	status, replayedLeaseID, err := app.CreateLease(ctx, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status, "a retry gets the original answer")
+	assert.Equal(t, leaseID, replayedLeaseID)
+
+	status, _, err = app.CreateLease(auth.WithPrincipal(context.Background(), &auth.Principal{Name: "billing"}), time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusAccepted, status)
+
+	other := WithIdempotencyKey(auth.WithPrincipal(context.Background(), &auth.Principal{Name: "reports"}), "run-42")
+	status, _, err = app.CreateLease(other, time.Minute, lease)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusAccepted, status, "idempotency keys are kept per owner")
+
+	_, _, err = app.CreateLease(ctx, time.Minute, leasemanagement.Lease{Key: "nightly-reports"})
+	assert.ErrorIs(t, err, ErrIdempotencyKeyReused)
+	app.Close()
+
+	events, err := app.LeaseHistory(ctx, lease.Key, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Len(t, events, 1, "a replay is not a new grant")
+}
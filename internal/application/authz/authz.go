@@ -0,0 +1,132 @@
+// Package authz decides whether a principal may perform a lease operation on
+// a key, based on the prefix policies from the configuration.
+package authz
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+)
+
+type Operation string
+
+const (
+	OperationAcquire   Operation = "acquire"
+	OperationKeepalive Operation = "keepalive"
+	OperationRelease   Operation = "release"
+	OperationInspect   Operation = "inspect"
+	OperationList      Operation = "list"
+	// OperationAdmin covers releasing and transferring locks held by others.
+	OperationAdmin Operation = "admin"
+)
+
+const (
+	wildcard          = "*"
+	effectDeny        = "deny"
+	DefaultDenyPolicy = "default-deny"
+	// DisabledPolicy denies admin operations while authorization is
+	// disabled, since nothing could grant them.
+	DisabledPolicy = "authz-disabled"
+)
+
+// DeniedError identifies the policy that denied an operation. Rule is
+// DefaultDenyPolicy when no policy allowed it.
+type DeniedError struct {
+	Rule      string    `json:"rule"`
+	Principal string    `json:"principal"`
+	Operation Operation `json:"operation"`
+	Key       string    `json:"key,omitempty"`
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%v is not allowed to %v %q (rule %v)", e.Principal, e.Operation, e.Key, e.Rule)
+}
+
+// Authorize evaluates the policies of cfg for principal performing operation
+// on key. For list operations key is the requested prefix. An empty key means
+// the key is unknown to the caller, and only the operation is checked.
+// A nil principal stands for an unauthenticated caller and only matches
+// policies for the "*" principal. With authorization disabled everything but
+// OperationAdmin is allowed.
+func Authorize(cfg *config.AuthzCfg, principal *auth.Principal, operation Operation, key string) error {
+	name := ""
+	if principal != nil {
+		name = principal.Name
+	}
+
+	if !cfg.Enabled {
+		if operation == OperationAdmin {
+			return &DeniedError{Rule: DisabledPolicy, Principal: name, Operation: operation, Key: key}
+		}
+		return nil
+	}
+
+	allowed := false
+	for _, policy := range cfg.Policies {
+		if !matchesPrincipal(policy, principal) ||
+			!matchesOperation(policy, operation) ||
+			!matchesKey(policy, key) {
+			continue
+		}
+		// With the key unknown, only deny policies covering every key apply.
+		if key == "" && policy.Effect == effectDeny && !slices.Contains(policy.Prefixes, wildcard) {
+			continue
+		}
+
+		if policy.Effect == effectDeny {
+			return &DeniedError{Rule: policy.Name, Principal: name, Operation: operation, Key: key}
+		}
+		allowed = true
+	}
+
+	if !allowed {
+		return &DeniedError{Rule: DefaultDenyPolicy, Principal: name, Operation: operation, Key: key}
+	}
+
+	return nil
+}
+
+func matchesPrincipal(policy config.PolicyCfg, principal *auth.Principal) bool {
+	if slices.Contains(policy.Principals, wildcard) {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	if slices.Contains(policy.Principals, principal.Name) {
+		return true
+	}
+	for _, group := range principal.Groups {
+		if slices.Contains(policy.Groups, group) {
+			return true
+		}
+	}
+
+	return slices.Contains(policy.Groups, wildcard)
+}
+
+// matchesOperation reports whether policy lists operation. The wildcard
+// leaves out OperationAdmin, which has to be granted by name.
+func matchesOperation(policy config.PolicyCfg, operation Operation) bool {
+	if slices.Contains(policy.Operations, string(operation)) {
+		return true
+	}
+
+	return operation != OperationAdmin && slices.Contains(policy.Operations, wildcard)
+}
+
+func matchesKey(policy config.PolicyCfg, key string) bool {
+	if key == "" {
+		return true
+	}
+	for _, prefix := range policy.Prefixes {
+		if prefix == wildcard || strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+)
+
+func TestAuthorize(t *testing.T) {
+	cfg := &config.AuthzCfg{
+		Enabled: true,
+		Policies: []config.PolicyCfg{
+			{Name: "team-a", Groups: []string{"team-a"}, Prefixes: []string{"teamA/"}, Operations: []string{"*"}},
+			{Name: "ops-read", Principals: []string{"oncall"}, Prefixes: []string{"*"}, Operations: []string{"inspect", "list"}},
+			{Name: "no-prod-release", Effect: "deny", Groups: []string{"team-a"}, Prefixes: []string{"teamA/prod/"}, Operations: []string{"release"}},
+			{Name: "public", Principals: []string{"*"}, Prefixes: []string{"public/"}, Operations: []string{"acquire", "keepalive"}},
+		},
+	}
+
+	teamA := &auth.Principal{Name: "ci", Groups: []string{"team-a"}}
+	oncall := &auth.Principal{Name: "oncall"}
+
+	tests := []struct {
+		name         string
+		principal    *auth.Principal
+		operation    Operation
+		key          string
+		expectedRule string
+	}{
+		{name: "group allowed on own prefix", principal: teamA, operation: OperationAcquire, key: "teamA/migrations"},
+		{name: "group denied outside prefix", principal: teamA, operation: OperationAcquire, key: "teamB/migrations", expectedRule: DefaultDenyPolicy},
+		{name: "deny policy wins", principal: teamA, operation: OperationRelease, key: "teamA/prod/db", expectedRule: "no-prod-release"},
+		{name: "deny policy scoped to prefix", principal: teamA, operation: OperationRelease, key: "teamA/staging/db"},
+		{name: "operation not granted", principal: oncall, operation: OperationAcquire, key: "teamA/migrations", expectedRule: DefaultDenyPolicy},
+		{name: "wildcard operation excludes admin", principal: teamA, operation: OperationAdmin, key: "teamA/migrations", expectedRule: DefaultDenyPolicy},
+		{name: "wildcard prefix", principal: oncall, operation: OperationInspect, key: "teamB/anything"},
+		{name: "wildcard principal", principal: &auth.Principal{Name: "anyone"}, operation: OperationAcquire, key: "public/x"},
+		{name: "unauthenticated caller matches wildcard", operation: OperationAcquire, key: "public/x"},
+		{name: "unauthenticated caller denied elsewhere", operation: OperationAcquire, key: "teamA/x", expectedRule: DefaultDenyPolicy},
+		{name: "unknown key checks operation only", principal: teamA, operation: OperationKeepalive},
+		{name: "unknown key ignores prefix-scoped deny", principal: teamA, operation: OperationRelease},
+		{name: "unknown key still needs the operation", principal: oncall, operation: OperationRelease, expectedRule: DefaultDenyPolicy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Authorize(cfg, tt.principal, tt.operation, tt.key)
+
+			if tt.expectedRule == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var denied *DeniedError
+			require.True(t, errors.As(err, &denied))
+			assert.Equal(t, tt.expectedRule, denied.Rule)
+			assert.Equal(t, tt.operation, denied.Operation)
+		})
+	}
+}
+
+func TestAuthorize_Disabled(t *testing.T) {
+	assert.NoError(t, Authorize(&config.AuthzCfg{}, nil, OperationRelease, "anything"))
+
+	var denied *DeniedError
+	require.ErrorAs(t, Authorize(&config.AuthzCfg{}, nil, OperationAdmin, "anything"), &denied)
+	assert.Equal(t, DisabledPolicy, denied.Rule, "admin needs an explicit grant")
+}
@@ -0,0 +1,231 @@
+package leasemanagement
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+)
+
+// electionPrefix returns the storage prefix of the candidates of the election
+// name in namespace.
+func electionPrefix(namespace, name string) string {
+	return ElectionPrefix + namespace + "/" + url.PathEscape(name) + "/"
+}
+
+// Campaign registers a candidate of owner storing value in the election name
+// under a lease of leaseTTL, and returns the ID of the lease. The candidate
+// stays in the election as long as the lease is kept alive.
+func Campaign(ctx context.Context, storageConnection storage.Storage, namespace, name string, leaseTTL time.Duration, owner, value string) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.Campaign",
+		tracing.NamespaceAttribute.String(namespace), tracing.KeyAttribute.String(name))
+	defer func() { tracing.End(span, err) }()
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, fmt.Errorf("failed to generate candidate key: %v", err)
+	}
+	data, err := json.Marshal(candidateRecord{
+		Candidate:  Candidate{Value: value, Owner: owner, CreatedAt: time.Now().UTC()},
+		GrantedTTL: int64(leaseTTL.Seconds()),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode candidate: %v", err)
+	}
+
+	status, leaseID, err := storageConnection.CreateLease(ctx, electionPrefix(namespace, name)+hex.EncodeToString(suffix), int64(leaseTTL.Seconds()), data)
+	if err != nil {
+		return 0, err
+	}
+	if status != storage.StatusCreated {
+		return 0, fmt.Errorf("candidate key of election %v is taken", name)
+	}
+
+	return leaseID, nil
+}
+
+// Candidates returns the candidates of the election name, the leader first:
+// candidates are ordered by the time they joined.
+func Candidates(ctx context.Context, storageConnection storage.Storage, namespace, name string) (_ []Candidate, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.Candidates",
+		tracing.NamespaceAttribute.String(namespace), tracing.KeyAttribute.String(name))
+	defer func() { tracing.End(span, err) }()
+
+	infos, err := storageConnection.ListKeys(ctx, electionPrefix(namespace, name))
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(infos, func(a, b storage.LeaseInfo) int {
+		return cmp.Compare(a.CreateRevision, b.CreateRevision)
+	})
+
+	candidates := make([]Candidate, 0, len(infos))
+	for _, info := range infos {
+		var candidate Candidate
+		if err := json.Unmarshal(info.Value, &candidate); err != nil {
+			candidate.Value = string(info.Value)
+		}
+		candidate.ID = info.LeaseID
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// ScanCandidates returns the candidates of every election in every namespace.
+func ScanCandidates(ctx context.Context, storageConnection storage.Storage) (_ []CandidateDetails, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.ScanCandidates")
+	defer func() { tracing.End(span, err) }()
+
+	infos, err := storageConnection.ListKeys(ctx, ElectionPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]CandidateDetails, 0, len(infos))
+	for _, info := range infos {
+		ns, _, found := strings.Cut(strings.TrimPrefix(info.Key, ElectionPrefix), "/")
+		if !found {
+			continue
+		}
+		var record candidateRecord
+		if err := json.Unmarshal(info.Value, &record); err != nil {
+			record.Value = string(info.Value)
+		}
+		record.ID = info.LeaseID
+		candidates = append(candidates, CandidateDetails{Candidate: record.Candidate, Namespace: ns, GrantedTTL: record.GrantedTTL})
+	}
+
+	return candidates, nil
+}
+
+// Resign removes the candidate of leaseID from the election name and revokes
+// its lease, so that the next candidate leads at once. It returns the
+// candidate removed, or storage.ErrLeaseNotFound when leaseID is not a
+// candidate.
+func Resign(ctx context.Context, storageConnection storage.Storage, namespace, name string, leaseID int64) (_ *CandidateDetails, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.Resign", tracing.NamespaceAttribute.String(namespace),
+		tracing.KeyAttribute.String(name), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	keys, err := storageConnection.LeaseKeys(ctx, leaseID)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, electionPrefix(namespace, name)) {
+			continue
+		}
+
+		// The owner and the granted TTL are only known from the stored record.
+		resigned := &CandidateDetails{Candidate: Candidate{ID: leaseID}, Namespace: namespace}
+		if info, err := storageConnection.GetLease(ctx, key); err == nil && info != nil {
+			var record candidateRecord
+			if json.Unmarshal(info.Value, &record) == nil {
+				resigned.Candidate = record.Candidate
+				resigned.ID = leaseID
+				resigned.GrantedTTL = record.GrantedTTL
+			}
+		}
+		if err := storageConnection.RevokeLease(ctx, key, leaseID); err != nil {
+			return nil, err
+		}
+
+		return resigned, nil
+	}
+
+	return nil, storage.ErrLeaseNotFound
+}
+
+// CandidateElection returns the election leaseID is a candidate of in
+// namespace, or storage.ErrLeaseNotFound.
+func CandidateElection(ctx context.Context, storageConnection storage.Storage, namespace string, leaseID int64) (string, error) {
+	keys, err := storageConnection.LeaseKeys(ctx, leaseID)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := ElectionPrefix + namespace + "/"
+	for _, key := range keys {
+		if rest, ok := strings.CutPrefix(key, prefix); ok {
+			escaped, _, _ := strings.Cut(rest, "/")
+			if name, err := url.PathUnescape(escaped); err == nil {
+				return name, nil
+			}
+		}
+	}
+
+	return "", storage.ErrLeaseNotFound
+}
+
+// ObserveLeader streams the leader of the election name, starting with the
+// current one and then on every change, until ctx is done or the watch
+// breaks.
+func ObserveLeader(ctx context.Context, storageConnection storage.Storage, namespace, name string) (<-chan LeaderEvent, error) {
+	changes, err := storageConnection.WatchLeases(ctx, electionPrefix(namespace, name))
+	if err != nil {
+		return nil, err
+	}
+	leader, err := currentLeader(ctx, storageConnection, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan LeaderEvent)
+	go func() {
+		defer close(events)
+
+		for {
+			select {
+			case events <- LeaderEvent{Leader: leader}:
+			case <-ctx.Done():
+				return
+			}
+
+			for {
+				if _, ok := <-changes; !ok {
+					return
+				}
+				next, err := currentLeader(ctx, storageConnection, namespace, name)
+				if err != nil {
+					log.Warnf("Failed to look up the leader of election %v: %v", name, err)
+					return
+				}
+				if leaderID(next) != leaderID(leader) {
+					leader = next
+					break
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func currentLeader(ctx context.Context, storageConnection storage.Storage, namespace, name string) (*Candidate, error) {
+	candidates, err := Candidates(ctx, storageConnection, namespace, name)
+	if err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+
+	return &candidates[0], nil
+}
+
+func leaderID(leader *Candidate) int64 {
+	if leader == nil {
+		return 0
+	}
+
+	return leader.ID
+}
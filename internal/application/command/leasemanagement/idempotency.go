@@ -0,0 +1,60 @@
+package leasemanagement
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+)
+
+// Acquisition is the outcome of a lease request remembered under its
+// idempotency key.
+type Acquisition struct {
+	Key     string `json:"key"`
+	LeaseID int64  `json:"-"`
+}
+
+// idempotencyKey returns the storage key of the idempotency key of owner in
+// namespace, so that callers cannot replay each other's requests.
+func idempotencyKey(namespace, owner, idempotencyKey string) string {
+	return IdempotencyPrefix + namespace + "/" + url.PathEscape(owner) + "/" + url.PathEscape(idempotencyKey)
+}
+
+// RememberAcquisition stores that leaseID was granted key for the request of
+// owner with idempotencyKey. The record lives as long as the lease.
+func RememberAcquisition(ctx context.Context, storageConnection storage.Storage, namespace, owner, idempotency string, acquisition Acquisition) (err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.RememberAcquisition", tracing.NamespaceAttribute.String(namespace),
+		tracing.KeyAttribute.String(acquisition.Key), tracing.LeaseIDAttribute.Int64(acquisition.LeaseID))
+	defer func() { tracing.End(span, err) }()
+
+	data, err := json.Marshal(acquisition)
+	if err != nil {
+		return fmt.Errorf("failed to encode acquisition: %v", err)
+	}
+
+	return storageConnection.AttachToLease(ctx, idempotencyKey(namespace, owner, idempotency), acquisition.LeaseID, data)
+}
+
+// RememberedAcquisition returns the acquisition remembered for the request of
+// owner with idempotencyKey, or storage.ErrLeaseNotFound once its lease is
+// gone.
+func RememberedAcquisition(ctx context.Context, storageConnection storage.Storage, namespace, owner, idempotency string) (_ *Acquisition, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.RememberedAcquisition", tracing.NamespaceAttribute.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
+	info, err := storageConnection.GetLease(ctx, idempotencyKey(namespace, owner, idempotency))
+	if err != nil {
+		return nil, err
+	}
+
+	var acquisition Acquisition
+	if err := json.Unmarshal(info.Value, &acquisition); err != nil {
+		return nil, fmt.Errorf("failed to decode acquisition: %v", err)
+	}
+	acquisition.LeaseID = info.LeaseID
+
+	return &acquisition, nil
+}
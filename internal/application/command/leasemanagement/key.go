@@ -0,0 +1,51 @@
+package leasemanagement
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxKeyLength is the longest key a lock can be taken on, in bytes.
+const MaxKeyLength = 512
+
+// ErrInvalidKey is returned for keys a lock cannot be taken on.
+var ErrInvalidKey = errors.New("invalid key")
+
+// ValidateKey checks that key is a non-empty path of segments separated by
+// "/" made of letters, digits and "-", "_", ".", ":", "@", "=", "+", ",",
+// "~". Empty, "." and ".." segments are refused so that a key never reads as
+// a path leaving its prefix.
+func ValidateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: key must not be empty", ErrInvalidKey)
+	}
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("%w: key must not be longer than %v bytes", ErrInvalidKey, MaxKeyLength)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		switch segment {
+		case "":
+			return fmt.Errorf("%w: key must not start or end with \"/\" or contain \"//\"", ErrInvalidKey)
+		case ".", "..":
+			return fmt.Errorf("%w: key must not contain %q segments", ErrInvalidKey, segment)
+		}
+		for _, r := range segment {
+			if !validKeyRune(r) {
+				return fmt.Errorf("%w: key must not contain %q", ErrInvalidKey, r)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validKeyRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	default:
+		return strings.ContainsRune("-_.:@=+,~", r)
+	}
+}
@@ -1,6 +1,8 @@
 package leasemanagement
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -8,5 +10,112 @@ type Lease struct {
 	Key       string            `json:"key"`
 	Value     string            `json:"value"`
 	Labels    map[string]string `json:"labels"`
+	Owner     string            `json:"owner,omitempty"`
 	CreatedAt time.Time         `json:"timestamp"`
 }
+
+// LeaseDetails is a held lease as reported by inspect and list operations.
+type LeaseDetails struct {
+	Lease
+	ID int64 `json:"id"`
+	// TTL is the remaining lifetime of the lease in seconds.
+	TTL int64 `json:"ttl"`
+	// GrantedTTL is the lifetime in seconds the lease was granted with.
+	GrantedTTL int64 `json:"granted_ttl"`
+}
+
+// leaseRecord is the value stored for a held key. GrantedTTL is kept so that
+// listings can count lease seconds without looking up every lease.
+type leaseRecord struct {
+	Lease
+	GrantedTTL int64 `json:"granted_ttl,omitempty"`
+}
+
+// encodeLease returns the record stored for lease granted for leaseTTL.
+func encodeLease(lease Lease, leaseTTL time.Duration) ([]byte, error) {
+	record, err := json.Marshal(leaseRecord{Lease: lease, GrantedTTL: int64(leaseTTL.Seconds())})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lease record: %v", err)
+	}
+
+	return record, nil
+}
+
+// decodeLease reads a stored lease record. Values written before records
+// were JSON encoded are returned as the plain lease value. Such a value may be
+// JSON itself, so only objects with the key and timestamp every record has
+// are taken for records.
+func decodeLease(key string, data []byte) leaseRecord {
+	var fields map[string]json.RawMessage
+	var record leaseRecord
+	if json.Unmarshal(data, &fields) != nil || fields["key"] == nil || fields["timestamp"] == nil ||
+		json.Unmarshal(data, &record) != nil {
+		return leaseRecord{Lease: Lease{Key: key, Value: string(data)}}
+	}
+	record.Key = key
+
+	return record
+}
+
+// History event types.
+const (
+	HistoryGranted  = "granted"
+	HistoryReleased = "released"
+	HistoryExpired  = "expired"
+	// HistoryTransferred is a grant to a new owner by an administrator
+	// without the key being free in between.
+	HistoryTransferred = "transferred"
+)
+
+// HistoryEvent is a change of the holder of a key kept in its history.
+type HistoryEvent struct {
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"event"`
+	LeaseID   int64             `json:"lease_id"`
+	Owner     string            `json:"owner,omitempty"`
+	Principal string            `json:"principal,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// Watch event types.
+const (
+	WatchAcquired = "acquired"
+	WatchReleased = "released"
+)
+
+// WatchEvent is a change to a held key reported to watchers. Released keys
+// were either released or their lease expired.
+type WatchEvent struct {
+	Type    string `json:"type"`
+	Key     string `json:"key"`
+	LeaseID int64  `json:"lease_id,omitempty"`
+}
+
+// Candidate is a participant of a leader election.
+type Candidate struct {
+	ID        int64     `json:"id"`
+	Value     string    `json:"value"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"timestamp"`
+}
+
+// candidateRecord is the value stored for a candidate. GrantedTTL is kept for
+// the same reason as in leaseRecord.
+type candidateRecord struct {
+	Candidate
+	GrantedTTL int64 `json:"granted_ttl,omitempty"`
+}
+
+// CandidateDetails is a candidate of any election with the namespace it
+// campaigns in and the lifetime in seconds its lease was granted with.
+type CandidateDetails struct {
+	Candidate
+	Namespace  string `json:"namespace"`
+	GrantedTTL int64  `json:"granted_ttl"`
+}
+
+// LeaderEvent reports the leader of an election to observers. Leader is nil
+// while the election has no candidate.
+type LeaderEvent struct {
+	Leader *Candidate `json:"leader"`
+}
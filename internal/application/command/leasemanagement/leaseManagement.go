@@ -2,36 +2,85 @@ package leasemanagement
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
 )
 
 const (
 	DefaultPrefix = "/shared-lock/"
+	// HistoryPrefix is kept apart from DefaultPrefix so that histories are
+	// not listed or watched as leases.
+	HistoryPrefix = "/shared-lock-history/"
+	// ElectionPrefix and IdempotencyPrefix are kept apart from DefaultPrefix
+	// for the same reason.
+	ElectionPrefix    = "/shared-lock-election/"
+	IdempotencyPrefix = "/shared-lock-idempotency/"
 )
 
-func CreateLease(ctx context.Context, storageConnection storage.Storage, leaseTTL time.Duration, lease Lease) (string, int64, error) {
-	var err error
-	var leaseID int64
-	var leaseStatus string
+// Prefix returns the storage prefix of the keys in namespace. The empty
+// namespace, used when namespaces are disabled, is the flat DefaultPrefix.
+func Prefix(namespace string) string {
+	if namespace == "" {
+		return DefaultPrefix
+	}
+
+	return DefaultPrefix + namespace + "/"
+}
+
+// historyKey returns the storage key of the history of key in namespace. The
+// key is escaped so that the history of a key does not contain the histories
+// of the keys below it.
+func historyKey(namespace, key string) string {
+	return HistoryPrefix + namespace + "/" + url.PathEscape(key)
+}
+
+func CreateLease(ctx context.Context, storageConnection storage.Storage, namespace string, leaseTTL time.Duration, lease Lease) (_ string, _ int64, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.CreateLease",
+		tracing.NamespaceAttribute.String(namespace), tracing.KeyAttribute.String(lease.Key))
+	defer func() { tracing.End(span, err) }()
 
-	key := DefaultPrefix + lease.Key
+	key := Prefix(namespace) + lease.Key
 
 	log.Debugf("Checking lease presence for the key: %v", key)
-	leaseID, err = storageConnection.CheckLeasePresence(ctx, key)
+	leaseID, err := storageConnection.CheckLeasePresence(ctx, key)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to check lease presence: %v", err)
+		return "", 0, fmt.Errorf("failed to check lease presence: %w", err)
 	}
 	if leaseID != 0 {
 		return "accepted", leaseID, nil
 	}
 
+	return ClaimLease(ctx, storageConnection, namespace, leaseTTL, lease)
+}
+
+// ClaimLease creates the lease without checking first whether the key is
+// held. It is cheaper than CreateLease for keys that are expected to be free;
+// a held key is still reported as accepted with the ID of its lease.
+func ClaimLease(ctx context.Context, storageConnection storage.Storage, namespace string, leaseTTL time.Duration, lease Lease) (leaseStatus string, leaseID int64, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.ClaimLease",
+		tracing.NamespaceAttribute.String(namespace), tracing.KeyAttribute.String(lease.Key))
+	defer func() { tracing.End(span, err) }()
+
+	key := Prefix(namespace) + lease.Key
+
+	if lease.CreatedAt.IsZero() {
+		lease.CreatedAt = time.Now().UTC()
+	}
+	record, err := encodeLease(lease, leaseTTL)
+	if err != nil {
+		return "", 0, err
+	}
+
 	log.Debugf("Creating lease for the key: %v", key)
-	leaseStatus, leaseID, err = storageConnection.CreateLease(ctx, key, int64(leaseTTL.Seconds()), []byte(lease.Value))
+	leaseStatus, leaseID, err = storageConnection.CreateLease(ctx, key, int64(leaseTTL.Seconds()), record)
 	if err != nil {
 		return "", 0, err
 	}
@@ -40,19 +89,222 @@ func CreateLease(ctx context.Context, storageConnection storage.Storage, leaseTT
 	}
 
 	log.Debugf("Prolong lease for the key: %v, with ttl: %v", key, leaseTTL)
-	err = storageConnection.KeepLeaseOnce(ctx, leaseID)
+	_, err = storageConnection.KeepLeaseOnce(ctx, leaseID)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to prolong lease with leaseID: %v, %v", leaseID, err)
+		return "", 0, fmt.Errorf("failed to prolong lease with leaseID: %v, %w", leaseID, err)
 	}
 
 	return leaseStatus, leaseID, nil
 }
 
-func ReviveLease(ctx context.Context, storageConnection storage.Storage, leaseID int64) error {
-	err := storageConnection.KeepLeaseOnce(ctx, leaseID)
+// ReviveLease renews leaseID once and returns its remaining TTL.
+func ReviveLease(ctx context.Context, storageConnection storage.Storage, leaseID int64) (_ time.Duration, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.ReviveLease", tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	ttl, err := storageConnection.KeepLeaseOnce(ctx, leaseID)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(ttl) * time.Second, nil
+}
+
+func ReleaseLease(ctx context.Context, storageConnection storage.Storage, namespace, key string, leaseID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.ReleaseLease", tracing.NamespaceAttribute.String(namespace),
+		tracing.KeyAttribute.String(key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	return storageConnection.RevokeLease(ctx, Prefix(namespace)+key, leaseID)
+}
+
+// TransferLease moves key from leaseID to a new lease of leaseTTL holding
+// lease, and returns the ID of the new lease.
+func TransferLease(ctx context.Context, storageConnection storage.Storage, namespace string, leaseID int64, leaseTTL time.Duration, lease Lease) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.TransferLease", tracing.NamespaceAttribute.String(namespace),
+		tracing.KeyAttribute.String(lease.Key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	record, err := encodeLease(lease, leaseTTL)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	return storageConnection.TransferLease(ctx, Prefix(namespace)+lease.Key, leaseID, int64(leaseTTL.Seconds()), record)
+}
+
+// CheckLease returns the ID of the lease holding key, or 0 if it is free.
+func CheckLease(ctx context.Context, storageConnection storage.Storage, namespace, key string) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.CheckLease",
+		tracing.NamespaceAttribute.String(namespace), tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	return storageConnection.CheckLeasePresence(ctx, Prefix(namespace)+key)
+}
+
+func GetLease(ctx context.Context, storageConnection storage.Storage, namespace, key string) (_ *LeaseDetails, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.GetLease",
+		tracing.NamespaceAttribute.String(namespace), tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	info, err := storageConnection.GetLease(ctx, Prefix(namespace)+key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LeaseDetails{
+		Lease:      decodeLease(key, info.Value).Lease,
+		ID:         info.LeaseID,
+		TTL:        info.TTL,
+		GrantedTTL: info.GrantedTTL,
+	}, nil
+}
+
+// LeaseKey returns the key in namespace held by leaseID. It returns
+// storage.ErrLeaseNotFound when the lease is dead or holds no key in
+// namespace.
+func LeaseKey(ctx context.Context, storageConnection storage.Storage, namespace string, leaseID int64) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.LeaseKey",
+		tracing.NamespaceAttribute.String(namespace), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	keys, err := storageConnection.LeaseKeys(ctx, leaseID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, Prefix(namespace)) {
+			return strings.TrimPrefix(key, Prefix(namespace)), nil
+		}
 	}
 
-	return nil
+	return "", storage.ErrLeaseNotFound
+}
+
+func ListLeases(ctx context.Context, storageConnection storage.Storage, namespace, prefix string) (_ []LeaseDetails, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.ListLeases", tracing.NamespaceAttribute.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
+	infos, err := storageConnection.ListLeases(ctx, Prefix(namespace)+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaseDetails(namespace, infos), nil
+}
+
+// ScanLeases is ListLeases in a single storage request, for counting the
+// held leases. TTL is left zero and GrantedTTL is taken from the lease
+// records; it is zero for records written before it was stored in them.
+func ScanLeases(ctx context.Context, storageConnection storage.Storage, namespace, prefix string) (_ []LeaseDetails, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.ScanLeases", tracing.NamespaceAttribute.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
+	infos, err := storageConnection.ListKeys(ctx, Prefix(namespace)+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaseDetails(namespace, infos), nil
+}
+
+func leaseDetails(namespace string, infos []storage.LeaseInfo) []LeaseDetails {
+	leases := make([]LeaseDetails, 0, len(infos))
+	for _, info := range infos {
+		record := decodeLease(strings.TrimPrefix(info.Key, Prefix(namespace)), info.Value)
+		grantedTTL := info.GrantedTTL
+		if grantedTTL == 0 {
+			grantedTTL = record.GrantedTTL
+		}
+		leases = append(leases, LeaseDetails{
+			Lease:      record.Lease,
+			ID:         info.LeaseID,
+			TTL:        info.TTL,
+			GrantedTTL: grantedTTL,
+		})
+	}
+
+	return leases
+}
+
+// RecordHistory appends event to the history of key in namespace.
+func RecordHistory(ctx context.Context, historyStorage storage.HistoryStorage, namespace, key string, event HistoryEvent, retention storage.HistoryRetention) (err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.RecordHistory",
+		tracing.NamespaceAttribute.String(namespace), tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	record, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode history event: %v", err)
+	}
+
+	return historyStorage.AppendHistory(ctx, historyKey(namespace, key), event.Time, record, retention)
+}
+
+// PruneHistories drops the events that fall out of retention from the
+// histories of the keys in every namespace.
+func PruneHistories(ctx context.Context, historyStorage storage.HistoryStorage, retention storage.HistoryRetention) (err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.PruneHistories")
+	defer func() { tracing.End(span, err) }()
+
+	return historyStorage.PruneHistories(ctx, HistoryPrefix, retention)
+}
+
+// History returns up to limit events of the history of key in namespace
+// between from and to, oldest first.
+func History(ctx context.Context, historyStorage storage.HistoryStorage, namespace, key string, from, to time.Time, limit int) (_ []HistoryEvent, err error) {
+	ctx, span := tracing.Start(ctx, "leasemanagement.History",
+		tracing.NamespaceAttribute.String(namespace), tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	records, err := historyStorage.History(ctx, historyKey(namespace, key), from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]HistoryEvent, 0, len(records))
+	for _, record := range records {
+		var event HistoryEvent
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			log.Warnf("Skipping undecodable history event of %v: %v", key, err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// WatchLeases streams the changes to the keys in namespace starting with
+// prefix until ctx is done or the watch breaks.
+func WatchLeases(ctx context.Context, storageConnection storage.Storage, namespace, prefix string) (<-chan WatchEvent, error) {
+	changes, err := storageConnection.WatchLeases(ctx, Prefix(namespace)+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan WatchEvent)
+	go func() {
+		defer close(events)
+
+		for change := range changes {
+			event := WatchEvent{
+				Type:    WatchAcquired,
+				Key:     strings.TrimPrefix(change.Key, Prefix(namespace)),
+				LeaseID: change.LeaseID,
+			}
+			if change.Deleted {
+				event.Type = WatchReleased
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
 }
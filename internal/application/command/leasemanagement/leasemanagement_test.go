@@ -3,6 +3,7 @@ package leasemanagement
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,7 +14,11 @@ import (
 type MockStorage struct {
 	checkLeasePresenceFunc func(ctx context.Context, key string) (int64, error)
 	createLeaseFunc        func(ctx context.Context, key string, leaseTTL int64, data []byte) (string, int64, error)
-	keepLeaseOnceFunc      func(ctx context.Context, leaseID int64) error
+	keepLeaseOnceFunc      func(ctx context.Context, leaseID int64) (int64, error)
+	revokeLeaseFunc        func(ctx context.Context, key string, leaseID int64) error
+	getLeaseFunc           func(ctx context.Context, key string) (*storage.LeaseInfo, error)
+	listLeasesFunc         func(ctx context.Context, prefix string) ([]storage.LeaseInfo, error)
+	leaseKeysFunc          func(ctx context.Context, leaseID int64) ([]string, error)
 }
 
 func (m *MockStorage) CheckLeasePresence(ctx context.Context, key string) (int64, error) {
@@ -30,13 +35,57 @@ func (m *MockStorage) CreateLease(ctx context.Context, key string, leaseTTL int6
 	return storage.StatusCreated, 123, nil
 }
 
-func (m *MockStorage) KeepLeaseOnce(ctx context.Context, leaseID int64) error {
+func (m *MockStorage) KeepLeaseOnce(ctx context.Context, leaseID int64) (int64, error) {
 	if m.keepLeaseOnceFunc != nil {
 		return m.keepLeaseOnceFunc(ctx, leaseID)
 	}
+	return 0, nil
+}
+
+func (m *MockStorage) RevokeLease(ctx context.Context, key string, leaseID int64) error {
+	if m.revokeLeaseFunc != nil {
+		return m.revokeLeaseFunc(ctx, key, leaseID)
+	}
+	return nil
+}
+
+func (m *MockStorage) TransferLease(ctx context.Context, key string, leaseID, leaseTTL int64, data []byte) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockStorage) AttachToLease(ctx context.Context, key string, leaseID int64, data []byte) error {
 	return nil
 }
 
+func (m *MockStorage) GetLease(ctx context.Context, key string) (*storage.LeaseInfo, error) {
+	if m.getLeaseFunc != nil {
+		return m.getLeaseFunc(ctx, key)
+	}
+	return nil, storage.ErrLeaseNotFound
+}
+
+func (m *MockStorage) ListLeases(ctx context.Context, prefix string) ([]storage.LeaseInfo, error) {
+	if m.listLeasesFunc != nil {
+		return m.listLeasesFunc(ctx, prefix)
+	}
+	return nil, nil
+}
+
+func (m *MockStorage) ListKeys(ctx context.Context, prefix string) ([]storage.LeaseInfo, error) {
+	return m.ListLeases(ctx, prefix)
+}
+
+func (m *MockStorage) LeaseKeys(ctx context.Context, leaseID int64) ([]string, error) {
+	if m.leaseKeysFunc != nil {
+		return m.leaseKeysFunc(ctx, leaseID)
+	}
+	return nil, nil
+}
+
+func (m *MockStorage) WatchLeases(ctx context.Context, prefix string) (<-chan storage.LeaseEvent, error) {
+	return nil, nil
+}
+
 func TestCreateLease(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -140,12 +189,12 @@ func TestCreateLease(t *testing.T) {
 				createLeaseFunc: func(ctx context.Context, key string, leaseTTL int64, data []byte) (string, int64, error) {
 					return tt.createLeaseStatus, tt.createLeaseID, tt.createLeaseError
 				},
-				keepLeaseOnceFunc: func(ctx context.Context, leaseID int64) error {
-					return tt.keepLeaseError
+				keepLeaseOnceFunc: func(ctx context.Context, leaseID int64) (int64, error) {
+					return int64(tt.leaseTTL.Seconds()), tt.keepLeaseError
 				},
 			}
 
-			status, id, err := CreateLease(context.Background(), mockStorage, tt.leaseTTL, tt.lease)
+			status, id, err := CreateLease(context.Background(), mockStorage, "", tt.leaseTTL, tt.lease)
 
 			if tt.expectedError != nil {
 				assert.Error(t, err)
@@ -159,17 +208,84 @@ func TestCreateLease(t *testing.T) {
 	}
 }
 
+func TestClaimLease(t *testing.T) {
+	mockStorage := &MockStorage{
+		checkLeasePresenceFunc: func(ctx context.Context, key string) (int64, error) {
+			t.Fatal("ClaimLease must not check lease presence")
+			return 0, nil
+		},
+		createLeaseFunc: func(ctx context.Context, key string, leaseTTL int64, data []byte) (string, int64, error) {
+			assert.Equal(t, "/shared-lock/team/test-key", key)
+			return storage.StatusAccepted, 456, nil
+		},
+	}
+
+	status, id, err := ClaimLease(context.Background(), mockStorage, "team", 10*time.Second, Lease{Key: "test-key"})
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusAccepted, status)
+	assert.Equal(t, int64(456), id)
+}
+
+func TestScanLeases(t *testing.T) {
+	var record []byte
+	mockStorage := &MockStorage{
+		createLeaseFunc: func(ctx context.Context, key string, leaseTTL int64, data []byte) (string, int64, error) {
+			record = data
+			return storage.StatusCreated, 123, nil
+		},
+	}
+	_, _, err := ClaimLease(context.Background(), mockStorage, "team", 30*time.Second, Lease{Key: "a", Owner: "worker"})
+	assert.NoError(t, err)
+
+	mockStorage.listLeasesFunc = func(ctx context.Context, prefix string) ([]storage.LeaseInfo, error) {
+		assert.Equal(t, "/shared-lock/team/", prefix)
+		return []storage.LeaseInfo{
+			{Key: "/shared-lock/team/a", LeaseID: 123, Value: record},
+			{Key: "/shared-lock/team/b", LeaseID: 124, Value: []byte("plain")},
+		}, nil
+	}
+
+	leases, err := ScanLeases(context.Background(), mockStorage, "team", "")
+	assert.NoError(t, err)
+	assert.Len(t, leases, 2)
+	assert.Equal(t, "a", leases[0].Key)
+	assert.Equal(t, "worker", leases[0].Owner)
+	assert.Equal(t, int64(30), leases[0].GrantedTTL, "granted TTL is kept in the record")
+	assert.Equal(t, "plain", leases[1].Value)
+	assert.Zero(t, leases[1].GrantedTTL, "records written before do not have it")
+}
+
+func TestDecodeLease(t *testing.T) {
+	record, err := encodeLease(Lease{Key: "a", Value: "worker-1", Owner: "cron"}, time.Minute)
+	assert.NoError(t, err)
+
+	decoded := decodeLease("a", record)
+	assert.Equal(t, "worker-1", decoded.Value)
+	assert.Equal(t, "cron", decoded.Owner)
+	assert.Equal(t, int64(60), decoded.GrantedTTL)
+
+	// Values stored by earlier versions are kept whole, even when they are JSON.
+	for _, legacy := range []string{"", "worker-1", `{"host":"worker-1","value":"x"}`, "null", "42", `"quoted"`} {
+		decoded = decodeLease("a", []byte(legacy))
+		assert.Equal(t, leaseRecord{Lease: Lease{Key: "a", Value: legacy}}, decoded, legacy)
+	}
+}
+
 func TestReviveLease(t *testing.T) {
 	tests := []struct {
 		name           string
 		leaseID        int64
+		keepLeaseTTL   int64
 		keepLeaseError error
+		expectedTTL    time.Duration
 		expectedError  error
 	}{
 		{
 			name:           "Successful lease revival",
 			leaseID:        123,
+			keepLeaseTTL:   30,
 			keepLeaseError: nil,
+			expectedTTL:    30 * time.Second,
 			expectedError:  nil,
 		},
 		{
@@ -183,19 +299,106 @@ func TestReviveLease(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockStorage := &MockStorage{
-				keepLeaseOnceFunc: func(ctx context.Context, leaseID int64) error {
-					return tt.keepLeaseError
+				keepLeaseOnceFunc: func(ctx context.Context, leaseID int64) (int64, error) {
+					return tt.keepLeaseTTL, tt.keepLeaseError
 				},
 			}
 
-			err := ReviveLease(context.Background(), mockStorage, tt.leaseID)
+			ttl, err := ReviveLease(context.Background(), mockStorage, tt.leaseID)
 
 			if tt.expectedError != nil {
 				assert.Error(t, err)
 				assert.Equal(t, tt.expectedError.Error(), err.Error())
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedTTL, ttl)
+			}
+		})
+	}
+}
+
+func TestLeaseKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespace     string
+		keys          []string
+		keysError     error
+		expectedKey   string
+		expectedError error
+	}{
+		{
+			name:        "flat key space",
+			keys:        []string{"/shared-lock/test-key"},
+			expectedKey: "test-key",
+		},
+		{
+			name:        "key in namespace",
+			namespace:   "team",
+			keys:        []string{"/shared-lock/other/test-key", "/shared-lock/team/test-key"},
+			expectedKey: "test-key",
+		},
+		{
+			name:          "key in another namespace",
+			namespace:     "team",
+			keys:          []string{"/shared-lock/other/test-key"},
+			expectedError: storage.ErrLeaseNotFound,
+		},
+		{
+			name:          "dead lease",
+			keysError:     storage.ErrLeaseNotFound,
+			expectedError: storage.ErrLeaseNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := &MockStorage{
+				leaseKeysFunc: func(ctx context.Context, leaseID int64) ([]string, error) {
+					return tt.keys, tt.keysError
+				},
+			}
+
+			key, err := LeaseKey(context.Background(), mockStorage, tt.namespace, 123)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedKey, key)
+			}
+		})
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{name: "plain", key: "nightly-billing", valid: true},
+		{name: "path", key: "billing/invoice_42.v2", valid: true},
+		{name: "punctuation", key: "host:8080/user@team=a+b,c~d", valid: true},
+		{name: "empty", key: ""},
+		{name: "too long", key: strings.Repeat("a", MaxKeyLength+1)},
+		{name: "leading slash", key: "/billing"},
+		{name: "trailing slash", key: "billing/"},
+		{name: "empty segment", key: "billing//invoice"},
+		{name: "dot segment", key: "billing/./invoice"},
+		{name: "parent segment", key: "../team-b/billing"},
+		{name: "space", key: "nightly billing"},
+		{name: "percent", key: "billing%2F..%2Fx"},
+		{name: "non-ascii", key: "billing/é"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateKey(tt.key)
+			if tt.valid {
+				assert.NoError(t, err)
+				return
 			}
+			assert.ErrorIs(t, err, ErrInvalidKey)
 		})
 	}
 }
@@ -0,0 +1,122 @@
+package application
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/application/authz"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/application/namespace"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+)
+
+// Election is the state of a leader election. Leader is nil while the
+// election has no candidate.
+type Election struct {
+	Name       string                     `json:"name"`
+	Leader     *leasemanagement.Candidate `json:"leader"`
+	Candidates int                        `json:"candidates"`
+}
+
+// Campaign registers a candidate with value in the election name and returns
+// the ID of its lease, which the candidate keeps alive like any other lease.
+// The candidate that joined first leads; leader reports whether that is the
+// new candidate. Policies authorize it as acquiring the election name, and
+// each candidate counts against the namespace quotas as a lock.
+func (a *Application) Campaign(ctx context.Context, name string, leaseTTL time.Duration, value string) (leaseID int64, leader bool, err error) {
+	ns := namespace.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "application.Campaign", tracing.NamespaceAttribute.String(ns), tracing.KeyAttribute.String(name))
+	defer func() { tracing.End(span, err) }()
+
+	if err := a.authorize(ctx, authz.OperationAcquire, name); err != nil {
+		return 0, false, err
+	}
+
+	leaseTTL = a.Config().Lease.ClampTTL(leaseTTL)
+	owner := ""
+	if principal := auth.PrincipalFromContext(ctx); principal != nil {
+		owner = principal.Name
+	}
+	admitted, err := a.reserve(ns, owner, leaseTTL, value)
+	if err != nil {
+		a.rejectQuota(ns, "Candidate of election "+name, err)
+		return 0, false, err
+	}
+
+	leaseID, err = leasemanagement.Campaign(ctx, a.storageConnection, ns, name, leaseTTL, owner, value)
+	if err != nil {
+		if admitted {
+			a.quotas.Release(ns, owner, leaseSeconds(leaseTTL))
+		}
+		return 0, false, err
+	}
+
+	candidates, err := leasemanagement.Candidates(ctx, a.storageConnection, ns, name)
+	if err != nil {
+		log.Warnf("Failed to look up the leader of election %v: %v", name, err)
+		return leaseID, false, nil
+	}
+
+	return leaseID, len(candidates) > 0 && candidates[0].ID == leaseID, nil
+}
+
+// Election returns the leader and the number of candidates of the election
+// name.
+func (a *Application) Election(ctx context.Context, name string) (_ *Election, err error) {
+	ns := namespace.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "application.Election", tracing.NamespaceAttribute.String(ns), tracing.KeyAttribute.String(name))
+	defer func() { tracing.End(span, err) }()
+
+	if err := a.authorize(ctx, authz.OperationInspect, name); err != nil {
+		return nil, err
+	}
+
+	candidates, err := leasemanagement.Candidates(ctx, a.storageConnection, ns, name)
+	if err != nil {
+		return nil, err
+	}
+
+	election := &Election{Name: name, Candidates: len(candidates)}
+	if len(candidates) > 0 {
+		election.Leader = &candidates[0]
+	}
+
+	return election, nil
+}
+
+// Resign withdraws the candidate of leaseID from the election name and
+// revokes its lease. A resigning leader hands over to the next candidate at
+// once rather than when its lease would have expired.
+func (a *Application) Resign(ctx context.Context, name string, leaseID int64) (err error) {
+	ns := namespace.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "application.Resign", tracing.NamespaceAttribute.String(ns),
+		tracing.KeyAttribute.String(name), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	if err := a.authorize(ctx, authz.OperationRelease, name); err != nil {
+		return err
+	}
+
+	resigned, err := leasemanagement.Resign(ctx, a.storageConnection, ns, name, leaseID)
+	if err != nil {
+		return err
+	}
+	a.markLeaseDead(leaseID)
+	if a.Config().Namespaces.Enabled {
+		a.quotas.Release(ns, resigned.Owner, resigned.GrantedTTL)
+	}
+
+	return nil
+}
+
+// ObserveLeader streams the leader of the election name, starting with the
+// current one, until ctx is done or the watch breaks.
+func (a *Application) ObserveLeader(ctx context.Context, name string) (<-chan leasemanagement.LeaderEvent, error) {
+	if err := a.authorize(ctx, authz.OperationInspect, name); err != nil {
+		return nil, err
+	}
+
+	return leasemanagement.ObserveLeader(ctx, a.storageConnection, namespace.FromContext(ctx), name)
+}
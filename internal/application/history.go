@@ -0,0 +1,169 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/application/authz"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/application/namespace"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+)
+
+// historyWriteTimeout bounds storing a single history event.
+const historyWriteTimeout = 5 * time.Second
+
+// ErrHistoryDisabled is returned by LeaseHistory when no history is kept.
+var ErrHistoryDisabled = errors.New("lock history is disabled")
+
+// historyEntry is an event waiting to be stored in the history of a key.
+type historyEntry struct {
+	namespace string
+	key       string
+	event     leasemanagement.HistoryEvent
+}
+
+// historyWriter stores history events in the background, so that keeping the
+// history does not slow down the lock operations.
+type historyWriter struct {
+	storage storage.HistoryStorage
+	entries chan historyEntry
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// SetHistory makes the application keep the history of lock grants and
+// releases in historyStorage, buffering up to cfg.History.BufferSize events.
+func (a *Application) SetHistory(historyStorage storage.HistoryStorage) {
+	writer := &historyWriter{
+		storage: historyStorage,
+		entries: make(chan historyEntry, a.Config().History.BufferSize),
+		done:    make(chan struct{}),
+	}
+	go a.writeHistory(writer)
+
+	a.history = writer
+}
+
+// LeaseHistory returns up to limit events of the history of key between from
+// and to, oldest first. A zero to means now.
+func (a *Application) LeaseHistory(ctx context.Context, key string, from, to time.Time, limit int) (_ []leasemanagement.HistoryEvent, err error) {
+	ctx, span := tracing.Start(ctx, "application.LeaseHistory",
+		tracing.NamespaceAttribute.String(namespace.FromContext(ctx)), tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	if err = leasemanagement.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := a.authorize(ctx, authz.OperationInspect, key); err != nil {
+		return nil, err
+	}
+	if a.history == nil {
+		return nil, ErrHistoryDisabled
+	}
+
+	return leasemanagement.History(ctx, a.history.storage, namespace.FromContext(ctx), key, from, to, limit)
+}
+
+// PruneHistory drops the history events that fall out of retention every
+// history.prune_interval until ctx is done. Storing an event only prunes the
+// history of its key, which would keep the history of keys that are no longer
+// locked forever.
+func (a *Application) PruneHistory(ctx context.Context) {
+	interval := a.Config().History.PruneInterval
+	if a.history == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.pruneHistory(ctx); err != nil {
+			log.Warnf("Failed to prune lock history: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Application) pruneHistory(ctx context.Context) error {
+	cfg := a.Config().History
+	retention := storage.HistoryRetention{MaxAge: cfg.MaxAge, MaxRecords: cfg.MaxEvents}
+
+	return leasemanagement.PruneHistories(ctx, a.history.storage, retention)
+}
+
+// recordHistory queues event for the history of key without blocking.
+func (a *Application) recordHistory(ctx context.Context, key string, event leasemanagement.HistoryEvent) {
+	if a.history == nil || key == "" {
+		return
+	}
+
+	// An expiry is noticed by whoever asked next, not caused by them.
+	event.Time = time.Now().UTC()
+	if principal := auth.PrincipalFromContext(ctx); principal != nil && event.Type != leasemanagement.HistoryExpired {
+		event.Principal = principal.Name
+	}
+
+	a.history.mu.RLock()
+	defer a.history.mu.RUnlock()
+	if a.history.closed {
+		return
+	}
+
+	select {
+	case a.history.entries <- historyEntry{namespace: namespace.FromContext(ctx), key: key, event: event}:
+	default:
+		metrics.HistoryEvents.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (a *Application) writeHistory(writer *historyWriter) {
+	defer close(writer.done)
+
+	for entry := range writer.entries {
+		cfg := a.Config().History
+		retention := storage.HistoryRetention{MaxAge: cfg.MaxAge, MaxRecords: cfg.MaxEvents}
+
+		ctx, cancel := context.WithTimeout(context.Background(), historyWriteTimeout)
+		err := leasemanagement.RecordHistory(ctx, writer.storage, entry.namespace, entry.key, entry.event, retention)
+		cancel()
+		if err != nil {
+			log.Warnf("Failed to store history of %v: %v", entry.key, err)
+			metrics.HistoryEvents.WithLabelValues("failed").Inc()
+			continue
+		}
+		metrics.HistoryEvents.WithLabelValues("stored").Inc()
+	}
+}
+
+// closeHistory stores the queued history events and stops the writer.
+func (a *Application) closeHistory() {
+	if a.history == nil {
+		return
+	}
+
+	a.history.mu.Lock()
+	if a.history.closed {
+		a.history.mu.Unlock()
+		return
+	}
+	a.history.closed = true
+	close(a.history.entries)
+	a.history.mu.Unlock()
+
+	<-a.history.done
+}
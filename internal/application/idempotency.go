@@ -0,0 +1,70 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+)
+
+// statusReplayed reports a lease request answered with the lease granted to
+// an earlier request with the same idempotency key.
+const statusReplayed = "replayed"
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is sent again
+// for another key while the lease granted for it lives.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used for another key")
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey attaches the idempotency key of a lease request to ctx.
+func WithIdempotencyKey(ctx context.Context, idempotencyKey string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, idempotencyKey)
+}
+
+func idempotencyKeyFromContext(ctx context.Context) string {
+	idempotencyKey, _ := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return idempotencyKey
+}
+
+// replayAcquisition returns the lease granted to an earlier request of the
+// same owner with the idempotency key in ctx, or 0 when there is none.
+func (a *Application) replayAcquisition(ctx context.Context, ns string, lease leasemanagement.Lease) (int64, error) {
+	idempotencyKey := idempotencyKeyFromContext(ctx)
+	if idempotencyKey == "" {
+		return 0, nil
+	}
+
+	acquisition, err := leasemanagement.RememberedAcquisition(ctx, a.storageConnection, ns, lease.Owner, idempotencyKey)
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up idempotency key: %w", err)
+	}
+	if acquisition.Key != lease.Key {
+		return 0, ErrIdempotencyKeyReused
+	}
+
+	metrics.IdempotentReplays.WithLabelValues(a.namespaces.Label(ns)).Inc()
+	return acquisition.LeaseID, nil
+}
+
+// rememberAcquisition stores the lease granted for the idempotency key in ctx
+// for as long as the lease lives. Retries arriving in the meantime are
+// answered as if they had been granted the key.
+func (a *Application) rememberAcquisition(ctx context.Context, ns string, lease leasemanagement.Lease, leaseID int64) {
+	idempotencyKey := idempotencyKeyFromContext(ctx)
+	if idempotencyKey == "" {
+		return
+	}
+
+	acquisition := leasemanagement.Acquisition{Key: lease.Key, LeaseID: leaseID}
+	if err := leasemanagement.RememberAcquisition(ctx, a.storageConnection, ns, lease.Owner, idempotencyKey, acquisition); err != nil {
+		log.Warnf("Failed to remember the idempotency key of lease %v: %v", leaseID, err)
+	}
+}
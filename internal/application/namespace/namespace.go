@@ -0,0 +1,71 @@
+// Package namespace decides which isolated key space a request works in and
+// carries that decision through the request context.
+package namespace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+)
+
+// Header lets callers without a tenant choose their namespace.
+const Header = "x-namespace"
+
+var (
+	ErrInvalid  = errors.New("invalid namespace")
+	ErrRequired = errors.New("namespace required")
+)
+
+// ForbiddenError is returned when a caller bound to a tenant asks for
+// another namespace.
+type ForbiddenError struct {
+	Tenant    string `json:"tenant"`
+	Namespace string `json:"namespace"`
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("tenant %q may not use namespace %q", e.Tenant, e.Namespace)
+}
+
+// Resolve picks the namespace of a request from the caller's tenant, the
+// namespace it asked for and the configured default, in that order. It
+// returns "" when namespaces are disabled.
+func Resolve(cfg *config.NamespacesCfg, principal *auth.Principal, requested string) (string, error) {
+	if !cfg.Enabled {
+		return "", nil
+	}
+
+	if principal != nil && principal.Tenant != "" {
+		if requested != "" && requested != principal.Tenant {
+			return "", &ForbiddenError{Tenant: principal.Tenant, Namespace: requested}
+		}
+		requested = principal.Tenant
+	}
+	if requested == "" {
+		requested = cfg.Default
+	}
+	if requested == "" {
+		return "", ErrRequired
+	}
+	if !config.IsValidNamespace(requested) {
+		return "", fmt.Errorf("%w %q", ErrInvalid, requested)
+	}
+
+	return requested, nil
+}
+
+type namespaceKey struct{}
+
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// FromContext returns the namespace of the request, or "" when namespaces
+// are disabled.
+func FromContext(ctx context.Context) string {
+	namespace, _ := ctx.Value(namespaceKey{}).(string)
+	return namespace
+}
@@ -0,0 +1,96 @@
+package namespace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+)
+
+func TestResolve(t *testing.T) {
+	enabled := config.NamespacesCfg{Enabled: true, Default: config.DefaultNamespace}
+
+	tests := []struct {
+		name      string
+		cfg       config.NamespacesCfg
+		principal *auth.Principal
+		requested string
+		expected  string
+		expectErr error
+		forbidden bool
+	}{
+		{
+			name:      "disabled ignores the header",
+			cfg:       config.NamespacesCfg{Default: config.DefaultNamespace},
+			requested: "team-a",
+			expected:  "",
+		},
+		{
+			name:     "default namespace",
+			cfg:      enabled,
+			expected: config.DefaultNamespace,
+		},
+		{
+			name:      "requested namespace",
+			cfg:       enabled,
+			principal: &auth.Principal{Name: "ci"},
+			requested: "team-a",
+			expected:  "team-a",
+		},
+		{
+			name:      "tenant namespace",
+			cfg:       enabled,
+			principal: &auth.Principal{Name: "ci", Tenant: "team-b"},
+			expected:  "team-b",
+		},
+		{
+			name:      "tenant requesting its own namespace",
+			cfg:       enabled,
+			principal: &auth.Principal{Name: "ci", Tenant: "team-b"},
+			requested: "team-b",
+			expected:  "team-b",
+		},
+		{
+			name:      "tenant requesting another namespace",
+			cfg:       enabled,
+			principal: &auth.Principal{Name: "ci", Tenant: "team-b"},
+			requested: "team-a",
+			forbidden: true,
+		},
+		{
+			name:      "invalid namespace",
+			cfg:       enabled,
+			requested: "../etc",
+			expectErr: ErrInvalid,
+		},
+		{
+			name:      "no default",
+			cfg:       config.NamespacesCfg{Enabled: true},
+			expectErr: ErrRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns, err := Resolve(&tt.cfg, tt.principal, tt.requested)
+
+			switch {
+			case tt.forbidden:
+				var forbidden *ForbiddenError
+				assert.ErrorAs(t, err, &forbidden)
+			case tt.expectErr != nil:
+				assert.ErrorIs(t, err, tt.expectErr)
+			default:
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, ns)
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, "", FromContext(context.Background()))
+	assert.Equal(t, "team-a", FromContext(WithNamespace(context.Background(), "team-a")))
+}
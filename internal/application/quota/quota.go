@@ -0,0 +1,226 @@
+// Package quota keeps track of how many locks each namespace holds and
+// rejects new locks beyond the configured namespace limits.
+package quota
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+)
+
+// Names of the quotas, as reported in ExceededError and metrics.
+const (
+	MaxLocks             = "max_locks"
+	MaxLocksPerPrincipal = "max_locks_per_principal"
+	MaxLeaseSeconds      = "max_lease_seconds"
+	MaxValueBytes        = "max_value_bytes"
+)
+
+// ExceededError is returned when a new lock would take a namespace, or a
+// principal within it, over a limit.
+type ExceededError struct {
+	Namespace string `json:"namespace"`
+	Principal string `json:"principal,omitempty"`
+	Quota     string `json:"quota"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Requested int64  `json:"requested"`
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("namespace %q exceeds its %v quota: %d used, %d requested, limit %d", e.Namespace, e.Quota, e.Used, e.Requested, e.Limit)
+}
+
+// Usage is what a namespace currently holds.
+type Usage struct {
+	Locks        int64
+	LeaseSeconds int64
+	// Principals counts the locks of each authenticated principal.
+	Principals map[string]int64
+}
+
+func newUsage() *Usage {
+	return &Usage{Principals: make(map[string]int64)}
+}
+
+// Snapshot is the usage of every namespace, counted from storage.
+type Snapshot map[string]*Usage
+
+// Add counts one held lock.
+func (s Snapshot) Add(namespace, principal string, leaseSeconds int64) {
+	usage, exists := s[namespace]
+	if !exists {
+		usage = newUsage()
+		s[namespace] = usage
+	}
+
+	usage.Locks++
+	usage.LeaseSeconds += leaseSeconds
+	if principal != "" {
+		usage.Principals[principal]++
+	}
+}
+
+// Tracker counts the usage of every namespace in memory, so that quota
+// checks need no storage round trip. Locks that expire are only noticed by
+// the next Replace with a fresh count from storage.
+type Tracker struct {
+	namespaces *metrics.Namespaces
+
+	mu    sync.Mutex
+	usage map[string]*Usage
+}
+
+// NewTracker reports usage by the label values of namespaces.
+func NewTracker(namespaces *metrics.Namespaces) *Tracker {
+	return &Tracker{namespaces: namespaces, usage: make(map[string]*Usage)}
+}
+
+// CheckValueSize rejects lock values larger than the namespace allows.
+func CheckValueSize(namespace string, size int, limits config.NamespaceLimits) error {
+	if limits.MaxValueBytes > 0 && size > limits.MaxValueBytes {
+		return &ExceededError{Namespace: namespace, Quota: MaxValueBytes, Limit: int64(limits.MaxValueBytes), Requested: int64(size)}
+	}
+
+	return nil
+}
+
+// Admit reserves one lock of leaseSeconds for principal in namespace, or
+// returns an ExceededError without reserving anything. An empty principal
+// is not subject to the per-principal limit.
+func (t *Tracker) Admit(namespace, principal string, leaseSeconds int64, limits config.NamespaceLimits) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := t.get(namespace)
+	previous := *usage
+
+	var exceeded *ExceededError
+	switch {
+	case limits.MaxLocks > 0 && usage.Locks+1 > int64(limits.MaxLocks):
+		exceeded = &ExceededError{Quota: MaxLocks, Limit: int64(limits.MaxLocks), Used: usage.Locks, Requested: 1}
+	case limits.MaxLocksPerPrincipal > 0 && principal != "" && usage.Principals[principal]+1 > int64(limits.MaxLocksPerPrincipal):
+		exceeded = &ExceededError{Principal: principal, Quota: MaxLocksPerPrincipal, Limit: int64(limits.MaxLocksPerPrincipal), Used: usage.Principals[principal], Requested: 1}
+	case limits.MaxLeaseSeconds > 0 && usage.LeaseSeconds+leaseSeconds > int64(limits.MaxLeaseSeconds):
+		exceeded = &ExceededError{Quota: MaxLeaseSeconds, Limit: int64(limits.MaxLeaseSeconds), Used: usage.LeaseSeconds, Requested: leaseSeconds}
+	}
+	if exceeded != nil {
+		exceeded.Namespace = namespace
+		return exceeded
+	}
+
+	usage.Locks++
+	usage.LeaseSeconds += leaseSeconds
+	if principal != "" {
+		usage.Principals[principal]++
+	}
+	t.report(namespace, previous, usage)
+
+	return nil
+}
+
+// Release returns a lock admitted earlier, or released from storage.
+func (t *Tracker) Release(namespace, principal string, leaseSeconds int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := t.get(namespace)
+	previous := *usage
+	usage.Locks = max(usage.Locks-1, 0)
+	usage.LeaseSeconds = max(usage.LeaseSeconds-leaseSeconds, 0)
+	if principal != "" {
+		if usage.Principals[principal] <= 1 {
+			delete(usage.Principals, principal)
+		} else {
+			usage.Principals[principal]--
+		}
+	}
+	t.report(namespace, previous, usage)
+}
+
+// Replace swaps the tracked usage for a fresh count from storage.
+// Namespaces missing from the snapshot hold nothing; the configured ones
+// are kept to report that.
+func (t *Tracker) Replace(snapshot Snapshot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for namespace := range t.usage {
+		if _, exists := snapshot[namespace]; !exists && t.namespaces.Label(namespace) != metrics.NamespaceOther {
+			snapshot[namespace] = newUsage()
+		}
+	}
+	t.usage = snapshot
+	t.publish()
+}
+
+// Relabel publishes the usage again after the namespaces reported as
+// themselves have changed, moving it between their series and other.
+func (t *Tracker) Relabel() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.publish()
+}
+
+// publish sets the usage series of every namespace, summing those reported
+// as other. Namespaces that are no longer configured lose their own series.
+func (t *Tracker) publish() {
+	other := newUsage()
+	for namespace, usage := range t.usage {
+		if t.namespaces.Label(namespace) == metrics.NamespaceOther {
+			other.Locks += usage.Locks
+			other.LeaseSeconds += usage.LeaseSeconds
+			metrics.QuotaUsage.DeleteLabelValues(namespace, "locks")
+			metrics.QuotaUsage.DeleteLabelValues(namespace, "lease_seconds")
+			continue
+		}
+		t.set(namespace, usage)
+	}
+	t.set(metrics.NamespaceOther, other)
+}
+
+// Usage returns a copy of the tracked usage of namespace.
+func (t *Tracker) Usage(namespace string) Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := *t.get(namespace)
+	usage.Principals = make(map[string]int64, len(usage.Principals))
+	for principal, locks := range t.usage[namespace].Principals {
+		usage.Principals[principal] = locks
+	}
+
+	return usage
+}
+
+func (t *Tracker) get(namespace string) *Usage {
+	usage, exists := t.usage[namespace]
+	if !exists {
+		usage = newUsage()
+		t.usage[namespace] = usage
+	}
+
+	return usage
+}
+
+// report publishes the usage of namespace after a change from previous.
+// Namespaces reported as other share their series, which is therefore moved
+// by the change rather than set.
+func (t *Tracker) report(namespace string, previous Usage, usage *Usage) {
+	label := t.namespaces.Label(namespace)
+	if label != metrics.NamespaceOther {
+		t.set(label, usage)
+		return
+	}
+
+	metrics.QuotaUsage.WithLabelValues(label, "locks").Add(float64(usage.Locks - previous.Locks))
+	metrics.QuotaUsage.WithLabelValues(label, "lease_seconds").Add(float64(usage.LeaseSeconds - previous.LeaseSeconds))
+}
+
+func (t *Tracker) set(label string, usage *Usage) {
+	metrics.QuotaUsage.WithLabelValues(label, "locks").Set(float64(usage.Locks))
+	metrics.QuotaUsage.WithLabelValues(label, "lease_seconds").Set(float64(usage.LeaseSeconds))
+}
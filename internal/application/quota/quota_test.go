@@ -0,0 +1,124 @@
+package quota
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+)
+
+func TestTracker_Admit(t *testing.T) {
+	limits := config.NamespaceLimits{MaxLocks: 3, MaxLocksPerPrincipal: 2, MaxLeaseSeconds: 100}
+
+	tests := []struct {
+		name          string
+		principal     string
+		leaseSeconds  int64
+		expectedQuota string
+	}{
+		{name: "first lock", principal: "a", leaseSeconds: 30},
+		{name: "second lock of principal", principal: "a", leaseSeconds: 30},
+		{name: "third lock of principal", principal: "a", leaseSeconds: 10, expectedQuota: MaxLocksPerPrincipal},
+		{name: "lease seconds exhausted", principal: "b", leaseSeconds: 50, expectedQuota: MaxLeaseSeconds},
+		{name: "anonymous lock", leaseSeconds: 40},
+		{name: "locks exhausted", principal: "b", leaseSeconds: 1, expectedQuota: MaxLocks},
+	}
+
+	tracker := NewTracker(metrics.NewNamespaces("team-a"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tracker.Admit("team-a", tt.principal, tt.leaseSeconds, limits)
+
+			if tt.expectedQuota == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var exceeded *ExceededError
+			require.ErrorAs(t, err, &exceeded)
+			assert.Equal(t, tt.expectedQuota, exceeded.Quota)
+			assert.Equal(t, "team-a", exceeded.Namespace)
+		})
+	}
+
+	assert.Equal(t, Usage{Locks: 3, LeaseSeconds: 100, Principals: map[string]int64{"a": 2}}, tracker.Usage("team-a"))
+	assert.Equal(t, int64(0), tracker.Usage("team-b").Locks, "namespaces are counted separately")
+
+	tracker.Release("team-a", "a", 30)
+	assert.Equal(t, Usage{Locks: 2, LeaseSeconds: 70, Principals: map[string]int64{"a": 1}}, tracker.Usage("team-a"))
+	assert.NoError(t, tracker.Admit("team-a", "b", 30, limits))
+}
+
+func TestTracker_Replace(t *testing.T) {
+	tracker := NewTracker(metrics.NewNamespaces("team-a", "stale"))
+	require.NoError(t, tracker.Admit("stale", "a", 10, config.NamespaceLimits{}))
+
+	snapshot := make(Snapshot)
+	snapshot.Add("team-a", "a", 10)
+	snapshot.Add("team-a", "", 5)
+	tracker.Replace(snapshot)
+
+	assert.Equal(t, Usage{Locks: 2, LeaseSeconds: 15, Principals: map[string]int64{"a": 1}}, tracker.Usage("team-a"))
+	assert.Equal(t, Usage{Principals: map[string]int64{}}, tracker.Usage("stale"))
+}
+
+func TestTracker_OtherNamespaces(t *testing.T) {
+	tracker := NewTracker(metrics.NewNamespaces("configured"))
+	locks := metrics.QuotaUsage.WithLabelValues(metrics.NamespaceOther, "locks")
+
+	snapshot := make(Snapshot)
+	snapshot.Add("picked-1", "", 10)
+	snapshot.Add("picked-2", "", 10)
+	snapshot.Add("configured", "", 10)
+	tracker.Replace(snapshot)
+	assert.Equal(t, 2.0, testutil.ToFloat64(locks))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.QuotaUsage.WithLabelValues("configured", "locks")))
+
+	require.NoError(t, tracker.Admit("picked-3", "", 10, config.NamespaceLimits{}))
+	tracker.Release("picked-1", "", 10)
+	tracker.Release("picked-1", "", 10)
+	assert.Equal(t, 2.0, testutil.ToFloat64(locks), "releasing more than held does not go below the usage")
+
+	tracker.Replace(make(Snapshot))
+	assert.Zero(t, testutil.ToFloat64(locks))
+	tracker.mu.Lock()
+	assert.NotContains(t, tracker.usage, "picked-2", "namespaces that are not configured are forgotten once empty")
+	tracker.mu.Unlock()
+}
+
+func TestTracker_Relabel(t *testing.T) {
+	namespaces := metrics.NewNamespaces("kept", "dropped")
+	tracker := NewTracker(namespaces)
+	other := metrics.QuotaUsage.WithLabelValues(metrics.NamespaceOther, "locks")
+
+	snapshot := make(Snapshot)
+	snapshot.Add("kept", "", 10)
+	snapshot.Add("dropped", "", 10)
+	snapshot.Add("added", "", 10)
+	snapshot.Add("added", "", 10)
+	tracker.Replace(snapshot)
+	assert.Equal(t, 2.0, testutil.ToFloat64(other))
+
+	namespaces.Set("kept", "added")
+	tracker.Relabel()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(other), "the dropped namespace is reported as other")
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.QuotaUsage.WithLabelValues("added", "locks")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.QuotaUsage.WithLabelValues("kept", "locks")))
+	assert.False(t, metrics.QuotaUsage.DeleteLabelValues("dropped", "locks"), "the dropped namespace loses its series")
+
+	require.NoError(t, tracker.Admit("added", "", 10, config.NamespaceLimits{}))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.QuotaUsage.WithLabelValues("added", "locks")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(other))
+}
+
+func TestCheckValueSize(t *testing.T) {
+	assert.NoError(t, CheckValueSize("team-a", 1<<20, config.NamespaceLimits{}))
+	assert.NoError(t, CheckValueSize("team-a", 8, config.NamespaceLimits{MaxValueBytes: 8}))
+
+	var exceeded *ExceededError
+	require.ErrorAs(t, CheckValueSize("team-a", 9, config.NamespaceLimits{MaxValueBytes: 8}), &exceeded)
+	assert.Equal(t, &ExceededError{Namespace: "team-a", Quota: MaxValueBytes, Limit: 8, Requested: 9}, exceeded)
+}
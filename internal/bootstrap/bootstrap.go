@@ -3,17 +3,31 @@ package bootstrap
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/tentens-tech/shared-lock/internal/application"
 	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/audit"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/cache"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/etcd"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/limiter"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/mock"
 )
 
-func newStorageConnection(cfg *config.Config) (storage.Storage, error) {
+func newStorageConnection(cfg *config.Config, backend storage.Storage) storage.Storage {
+	if cfg.Storage.MaxConcurrentRequests > 0 {
+		log.Infof("Storage requests are limited to %d in flight", cfg.Storage.MaxConcurrentRequests)
+		return limiter.New(backend, cfg.Storage.MaxConcurrentRequests, cfg.Storage.MaxWait)
+	}
+
+	return backend
+}
+
+func newStorageBackend(cfg *config.Config) (storage.Storage, error) {
 	if cfg.Storage.Type == "etcd" {
 		storageConnection, err := etcd.New(cfg)
 		if err != nil {
@@ -21,34 +35,113 @@ func newStorageConnection(cfg *config.Config) (storage.Storage, error) {
 		}
 		return storageConnection, nil
 	} else if cfg.Storage.Type == "mock" {
-		return &mock.Storage{}, nil
+		return mock.New(), nil
 	}
 
 	return nil, fmt.Errorf("unsupported storage type: %v", cfg.Storage.Type)
 }
 
-func newCache(cfg *config.Config) (*cache.Cache, error) {
+func newCache(cfg *config.Config) (*cache.LeaseCache, error) {
 	if cfg.Cache.Enabled {
 		log.Info("Cache is enabled")
-		return cache.New(cfg.Cache.Size), nil
+		return cache.NewLeaseCache(cfg.Cache.Size), nil
 	}
 
 	log.Info("Cache is disabled")
 	return nil, nil
 }
 
+// NewAuthenticator builds the authentication chain configured in cfg.Auth.
+// It returns nil when authentication is disabled.
+func NewAuthenticator(cfg *config.Config) (auth.Authenticator, error) {
+	if !cfg.Auth.Enabled {
+		log.Info("Authentication is disabled")
+		return nil, nil
+	}
+
+	var chain auth.Chain
+	if cfg.Auth.TokensFile != "" {
+		tokenAuthenticator, err := auth.NewTokenAuthenticator(cfg.Auth.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, tokenAuthenticator)
+	}
+	if cfg.Auth.JWT.JWKSFile != "" {
+		jwtAuthenticator, err := auth.NewJWTAuthenticator(auth.JWTOptions{
+			JWKSPath:    cfg.Auth.JWT.JWKSFile,
+			Issuer:      cfg.Auth.JWT.Issuer,
+			Audience:    cfg.Auth.JWT.Audience,
+			GroupsClaim: cfg.Auth.JWT.GroupsClaim,
+			TenantClaim: cfg.Auth.JWT.TenantClaim,
+		})
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, jwtAuthenticator)
+	}
+	if cfg.Auth.MTLS {
+		chain = append(chain, auth.NewMTLSAuthenticator())
+	}
+
+	log.Infof("Authentication is enabled with %d method(s)", len(chain))
+	return chain, nil
+}
+
+func newAuditLog(cfg *config.Config) (*audit.Logger, error) {
+	var sinks []io.Writer
+	if cfg.Audit.Stdout {
+		// Hide Close so that closing the audit log leaves stdout open.
+		sinks = append(sinks, struct{ io.Writer }{os.Stdout})
+	}
+	if cfg.Audit.File != "" {
+		file, err := audit.NewRotatingFile(cfg.Audit.File, int64(cfg.Audit.MaxSizeMB)<<20, cfg.Audit.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, file)
+		log.Infof("Audit log is written to %v", cfg.Audit.File)
+	}
+
+	return audit.New(cfg.Audit.BufferSize, sinks...), nil
+}
+
 func NewApplication(ctx context.Context, cfg *config.Config) (*application.Application, error) {
 	leaseCache, err := newCache(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cache: %v", err)
 	}
 
-	storageConnection, err := newStorageConnection(cfg)
+	backend, err := newStorageBackend(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage connection: %v", err)
 	}
 
+	storageConnection := newStorageConnection(cfg, backend)
 	app := application.New(ctx, cfg, storageConnection, leaseCache)
 
+	if cfg.History.Enabled {
+		historyStorage, ok := backend.(storage.HistoryStorage)
+		if !ok {
+			app.Close()
+			return nil, fmt.Errorf("storage type %v does not keep lock history", cfg.Storage.Type)
+		}
+		// History requests count against the same limit as lock requests.
+		if limited, ok := storageConnection.(*limiter.Storage); ok {
+			historyStorage = limited.WrapHistory(historyStorage)
+		}
+		log.Info("Lock history is enabled")
+		app.SetHistory(historyStorage)
+	}
+
+	if cfg.Audit.Enabled {
+		auditLog, err := newAuditLog(cfg)
+		if err != nil {
+			app.Close()
+			return nil, fmt.Errorf("failed to create audit log: %v", err)
+		}
+		app.SetAuditLog(auditLog)
+	}
+
 	return app, nil
 }
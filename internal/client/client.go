@@ -0,0 +1,318 @@
+// Package client talks to a running shared-lock server over its HTTP API.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tentens-tech/shared-lock/internal/api"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/application/namespace"
+)
+
+// DefaultServer is the address of a server running with the default
+// configuration on the local host.
+const DefaultServer = "http://localhost:8080"
+
+// ErrNotFound is returned when the server answers 404, e.g. for a key that
+// is not held.
+var ErrNotFound = errors.New("not found")
+
+// StatusError is an unexpected answer of the server.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server answered %v", http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("server answered %v: %v", http.StatusText(e.StatusCode), e.Message)
+}
+
+// Options configure how the client reaches the server.
+type Options struct {
+	// Server is the base URL of the server, DefaultServer when empty.
+	Server string
+	// Token is sent as bearer token when set.
+	Token string
+	// Namespace is sent in the namespace header when set.
+	Namespace string
+	// CACertPath, CertPath and KeyPath configure TLS: a CA to verify the
+	// server with, and a client certificate for mTLS.
+	CACertPath string
+	CertPath   string
+	KeyPath    string
+	// Timeout bounds each request except watches, none when zero.
+	Timeout time.Duration
+}
+
+type Client struct {
+	server     *url.URL
+	token      string
+	namespace  string
+	httpClient *http.Client
+	timeout    time.Duration
+}
+
+func New(opts Options) (*Client, error) {
+	if opts.Server == "" {
+		opts.Server = DefaultServer
+	}
+	server, err := url.Parse(strings.TrimSuffix(opts.Server, "/"))
+	if err != nil || server.Scheme == "" || server.Host == "" {
+		return nil, fmt.Errorf("invalid server address %q", opts.Server)
+	}
+
+	tlsConfig, err := tlsConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &Client{
+		server:     server,
+		token:      opts.Token,
+		namespace:  opts.Namespace,
+		httpClient: &http.Client{Transport: transport},
+		timeout:    opts.Timeout,
+	}, nil
+}
+
+func tlsConfig(opts Options) (*tls.Config, error) {
+	if opts.CACertPath == "" && opts.CertPath == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CACertPath != "" {
+		pem, err := os.ReadFile(opts.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", opts.CACertPath)
+		}
+		config.RootCAs = pool
+	}
+	if opts.CertPath != "" {
+		certificate, err := tls.LoadX509KeyPair(opts.CertPath, opts.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// List returns the held leases whose key starts with prefix.
+func (c *Client) List(ctx context.Context, prefix string) ([]leasemanagement.LeaseDetails, error) {
+	var leases []leasemanagement.LeaseDetails
+	err := c.do(ctx, http.MethodGet, "/leases?prefix="+url.QueryEscape(prefix), nil, &leases)
+
+	return leases, err
+}
+
+// Get returns the lease holding key, or ErrNotFound.
+func (c *Client) Get(ctx context.Context, key string) (*leasemanagement.LeaseDetails, error) {
+	var lease leasemanagement.LeaseDetails
+	if err := c.do(ctx, http.MethodGet, "/lease/"+escapeKey(key), nil, &lease); err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+// Release releases key if it is held by leaseID, otherwise it returns
+// ErrNotFound.
+func (c *Client) Release(ctx context.Context, key string, leaseID int64) error {
+	body := struct {
+		Key string `json:"key"`
+		ID  int64  `json:"id"`
+	}{Key: key, ID: leaseID}
+
+	return c.do(ctx, http.MethodPost, "/release", body, nil)
+}
+
+// ForceRelease releases key whichever lease holds it and returns the ID of
+// that lease.
+func (c *Client) ForceRelease(ctx context.Context, key string) (int64, error) {
+	body := struct {
+		Key string `json:"key"`
+	}{Key: key}
+
+	var released struct {
+		ID int64 `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/admin/release", body, &released); err != nil {
+		return 0, err
+	}
+
+	return released.ID, nil
+}
+
+// Transfer hands key over to owner and returns the ID of the new lease.
+func (c *Client) Transfer(ctx context.Context, key, owner string) (int64, error) {
+	body := struct {
+		Key   string `json:"key"`
+		Owner string `json:"owner"`
+	}{Key: key, Owner: owner}
+
+	var transferred struct {
+		ID int64 `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/admin/transfer", body, &transferred); err != nil {
+		return 0, err
+	}
+
+	return transferred.ID, nil
+}
+
+// Stats returns the number of held locks by key prefix and owner.
+func (c *Client) Stats(ctx context.Context) (*api.LockStats, error) {
+	var stats api.LockStats
+	if err := c.do(ctx, http.MethodGet, "/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Watch calls handle for every change to the keys starting with prefix
+// until ctx is done, the server ends the stream or handle returns an error.
+func (c *Client) Watch(ctx context.Context, prefix string, handle func(leasemanagement.WatchEvent) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/watch?prefix="+url.QueryEscape(prefix), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var event leasemanagement.WatchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("failed to decode watch event: %v", err)
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return scanner.Err()
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, result any) error {
+	_, _, err := c.exchange(ctx, method, path, nil, body, result)
+	return err
+}
+
+// exchange sends a request with the extra header, decodes a successful answer
+// into result and returns its status code and header.
+func (c *Client) exchange(ctx context.Context, method, path string, header http.Header, body, result any) (int, http.Header, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp); err != nil {
+		return resp.StatusCode, resp.Header, err
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, resp.Header, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return resp.StatusCode, resp.Header, fmt.Errorf("failed to decode server response: %v", err)
+	}
+
+	return resp.StatusCode, resp.Header, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = strings.NewReader(string(encoded))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.server.String()+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set(namespace.Header, c.namespace)
+	}
+
+	return req, nil
+}
+
+// checkStatus turns an unsuccessful answer into an error.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+}
+
+// escapeKey escapes the segments of key for use in a URL path.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
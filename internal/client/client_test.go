@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/api"
+	"github.com/tentens-tech/shared-lock/internal/application"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	httpserver "github.com/tentens-tech/shared-lock/internal/delivery/http"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/mock"
+)
+
+func newTestServer(t *testing.T, configure ...func(*config.Config)) *httptest.Server {
+	t.Helper()
+
+	cfg := config.NewConfig()
+	cfg.Storage.Type = "mock"
+	for _, apply := range configure {
+		apply(cfg)
+	}
+	app := application.New(context.Background(), cfg, mock.New(), nil)
+	t.Cleanup(app.Close)
+
+	server := httptest.NewServer(httpserver.New(app, nil).Handler(&cfg.Server))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestClient(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c, err := New(Options{Server: server.URL + "/"})
+	require.NoError(t, err)
+
+	resp, err := http.Post(server.URL+"/lease", "application/json", strings.NewReader(`{"key": "billing/nightly", "labels": {"env": "prod"}}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	leases, err := c.List(ctx, "billing/")
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+	assert.Equal(t, "billing/nightly", leases[0].Key)
+
+	lease, err := c.Get(ctx, "billing/nightly")
+	require.NoError(t, err)
+	assert.Equal(t, int64(123), lease.ID)
+	assert.Equal(t, "prod", lease.Labels["env"])
+
+	stats, err := c.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &api.LockStats{Locks: 1, Prefixes: map[string]int{"billing": 1}, Owners: map[string]int{"": 1}}, stats)
+
+	assert.ErrorIs(t, c.Release(ctx, "billing/nightly", 999), ErrNotFound)
+	require.NoError(t, c.Release(ctx, "billing/nightly", lease.ID))
+	_, err = c.Get(ctx, "billing/nightly")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestClient_Admin(t *testing.T) {
+	server := newTestServer(t, func(cfg *config.Config) {
+		cfg.Authz = config.AuthzCfg{
+			Enabled: true,
+			Policies: []config.PolicyCfg{
+				{Name: "everyone", Principals: []string{"*"}, Prefixes: []string{"*"}, Operations: []string{"*", "admin"}},
+			},
+		}
+	})
+	ctx := context.Background()
+	c, err := New(Options{Server: server.URL})
+	require.NoError(t, err)
+
+	_, err = c.Transfer(ctx, "billing/nightly", "worker-2")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	resp, err := http.Post(server.URL+"/lease", "application/json", strings.NewReader(`{"key": "billing/nightly"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	leaseID, err := c.Transfer(ctx, "billing/nightly", "worker-2")
+	require.NoError(t, err)
+	lease, err := c.Get(ctx, "billing/nightly")
+	require.NoError(t, err)
+	assert.Equal(t, leaseID, lease.ID)
+	assert.Equal(t, "worker-2", lease.Owner)
+
+	released, err := c.ForceRelease(ctx, "billing/nightly")
+	require.NoError(t, err)
+	assert.Equal(t, leaseID, released)
+	_, err = c.ForceRelease(ctx, "billing/nightly")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestClient_Watch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/watch", r.URL.Path)
+		assert.Equal(t, "billing/", r.URL.Query().Get("prefix"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "team-a", r.Header.Get("x-namespace"))
+		_, _ = w.Write([]byte(`{"type":"acquired","key":"billing/nightly","lease_id":7}` + "\n" + `{"type":"released","key":"billing/nightly"}` + "\n"))
+	}))
+	defer server.Close()
+
+	c, err := New(Options{Server: server.URL, Token: "secret", Namespace: "team-a"})
+	require.NoError(t, err)
+
+	var events []leasemanagement.WatchEvent
+	err = c.Watch(context.Background(), "billing/", func(event leasemanagement.WatchEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []leasemanagement.WatchEvent{
+		{Type: leasemanagement.WatchAcquired, Key: "billing/nightly", LeaseID: 7},
+		{Type: leasemanagement.WatchReleased, Key: "billing/nightly"},
+	}, events)
+}
+
+func TestClient_StatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	c, err := New(Options{Server: server.URL})
+	require.NoError(t, err)
+
+	_, err = c.List(context.Background(), "")
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusUnauthorized, statusErr.StatusCode)
+	assert.Equal(t, "unauthorized", statusErr.Message)
+}
+
+func TestNew_InvalidServer(t *testing.T) {
+	_, err := New(Options{Server: "localhost:8080"})
+	assert.Error(t, err)
+}
+
+func TestClient_Lock(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c, err := New(Options{Server: server.URL})
+	require.NoError(t, err)
+
+	lease := leasemanagement.Lease{Key: "billing/nightly"}
+	leaseID, granted, err := c.Acquire(ctx, lease, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(123), leaseID)
+	assert.Equal(t, time.Minute, granted)
+	_, _, err = c.Acquire(ctx, lease, time.Minute)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, c.Keepalive(ctx, leaseID))
+	assert.ErrorIs(t, c.Keepalive(ctx, 999), ErrLeaseLost)
+
+	holdCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, c.Hold(holdCtx, leaseID, 30*time.Millisecond))
+	assert.ErrorIs(t, c.Hold(ctx, 999, 30*time.Millisecond), ErrLeaseLost)
+}
+
+func TestClient_AcquireGrantedTTL(t *testing.T) {
+	server := newTestServer(t, func(cfg *config.Config) { cfg.Lease.MaxTTL = 10 * time.Second })
+	c, err := New(Options{Server: server.URL})
+	require.NoError(t, err)
+
+	_, granted, err := c.Acquire(context.Background(), leasemanagement.Lease{Key: "billing/nightly"}, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, granted, "the server lowers the TTL to lease.max_ttl")
+}
+
+func TestClient_HoldUnreachable(t *testing.T) {
+	server := newTestServer(t)
+	c, err := New(Options{Server: server.URL})
+	require.NoError(t, err)
+	server.Close()
+
+	err = c.Hold(context.Background(), 123, 30*time.Millisecond)
+	assert.ErrorIs(t, err, ErrLeaseLost, "the lease expires once no keepalive got through for its TTL")
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+)
+
+const leaseTTLHeader = "x-lease-ttl"
+
+var (
+	// ErrLocked is returned by Acquire when the key is held by another lease.
+	ErrLocked = errors.New("lock is held by another lease")
+	// ErrLeaseLost is returned once a held lease has expired or was taken
+	// away.
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+// Acquire takes the lock on lease.Key for ttl and returns the ID of the new
+// lease and the TTL the server granted it, which may be shorter than ttl.
+// It does not wait: when the key is held it returns ErrLocked.
+func (c *Client) Acquire(ctx context.Context, lease leasemanagement.Lease, ttl time.Duration) (int64, time.Duration, error) {
+	header := http.Header{}
+	header.Set(leaseTTLHeader, ttl.String())
+
+	var leaseID int64
+	status, respHeader, err := c.exchange(ctx, http.MethodPost, "/lease", header, lease, &leaseID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if status != http.StatusCreated {
+		return 0, 0, ErrLocked
+	}
+
+	// Servers that do not report the granted TTL grant the one asked for.
+	granted, err := time.ParseDuration(respHeader.Get(leaseTTLHeader))
+	if err != nil || granted <= 0 {
+		granted = ttl
+	}
+
+	return leaseID, granted, nil
+}
+
+// Keepalive prolongs leaseID, or returns ErrLeaseLost when it is gone.
+func (c *Client) Keepalive(ctx context.Context, leaseID int64) error {
+	status, _, err := c.exchange(ctx, http.MethodPost, "/keepalive", nil, leaseID, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNoContent {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+// Hold keeps leaseID alive, sending a keepalive every third of ttl, until ctx
+// is done. It returns an ErrLeaseLost error when the server reports the lease
+// gone, or when no keepalive got through for ttl, after which the server has
+// let it expire.
+func (c *Client) Hold(ctx context.Context, leaseID int64, ttl time.Duration) error {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	kept := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		err := c.Keepalive(ctx, leaseID)
+		switch {
+		case err == nil:
+			kept = time.Now()
+		case errors.Is(err, ErrLeaseLost):
+			return err
+		case ctx.Err() != nil:
+			return nil
+		case time.Since(kept) >= ttl:
+			return fmt.Errorf("%w: no keepalive got through for %v: %v", ErrLeaseLost, ttl, err)
+		}
+	}
+}
@@ -2,10 +2,6 @@ package config
 
 import (
 	"fmt"
-	"log"
-	"os"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -17,6 +13,8 @@ const (
 	DefaultServerIdleTimeout        = 120 * time.Second
 	DefaultServerShutdownTimeout    = 10 * time.Second
 	DefaultServerPPROFEnabled       = false
+	DefaultServerTLSMinVersion      = "1.2"
+	DefaultServerTLSClientAuth      = "none"
 	DefaultStorageType              = "etcd"
 	DefaultEtcdAddrList             = "http://localhost:2379"
 	DefaultEtcdTLSEnabled           = false
@@ -25,118 +23,385 @@ const (
 	DefaultEtcdServerClientKeyPath  = "/etc/etcd/client.key"
 	DefaultCacheEnabled             = false
 	DefaultCacheSize                = 1000
+	DefaultCacheFallbackTTL         = time.Second
+	DefaultCacheNegativeTTL         = 5 * time.Second
+	DefaultLogLevel                 = "info"
+	DefaultLeaseTTL                 = 10 * time.Second
+	DefaultLeaseMinTTL              = time.Second
+	DefaultLeaseMaxTTL              = 0
+	DefaultJWTGroupsClaim           = "groups"
+	DefaultJWTTenantClaim           = "tenant"
+	DefaultNamespace                = "default"
+	DefaultQuotaReconcileInterval   = 15 * time.Second
+	DefaultStorageMaxWait           = 100 * time.Millisecond
+	DefaultTracingEndpoint          = "localhost:4317"
+	DefaultTracingSampleRatio       = 1.0
+	DefaultTracingServiceName       = "shared-lock"
+	DefaultMetricsKeyPrefixSep      = "/"
+	DefaultMetricsMaxKeyPrefixes    = 100
+	DefaultActiveLocksInterval      = 30 * time.Second
+	DefaultAuditMaxSizeMB           = 100
+	DefaultAuditMaxBackups          = 5
+	DefaultAuditBufferSize          = 4096
+	DefaultAuditKeepaliveSampling   = 1.0
+	DefaultHistoryMaxAge            = 7 * 24 * time.Hour
+	DefaultHistoryMaxEvents         = 1000
+	DefaultHistoryBufferSize        = 4096
+	DefaultHistoryPruneInterval     = time.Hour
 )
 
 type Config struct {
-	Server  ServerCfg
-	Storage StorageCfg
-	Cache   CacheCfg
-	Debug   bool
+	Server     ServerCfg     `yaml:"server" toml:"server"`
+	Storage    StorageCfg    `yaml:"storage" toml:"storage"`
+	Cache      CacheCfg      `yaml:"cache" toml:"cache"`
+	Lease      LeaseCfg      `yaml:"lease" toml:"lease"`
+	Auth       AuthCfg       `yaml:"auth" toml:"auth"`
+	Authz      AuthzCfg      `yaml:"authz" toml:"authz"`
+	Namespaces NamespacesCfg `yaml:"namespaces" toml:"namespaces"`
+	RateLimit  RateLimitCfg  `yaml:"rate_limit" toml:"rate_limit"`
+	Tracing    TracingCfg    `yaml:"tracing" toml:"tracing"`
+	Metrics    MetricsCfg    `yaml:"metrics" toml:"metrics"`
+	Audit      AuditCfg      `yaml:"audit" toml:"audit"`
+	History    HistoryCfg    `yaml:"history" toml:"history"`
+	LogLevel   string        `yaml:"log_level" toml:"log_level"`
+	Debug      bool          `yaml:"debug" toml:"debug"`
 }
 
 type ServerCfg struct {
-	Port         string
-	PPROFEnabled bool
-	Timeout      ServerTimeout
+	Port         string        `yaml:"port" toml:"port"`
+	PPROFEnabled bool          `yaml:"pprof_enabled" toml:"pprof_enabled"`
+	Timeout      ServerTimeout `yaml:"timeout" toml:"timeout"`
+	TLS          ServerTLSCfg  `yaml:"tls" toml:"tls"`
 }
 
 type ServerTimeout struct {
-	Read     time.Duration
-	Write    time.Duration
-	Idle     time.Duration
-	Shutdown time.Duration
+	Read     time.Duration `yaml:"read" toml:"read"`
+	Write    time.Duration `yaml:"write" toml:"write"`
+	Idle     time.Duration `yaml:"idle" toml:"idle"`
+	Shutdown time.Duration `yaml:"shutdown" toml:"shutdown"`
 }
 
+// ServerTLSCfg terminates TLS in the HTTP server. The certificate, key and
+// client CA files are watched and reloaded when they change. ClientAuth is
+// "none", "request" (verify a client certificate if one is sent) or
+// "require".
+type ServerTLSCfg struct {
+	Enabled    bool   `yaml:"enabled" toml:"enabled"`
+	CertPath   string `yaml:"cert_path" toml:"cert_path"`
+	KeyPath    string `yaml:"key_path" toml:"key_path"`
+	CACertPath string `yaml:"ca_cert_path" toml:"ca_cert_path"`
+	MinVersion string `yaml:"min_version" toml:"min_version"`
+	ClientAuth string `yaml:"client_auth" toml:"client_auth"`
+}
+
+// StorageCfg selects the storage backend. MaxConcurrentRequests caps the
+// requests in flight to it (0 means unlimited); requests that cannot start
+// within MaxWait are rejected.
 type StorageCfg struct {
-	Type string `validate:"required" oneof:"etcd mock"`
-	Etcd EtcdCfg
-	Mock MockCfg
+	Type                  string        `yaml:"type" toml:"type" validate:"required" oneof:"etcd mock"`
+	MaxConcurrentRequests int           `yaml:"max_concurrent_requests" toml:"max_concurrent_requests"`
+	MaxWait               time.Duration `yaml:"max_wait" toml:"max_wait"`
+	Etcd                  EtcdCfg       `yaml:"etcd" toml:"etcd"`
+	Mock                  MockCfg       `yaml:"mock" toml:"mock"`
 }
 
 type MockCfg struct {
 }
 
 type EtcdCfg struct {
-	EtcdAddrList         []string
-	TLSEnabled           bool
-	ServerCACertPath     string
-	ServerClientCertPath string
-	ServerClientKeyPath  string
+	EtcdAddrList         []string `yaml:"addr_list" toml:"addr_list"`
+	TLSEnabled           bool     `yaml:"tls_enabled" toml:"tls_enabled"`
+	ServerCACertPath     string   `yaml:"ca_cert_path" toml:"ca_cert_path"`
+	ServerClientCertPath string   `yaml:"client_cert_path" toml:"client_cert_path"`
+	ServerClientKeyPath  string   `yaml:"client_key_path" toml:"client_key_path"`
 }
 
+// CacheCfg configures the in-memory lease cache. Entries are kept coherent
+// with storage through a watch; while the watch is down they are cached for
+// at most FallbackTTL. Dead lease IDs and free keys are remembered for
+// NegativeTTL, zero disables this.
 type CacheCfg struct {
-	Enabled bool
-	Size    int
+	Enabled     bool          `yaml:"enabled" toml:"enabled"`
+	Size        int           `yaml:"size" toml:"size"`
+	FallbackTTL time.Duration `yaml:"fallback_ttl" toml:"fallback_ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl" toml:"negative_ttl"`
 }
 
-func NewConfig() *Config {
-	etcdEndpointsList, err := checkEtcdEndpointsList(getEnv("SHARED_LOCK_ETCD_ADDR_LIST", DefaultEtcdAddrList))
-	if err != nil {
-		log.Fatal(err)
-	}
+// LeaseCfg is the TTL policy applied to lease requests. DefaultTTL is used
+// when the client does not send a valid x-lease-ttl header, requested TTLs
+// are clamped to [MinTTL, MaxTTL] and a zero MaxTTL means no upper bound.
+type LeaseCfg struct {
+	DefaultTTL time.Duration `yaml:"default_ttl" toml:"default_ttl"`
+	MinTTL     time.Duration `yaml:"min_ttl" toml:"min_ttl"`
+	MaxTTL     time.Duration `yaml:"max_ttl" toml:"max_ttl"`
+}
+
+// AuthCfg enables authentication of API requests. Every configured method
+// is tried in order: static bearer tokens, JWT and client certificates.
+type AuthCfg struct {
+	Enabled    bool   `yaml:"enabled" toml:"enabled"`
+	TokensFile string `yaml:"tokens_file" toml:"tokens_file"`
+	JWT        JWTCfg `yaml:"jwt" toml:"jwt"`
+	MTLS       bool   `yaml:"mtls" toml:"mtls"`
+}
+
+type JWTCfg struct {
+	JWKSFile    string `yaml:"jwks_file" toml:"jwks_file"`
+	Issuer      string `yaml:"issuer" toml:"issuer"`
+	Audience    string `yaml:"audience" toml:"audience"`
+	GroupsClaim string `yaml:"groups_claim" toml:"groups_claim"`
+	TenantClaim string `yaml:"tenant_claim" toml:"tenant_claim"`
+}
+
+// AuthzCfg restricts what authenticated principals may do. Policies are
+// evaluated on every lease operation: a matching deny policy always wins,
+// otherwise a matching allow policy is required.
+type AuthzCfg struct {
+	Enabled  bool        `yaml:"enabled" toml:"enabled"`
+	Policies []PolicyCfg `yaml:"policies" toml:"policies"`
+}
+
+// PolicyCfg grants (or, with effect "deny", forbids) operations on keys
+// under Prefixes to the listed principals and members of the listed groups.
+// "*" matches any principal, group, operation or key.
+type PolicyCfg struct {
+	Name       string   `yaml:"name" toml:"name"`
+	Effect     string   `yaml:"effect" toml:"effect"`
+	Principals []string `yaml:"principals" toml:"principals"`
+	Groups     []string `yaml:"groups" toml:"groups"`
+	Prefixes   []string `yaml:"prefixes" toml:"prefixes"`
+	Operations []string `yaml:"operations" toml:"operations"`
+}
+
+// NamespacesCfg gives every tenant an isolated key space. Callers whose
+// principal has a tenant are confined to the namespace of that name, other
+// callers choose one with the x-namespace header or get Default. Limits are
+// keyed by namespace, "*" applies to namespaces without their own entry.
+// Usage is tracked in memory and recounted from storage every
+// QuotaReconcileInterval.
+type NamespacesCfg struct {
+	Enabled                bool                       `yaml:"enabled" toml:"enabled"`
+	Default                string                     `yaml:"default" toml:"default"`
+	Limits                 map[string]NamespaceLimits `yaml:"limits" toml:"limits"`
+	QuotaReconcileInterval time.Duration              `yaml:"quota_reconcile_interval" toml:"quota_reconcile_interval"`
+}
+
+// NamespaceLimits caps the usage of a namespace. Zero means unlimited.
+// MaxLeaseSeconds limits the sum of the TTLs of all held locks and
+// MaxLocksPerPrincipal applies to each authenticated principal separately.
+type NamespaceLimits struct {
+	MaxLocks             int `yaml:"max_locks" toml:"max_locks"`
+	MaxLocksPerPrincipal int `yaml:"max_locks_per_principal" toml:"max_locks_per_principal"`
+	MaxLeaseSeconds      int `yaml:"max_lease_seconds" toml:"max_lease_seconds"`
+	MaxValueBytes        int `yaml:"max_value_bytes" toml:"max_value_bytes"`
+}
+
+// RateLimitCfg throttles /lease and /keepalive requests with token buckets
+// per client identity (the authenticated principal, otherwise the remote
+// address) and per lock key.
+type RateLimitCfg struct {
+	Enabled   bool    `yaml:"enabled" toml:"enabled"`
+	PerClient RateCfg `yaml:"per_client" toml:"per_client"`
+	PerKey    RateCfg `yaml:"per_key" toml:"per_key"`
+}
+
+// RateCfg is a token bucket refilled with Rate tokens per second up to
+// Burst. A zero Rate disables the limit.
+type RateCfg struct {
+	Rate  float64 `yaml:"rate" toml:"rate"`
+	Burst int     `yaml:"burst" toml:"burst"`
+}
+
+// TracingCfg configures OpenTelemetry tracing. Spans are exported over
+// OTLP/gRPC to Endpoint; a SampleRatio of 1 records every trace that is not
+// already sampled by the caller.
+type TracingCfg struct {
+	Enabled     bool    `yaml:"enabled" toml:"enabled"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+}
+
+// MetricsCfg configures the Prometheus metrics. Lock metrics are labelled
+// with the part of the key before KeyPrefixSeparator; once MaxKeyPrefixes
+// distinct prefixes were seen, further ones are reported as "other".
+// Active locks are counted every ActiveLocksInterval, 0 disables counting.
+type MetricsCfg struct {
+	KeyPrefixSeparator  string        `yaml:"key_prefix_separator" toml:"key_prefix_separator"`
+	MaxKeyPrefixes      int           `yaml:"max_key_prefixes" toml:"max_key_prefixes"`
+	ActiveLocksInterval time.Duration `yaml:"active_locks_interval" toml:"active_locks_interval"`
+}
+
+// AuditCfg configures the audit log of lock operations. Events are written
+// as JSON lines to stdout and/or File, which is rotated at MaxSizeMB keeping
+// MaxBackups old files. Up to BufferSize events wait to be written; further
+// ones are dropped. Only KeepaliveSampleRatio of the successful keepalives
+// are recorded.
+type AuditCfg struct {
+	Enabled              bool    `yaml:"enabled" toml:"enabled"`
+	Stdout               bool    `yaml:"stdout" toml:"stdout"`
+	File                 string  `yaml:"file" toml:"file"`
+	MaxSizeMB            int     `yaml:"max_size_mb" toml:"max_size_mb"`
+	MaxBackups           int     `yaml:"max_backups" toml:"max_backups"`
+	BufferSize           int     `yaml:"buffer_size" toml:"buffer_size"`
+	KeepaliveSampleRatio float64 `yaml:"keepalive_sample_ratio" toml:"keepalive_sample_ratio"`
+}
 
+// HistoryCfg configures the history of lock grants and releases kept per
+// key in storage. Events older than MaxAge and beyond the newest MaxEvents of
+// a key are dropped, 0 disables either limit. The histories of all keys are
+// pruned every PruneInterval, 0 leaves them to be pruned when written. Up to
+// BufferSize events wait to be stored; further ones are dropped.
+type HistoryCfg struct {
+	Enabled       bool          `yaml:"enabled" toml:"enabled"`
+	MaxAge        time.Duration `yaml:"max_age" toml:"max_age"`
+	MaxEvents     int           `yaml:"max_events" toml:"max_events"`
+	BufferSize    int           `yaml:"buffer_size" toml:"buffer_size"`
+	PruneInterval time.Duration `yaml:"prune_interval" toml:"prune_interval"`
+}
+
+// NewConfig returns the built-in default configuration. Use Load to apply a
+// configuration file and environment overrides on top of it.
+func NewConfig() *Config {
 	return &Config{
 		Server: ServerCfg{
-			Port:         getEnv("SHARED_LOCK_SERVER_PORT", DefaultServerPort),
-			PPROFEnabled: getEnv("SHARED_LOCK_PPROF_ENABLED", bool(DefaultServerPPROFEnabled)),
+			Port:         DefaultServerPort,
+			PPROFEnabled: DefaultServerPPROFEnabled,
 			Timeout: ServerTimeout{
-				Read:     getEnv("SHARED_LOCK_SERVER_READ_TIMEOUT", DefaultServerReadTimeout),
-				Write:    getEnv("SHARED_LOCK_SERVER_WRITE_TIMEOUT", DefaultServerWriteTimeout),
-				Idle:     getEnv("SHARED_LOCK_SERVER_IDLE_TIMEOUT", DefaultServerIdleTimeout),
-				Shutdown: getEnv("SHARED_LOCK_SERVER_SHUTDOWN_TIMEOUT", DefaultServerShutdownTimeout),
+				Read:     DefaultServerReadTimeout,
+				Write:    DefaultServerWriteTimeout,
+				Idle:     DefaultServerIdleTimeout,
+				Shutdown: DefaultServerShutdownTimeout,
+			},
+			TLS: ServerTLSCfg{
+				MinVersion: DefaultServerTLSMinVersion,
+				ClientAuth: DefaultServerTLSClientAuth,
 			},
 		},
 		Storage: StorageCfg{
-			Type: getEnv("SHARED_LOCK_STORAGE_TYPE", DefaultStorageType),
+			Type:    DefaultStorageType,
+			MaxWait: DefaultStorageMaxWait,
 			Etcd: EtcdCfg{
-				EtcdAddrList:         etcdEndpointsList,
-				TLSEnabled:           getEnv("SHARED_LOCK_ETCD_TLS", DefaultEtcdTLSEnabled),
-				ServerCACertPath:     getEnv("SHARED_LOCK_CA_CERT_PATH", DefaultEtcdServerCACertPath),
-				ServerClientCertPath: getEnv("SHARED_LOCK_CLIENT_CERT_PATH", DefaultEtcdServerClientCertPath),
-				ServerClientKeyPath:  getEnv("SHARED_LOCK_CLIENT_KEY_PATH", DefaultEtcdServerClientKeyPath),
+				EtcdAddrList:         splitList(DefaultEtcdAddrList),
+				TLSEnabled:           DefaultEtcdTLSEnabled,
+				ServerCACertPath:     DefaultEtcdServerCACertPath,
+				ServerClientCertPath: DefaultEtcdServerClientCertPath,
+				ServerClientKeyPath:  DefaultEtcdServerClientKeyPath,
 			},
 		},
 		Cache: CacheCfg{
-			Enabled: getEnv("SHARED_LOCK_CACHE_ENABLED", DefaultCacheEnabled),
-			Size:    getEnv("SHARED_LOCK_CACHE_SIZE", DefaultCacheSize),
+			Enabled:     DefaultCacheEnabled,
+			Size:        DefaultCacheSize,
+			FallbackTTL: DefaultCacheFallbackTTL,
+			NegativeTTL: DefaultCacheNegativeTTL,
+		},
+		Lease: LeaseCfg{
+			DefaultTTL: DefaultLeaseTTL,
+			MinTTL:     DefaultLeaseMinTTL,
+			MaxTTL:     DefaultLeaseMaxTTL,
+		},
+		Auth: AuthCfg{
+			JWT: JWTCfg{
+				GroupsClaim: DefaultJWTGroupsClaim,
+				TenantClaim: DefaultJWTTenantClaim,
+			},
+		},
+		Authz: AuthzCfg{
+			Policies: []PolicyCfg{},
+		},
+		Namespaces: NamespacesCfg{
+			Default:                DefaultNamespace,
+			Limits:                 map[string]NamespaceLimits{},
+			QuotaReconcileInterval: DefaultQuotaReconcileInterval,
+		},
+		Tracing: TracingCfg{
+			Endpoint:    DefaultTracingEndpoint,
+			SampleRatio: DefaultTracingSampleRatio,
+			ServiceName: DefaultTracingServiceName,
+		},
+		Metrics: MetricsCfg{
+			KeyPrefixSeparator:  DefaultMetricsKeyPrefixSep,
+			MaxKeyPrefixes:      DefaultMetricsMaxKeyPrefixes,
+			ActiveLocksInterval: DefaultActiveLocksInterval,
+		},
+		Audit: AuditCfg{
+			MaxSizeMB:            DefaultAuditMaxSizeMB,
+			MaxBackups:           DefaultAuditMaxBackups,
+			BufferSize:           DefaultAuditBufferSize,
+			KeepaliveSampleRatio: DefaultAuditKeepaliveSampling,
+		},
+		History: HistoryCfg{
+			MaxAge:        DefaultHistoryMaxAge,
+			MaxEvents:     DefaultHistoryMaxEvents,
+			BufferSize:    DefaultHistoryBufferSize,
+			PruneInterval: DefaultHistoryPruneInterval,
 		},
-		Debug: getEnv("SHARED_LOCK_DEBUG", bool(DefaultDebugMode)),
+		LogLevel: DefaultLogLevel,
+		Debug:    DefaultDebugMode,
 	}
 }
 
-func getEnv[T any](key string, defaultVal T) T {
-	if value, exists := os.LookupEnv(key); exists {
-		switch any(defaultVal).(type) {
-		case string:
-			return any(value).(T)
-		case int:
-			if intVal, err := strconv.Atoi(value); err == nil {
-				return any(intVal).(T)
-			}
-		case bool:
-			if boolVal, err := strconv.ParseBool(value); err == nil {
-				return any(boolVal).(T)
-			}
+// Load builds the effective configuration: defaults, then the optional
+// configuration file at path, then SHARED_LOCK_* environment variables.
+// The result is validated and every problem found is reported at once.
+func Load(path string) (*Config, error) {
+	cfg := NewConfig()
+
+	if path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return nil, fmt.Errorf("failed to load configuration file %v: %w", path, err)
 		}
 	}
 
-	return defaultVal
+	if err := applyEnv(cfg); err != nil {
+		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
 }
 
-func checkEtcdEndpointsList(etcdEndpointsList string) ([]string, error) {
-	etcdEndpoints := strings.Split(etcdEndpointsList, ",")
-	if len(etcdEndpoints) == 0 {
-		return nil, fmt.Errorf("no etcd endpoints provided")
+// ClampTTL applies the lease TTL policy to a requested TTL. A non-positive
+// request falls back to the default TTL.
+func (c LeaseCfg) ClampTTL(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		requested = c.DefaultTTL
+	}
+	if c.MinTTL > 0 && requested < c.MinTTL {
+		return c.MinTTL
 	}
-	if strings.ContainsAny(etcdEndpointsList, ";|") {
-		return nil, fmt.Errorf("invalid separator in etcd endpoints. Use comma (,) to separate endpoints")
+	if c.MaxTTL > 0 && requested > c.MaxTTL {
+		return c.MaxTTL
 	}
 
-	for _, endpoint := range etcdEndpoints {
-		if endpoint = strings.TrimSpace(endpoint); endpoint == "" {
-			return nil, fmt.Errorf("empty etcd endpoint provided")
+	return requested
+}
+
+// Names returns the namespaces named in the configuration: the default and
+// those with limits of their own.
+func (c NamespacesCfg) Names() []string {
+	var names []string
+	if c.Default != "" {
+		names = append(names, c.Default)
+	}
+	for name := range c.Limits {
+		if name != "*" {
+			names = append(names, name)
 		}
 	}
 
-	return etcdEndpoints, nil
+	return names
+}
+
+// LimitsFor returns the limits that apply to namespace.
+func (c NamespacesCfg) LimitsFor(namespace string) NamespaceLimits {
+	if limits, exists := c.Limits[namespace]; exists {
+		return limits
+	}
+
+	return c.Limits["*"]
 }
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, NewConfig(), cfg)
+}
+
+func TestLoad_Files(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			content: `
+server:
+  port: "9090"
+  timeout:
+    read: 30s
+storage:
+  type: etcd
+  etcd:
+    addr_list: [http://etcd-0:2379, http://etcd-1:2379]
+cache:
+  enabled: true
+  size: 50
+namespaces:
+  limits:
+    team-a: {max_locks: 5}
+`,
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			content: `
+[server]
+port = "9090"
+[server.timeout]
+read = "30s"
+[storage]
+type = "etcd"
+[storage.etcd]
+addr_list = ["http://etcd-0:2379", "http://etcd-1:2379"]
+[cache]
+enabled = true
+size = 50
+[namespaces.limits.team-a]
+max_locks = 5
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(writeConfigFile(t, tt.file, tt.content))
+			require.NoError(t, err)
+
+			assert.Equal(t, "9090", cfg.Server.Port)
+			assert.Equal(t, 30*time.Second, cfg.Server.Timeout.Read)
+			assert.Equal(t, DefaultServerWriteTimeout, cfg.Server.Timeout.Write)
+			assert.Equal(t, []string{"http://etcd-0:2379", "http://etcd-1:2379"}, cfg.Storage.Etcd.EtcdAddrList)
+			assert.True(t, cfg.Cache.Enabled)
+			assert.Equal(t, 50, cfg.Cache.Size)
+			assert.Equal(t, NamespaceLimits{MaxLocks: 5}, cfg.Namespaces.LimitsFor("team-a"))
+			assert.Equal(t, NamespaceLimits{}, cfg.Namespaces.LimitsFor("team-b"))
+		})
+	}
+}
+
+func TestLoad_EnvOverridesFile(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "server:\n  port: \"9090\"\n  timeout:\n    read: 30s\n")
+	t.Setenv("SHARED_LOCK_SERVER_PORT", "7070")
+	t.Setenv("SHARED_LOCK_SERVER_READ_TIMEOUT", "45s")
+	t.Setenv("SHARED_LOCK_ETCD_ADDR_LIST", "http://a:2379, http://b:2379")
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "7070", cfg.Server.Port)
+	assert.Equal(t, 45*time.Second, cfg.Server.Timeout.Read)
+	assert.Equal(t, []string{"http://a:2379", "http://b:2379"}, cfg.Storage.Etcd.EtcdAddrList)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		env      map[string]string
+		expected []string
+	}{
+		{
+			name:     "unparsable env duration",
+			env:      map[string]string{"SHARED_LOCK_SERVER_READ_TIMEOUT": "soon"},
+			expected: []string{"SHARED_LOCK_SERVER_READ_TIMEOUT"},
+		},
+		{
+			name:     "unknown yaml key",
+			file:     "config.yaml",
+			content:  "server:\n  prot: \"8080\"\n",
+			expected: []string{"prot"},
+		},
+		{
+			name:     "unknown toml key",
+			file:     "config.toml",
+			content:  "[cache]\nsise = 10\n",
+			expected: []string{"cache.sise"},
+		},
+		{
+			name:     "unsupported extension",
+			file:     "config.json",
+			content:  "{}",
+			expected: []string{"unsupported configuration file extension"},
+		},
+		{
+			name: "multiple validation errors",
+			env: map[string]string{
+				"SHARED_LOCK_SERVER_PORT":        "http",
+				"SHARED_LOCK_STORAGE_TYPE":       "redis",
+				"SHARED_LOCK_CACHE_ENABLED":      "true",
+				"SHARED_LOCK_CACHE_SIZE":         "0",
+				"SHARED_LOCK_CACHE_FALLBACK_TTL": "0s",
+				"SHARED_LOCK_ETCD_ADDR_LIST":     "",
+			},
+			expected: []string{"server.port", "storage.type", "cache.size", "cache.fallback_ttl"},
+		},
+		{
+			name:     "invalid etcd separator",
+			env:      map[string]string{"SHARED_LOCK_ETCD_ADDR_LIST": "http://a:2379;http://b:2379"},
+			expected: []string{"storage.etcd.addr_list"},
+		},
+		{
+			name: "incomplete server tls",
+			env: map[string]string{
+				"SHARED_LOCK_SERVER_TLS_ENABLED":     "true",
+				"SHARED_LOCK_SERVER_TLS_MIN_VERSION": "1.0",
+				"SHARED_LOCK_SERVER_TLS_CLIENT_AUTH": "require",
+			},
+			expected: []string{"server.tls.cert_path", "server.tls.key_path", "server.tls.min_version", "server.tls.ca_cert_path"},
+		},
+		{
+			name:     "invalid namespace limits",
+			file:     "config.yaml",
+			content:  "namespaces:\n  default: Default\n  limits:\n    team/a:\n      max_locks: -1\n",
+			expected: []string{"namespaces.default", "namespaces.limits: invalid namespace name \"team/a\"", "namespaces.limits.team/a: limits must not be negative"},
+		},
+		{
+			name: "invalid rate limits",
+			env: map[string]string{
+				"SHARED_LOCK_RATE_LIMIT_CLIENT_RATE":          "10",
+				"SHARED_LOCK_RATE_LIMIT_KEY_RATE":             "-1",
+				"SHARED_LOCK_STORAGE_MAX_CONCURRENT_REQUESTS": "-5",
+			},
+			expected: []string{"rate_limit.per_client.burst", "rate_limit.per_key.rate", "storage.max_concurrent_requests"},
+		},
+		{
+			name: "mtls without client certificates",
+			env: map[string]string{
+				"SHARED_LOCK_AUTH_ENABLED": "true",
+				"SHARED_LOCK_AUTH_MTLS":    "true",
+			},
+			expected: []string{"auth.mtls"},
+		},
+		{
+			name: "invalid tracing",
+			env: map[string]string{
+				"SHARED_LOCK_TRACING_ENABLED":      "true",
+				"SHARED_LOCK_TRACING_ENDPOINT":     "",
+				"SHARED_LOCK_TRACING_SAMPLE_RATIO": "1.5",
+			},
+			expected: []string{"tracing.endpoint", "tracing.sample_ratio"},
+		},
+		{
+			name: "invalid metrics",
+			env: map[string]string{
+				"SHARED_LOCK_METRICS_MAX_KEY_PREFIXES":      "-1",
+				"SHARED_LOCK_METRICS_ACTIVE_LOCKS_INTERVAL": "-1s",
+			},
+			expected: []string{"metrics.max_key_prefixes", "metrics.active_locks_interval"},
+		},
+		{
+			name: "audit log without sinks",
+			env: map[string]string{
+				"SHARED_LOCK_AUDIT_ENABLED":                "true",
+				"SHARED_LOCK_AUDIT_BUFFER_SIZE":            "0",
+				"SHARED_LOCK_AUDIT_KEEPALIVE_SAMPLE_RATIO": "2",
+			},
+			expected: []string{"audit: enable stdout or set file", "audit.buffer_size", "audit.keepalive_sample_ratio"},
+		},
+		{
+			name: "invalid history retention",
+			env: map[string]string{
+				"SHARED_LOCK_HISTORY_ENABLED":        "true",
+				"SHARED_LOCK_HISTORY_MAX_AGE":        "-1h",
+				"SHARED_LOCK_HISTORY_MAX_EVENTS":     "-1",
+				"SHARED_LOCK_HISTORY_BUFFER_SIZE":    "0",
+				"SHARED_LOCK_HISTORY_PRUNE_INTERVAL": "-1h",
+			},
+			expected: []string{"history.max_age", "history.max_events", "history.buffer_size", "history.prune_interval"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			path := ""
+			if tt.file != "" {
+				path = writeConfigFile(t, tt.file, tt.content)
+			}
+
+			_, err := Load(path)
+
+			require.Error(t, err)
+			for _, expected := range tt.expected {
+				assert.Contains(t, err.Error(), expected)
+			}
+		})
+	}
+}
+
+func TestPrint_RoundTrip(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Server.Timeout.Read = 42 * time.Second
+	cfg.Namespaces.Limits["*"] = NamespaceLimits{MaxLocks: 100}
+
+	var out bytes.Buffer
+	require.NoError(t, Print(&out, cfg))
+	assert.Contains(t, out.String(), "read: 42s")
+
+	loaded, err := Load(writeConfigFile(t, "printed.yaml", out.String()))
+	require.NoError(t, err)
+	assert.Equal(t, cfg, loaded)
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func applyEnv(cfg *Config) error {
+	errs := []error{
+		getEnv("SHARED_LOCK_SERVER_PORT", &cfg.Server.Port),
+		getEnv("SHARED_LOCK_PPROF_ENABLED", &cfg.Server.PPROFEnabled),
+		getEnv("SHARED_LOCK_SERVER_READ_TIMEOUT", &cfg.Server.Timeout.Read),
+		getEnv("SHARED_LOCK_SERVER_WRITE_TIMEOUT", &cfg.Server.Timeout.Write),
+		getEnv("SHARED_LOCK_SERVER_IDLE_TIMEOUT", &cfg.Server.Timeout.Idle),
+		getEnv("SHARED_LOCK_SERVER_SHUTDOWN_TIMEOUT", &cfg.Server.Timeout.Shutdown),
+		getEnv("SHARED_LOCK_SERVER_TLS_ENABLED", &cfg.Server.TLS.Enabled),
+		getEnv("SHARED_LOCK_SERVER_TLS_CERT_PATH", &cfg.Server.TLS.CertPath),
+		getEnv("SHARED_LOCK_SERVER_TLS_KEY_PATH", &cfg.Server.TLS.KeyPath),
+		getEnv("SHARED_LOCK_SERVER_TLS_CA_CERT_PATH", &cfg.Server.TLS.CACertPath),
+		getEnv("SHARED_LOCK_SERVER_TLS_MIN_VERSION", &cfg.Server.TLS.MinVersion),
+		getEnv("SHARED_LOCK_SERVER_TLS_CLIENT_AUTH", &cfg.Server.TLS.ClientAuth),
+		getEnv("SHARED_LOCK_STORAGE_TYPE", &cfg.Storage.Type),
+		getEnv("SHARED_LOCK_STORAGE_MAX_CONCURRENT_REQUESTS", &cfg.Storage.MaxConcurrentRequests),
+		getEnv("SHARED_LOCK_STORAGE_MAX_WAIT", &cfg.Storage.MaxWait),
+		getEnv("SHARED_LOCK_ETCD_ADDR_LIST", &cfg.Storage.Etcd.EtcdAddrList),
+		getEnv("SHARED_LOCK_ETCD_TLS", &cfg.Storage.Etcd.TLSEnabled),
+		getEnv("SHARED_LOCK_CA_CERT_PATH", &cfg.Storage.Etcd.ServerCACertPath),
+		getEnv("SHARED_LOCK_CLIENT_CERT_PATH", &cfg.Storage.Etcd.ServerClientCertPath),
+		getEnv("SHARED_LOCK_CLIENT_KEY_PATH", &cfg.Storage.Etcd.ServerClientKeyPath),
+		getEnv("SHARED_LOCK_CACHE_ENABLED", &cfg.Cache.Enabled),
+		getEnv("SHARED_LOCK_CACHE_SIZE", &cfg.Cache.Size),
+		getEnv("SHARED_LOCK_CACHE_FALLBACK_TTL", &cfg.Cache.FallbackTTL),
+		getEnv("SHARED_LOCK_CACHE_NEGATIVE_TTL", &cfg.Cache.NegativeTTL),
+		getEnv("SHARED_LOCK_LEASE_DEFAULT_TTL", &cfg.Lease.DefaultTTL),
+		getEnv("SHARED_LOCK_LEASE_MIN_TTL", &cfg.Lease.MinTTL),
+		getEnv("SHARED_LOCK_LEASE_MAX_TTL", &cfg.Lease.MaxTTL),
+		getEnv("SHARED_LOCK_AUTH_ENABLED", &cfg.Auth.Enabled),
+		getEnv("SHARED_LOCK_AUTH_TOKENS_FILE", &cfg.Auth.TokensFile),
+		getEnv("SHARED_LOCK_AUTH_JWKS_FILE", &cfg.Auth.JWT.JWKSFile),
+		getEnv("SHARED_LOCK_AUTH_JWT_ISSUER", &cfg.Auth.JWT.Issuer),
+		getEnv("SHARED_LOCK_AUTH_JWT_AUDIENCE", &cfg.Auth.JWT.Audience),
+		getEnv("SHARED_LOCK_AUTH_JWT_GROUPS_CLAIM", &cfg.Auth.JWT.GroupsClaim),
+		getEnv("SHARED_LOCK_AUTH_JWT_TENANT_CLAIM", &cfg.Auth.JWT.TenantClaim),
+		getEnv("SHARED_LOCK_AUTH_MTLS", &cfg.Auth.MTLS),
+		getEnv("SHARED_LOCK_AUTHZ_ENABLED", &cfg.Authz.Enabled),
+		getEnv("SHARED_LOCK_NAMESPACES_ENABLED", &cfg.Namespaces.Enabled),
+		getEnv("SHARED_LOCK_NAMESPACES_DEFAULT", &cfg.Namespaces.Default),
+		getEnv("SHARED_LOCK_NAMESPACES_QUOTA_RECONCILE_INTERVAL", &cfg.Namespaces.QuotaReconcileInterval),
+		getEnv("SHARED_LOCK_RATE_LIMIT_ENABLED", &cfg.RateLimit.Enabled),
+		getEnv("SHARED_LOCK_RATE_LIMIT_CLIENT_RATE", &cfg.RateLimit.PerClient.Rate),
+		getEnv("SHARED_LOCK_RATE_LIMIT_CLIENT_BURST", &cfg.RateLimit.PerClient.Burst),
+		getEnv("SHARED_LOCK_RATE_LIMIT_KEY_RATE", &cfg.RateLimit.PerKey.Rate),
+		getEnv("SHARED_LOCK_RATE_LIMIT_KEY_BURST", &cfg.RateLimit.PerKey.Burst),
+		getEnv("SHARED_LOCK_TRACING_ENABLED", &cfg.Tracing.Enabled),
+		getEnv("SHARED_LOCK_TRACING_ENDPOINT", &cfg.Tracing.Endpoint),
+		getEnv("SHARED_LOCK_TRACING_INSECURE", &cfg.Tracing.Insecure),
+		getEnv("SHARED_LOCK_TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio),
+		getEnv("SHARED_LOCK_TRACING_SERVICE_NAME", &cfg.Tracing.ServiceName),
+		getEnv("SHARED_LOCK_METRICS_KEY_PREFIX_SEPARATOR", &cfg.Metrics.KeyPrefixSeparator),
+		getEnv("SHARED_LOCK_METRICS_MAX_KEY_PREFIXES", &cfg.Metrics.MaxKeyPrefixes),
+		getEnv("SHARED_LOCK_METRICS_ACTIVE_LOCKS_INTERVAL", &cfg.Metrics.ActiveLocksInterval),
+		getEnv("SHARED_LOCK_AUDIT_ENABLED", &cfg.Audit.Enabled),
+		getEnv("SHARED_LOCK_AUDIT_STDOUT", &cfg.Audit.Stdout),
+		getEnv("SHARED_LOCK_AUDIT_FILE", &cfg.Audit.File),
+		getEnv("SHARED_LOCK_AUDIT_MAX_SIZE_MB", &cfg.Audit.MaxSizeMB),
+		getEnv("SHARED_LOCK_AUDIT_MAX_BACKUPS", &cfg.Audit.MaxBackups),
+		getEnv("SHARED_LOCK_AUDIT_BUFFER_SIZE", &cfg.Audit.BufferSize),
+		getEnv("SHARED_LOCK_AUDIT_KEEPALIVE_SAMPLE_RATIO", &cfg.Audit.KeepaliveSampleRatio),
+		getEnv("SHARED_LOCK_HISTORY_ENABLED", &cfg.History.Enabled),
+		getEnv("SHARED_LOCK_HISTORY_MAX_AGE", &cfg.History.MaxAge),
+		getEnv("SHARED_LOCK_HISTORY_MAX_EVENTS", &cfg.History.MaxEvents),
+		getEnv("SHARED_LOCK_HISTORY_BUFFER_SIZE", &cfg.History.BufferSize),
+		getEnv("SHARED_LOCK_HISTORY_PRUNE_INTERVAL", &cfg.History.PruneInterval),
+		getEnv("SHARED_LOCK_LOG_LEVEL", &cfg.LogLevel),
+		getEnv("SHARED_LOCK_DEBUG", &cfg.Debug),
+	}
+
+	return errors.Join(errs...)
+}
+
+// getEnv overwrites target with the parsed value of the environment variable
+// key, if it is set. Unparsable values are reported instead of ignored.
+func getEnv[T any](key string, target *T) error {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return nil
+	}
+
+	var parsed any
+	var err error
+
+	switch any(*target).(type) {
+	case string:
+		parsed = value
+	case int:
+		parsed, err = strconv.Atoi(value)
+	case float64:
+		parsed, err = strconv.ParseFloat(value, 64)
+	case bool:
+		parsed, err = strconv.ParseBool(value)
+	case time.Duration:
+		parsed, err = time.ParseDuration(value)
+	case []string:
+		parsed = splitList(value)
+	default:
+		return fmt.Errorf("%v: unsupported type %T", key, *target)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: cannot parse %q: %v", key, value, err)
+	}
+
+	*target = parsed.(T)
+	return nil
+}
+
+// splitList splits a comma-separated list, trimming whitespace around items.
+// Empty items are preserved so that validation can reject them.
+func splitList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return []string{}
+	}
+
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+
+	return items
+}
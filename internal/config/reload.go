@@ -0,0 +1,114 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// reloadableSettings lists the settings, by their configuration file path,
+// that the running service applies without a restart. A path also covers
+// every setting nested below it.
+var reloadableSettings = []string{
+	"log_level",
+	"debug",
+	"cache.size",
+	"cache.fallback_ttl",
+	"cache.negative_ttl",
+	"lease",
+	"authz",
+	"namespaces.default",
+	"namespaces.limits",
+	"rate_limit",
+	"audit.keepalive_sample_ratio",
+	"history.max_age",
+	"history.max_events",
+}
+
+// ReloadResult describes how a freshly loaded configuration differs from the
+// running one.
+type ReloadResult struct {
+	// Config is the configuration to run with: the running configuration
+	// with every reloadable change applied.
+	Config *Config
+	// Applied lists changed settings that take effect immediately.
+	Applied []string
+	// RestartRequired lists changed settings that were ignored because they
+	// only take effect after a restart.
+	RestartRequired []string
+}
+
+// Reload compares the running configuration with a newly loaded one and
+// merges only the settings that are safe to change at runtime.
+func Reload(running, loaded *Config) ReloadResult {
+	merged := *running
+
+	result := ReloadResult{Config: &merged}
+	for _, path := range diff(reflect.ValueOf(*running), reflect.ValueOf(*loaded), "") {
+		if !isReloadable(path) {
+			result.RestartRequired = append(result.RestartRequired, path)
+			continue
+		}
+
+		field(reflect.ValueOf(&merged).Elem(), path).Set(field(reflect.ValueOf(loaded).Elem(), path))
+		result.Applied = append(result.Applied, path)
+	}
+
+	return result
+}
+
+func isReloadable(path string) bool {
+	for _, setting := range reloadableSettings {
+		if path == setting || strings.HasPrefix(path, setting+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diff returns the configuration file paths of every leaf setting that
+// differs between a and b.
+func diff(a, b reflect.Value, prefix string) []string {
+	if a.Kind() != reflect.Struct {
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return nil
+		}
+		return []string{prefix}
+	}
+
+	var paths []string
+	for i := 0; i < a.NumField(); i++ {
+		name := settingName(a.Type().Field(i))
+		if name == "" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		paths = append(paths, diff(a.Field(i), b.Field(i), name)...)
+	}
+
+	return paths
+}
+
+func field(v reflect.Value, path string) reflect.Value {
+	for _, name := range strings.Split(path, ".") {
+		for i := 0; i < v.NumField(); i++ {
+			if settingName(v.Type().Field(i)) == name {
+				v = v.Field(i)
+				break
+			}
+		}
+	}
+
+	return v
+}
+
+func settingName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if name == "-" || !f.IsExported() {
+		return ""
+	}
+
+	return name
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	running := NewConfig()
+
+	loaded := NewConfig()
+	loaded.LogLevel = "warn"
+	loaded.Cache.Size = 10
+	loaded.Lease.MaxTTL = time.Minute
+	loaded.Server.Port = "9090"
+	loaded.Storage.Etcd.EtcdAddrList = []string{"http://etcd:2379"}
+
+	result := Reload(running, loaded)
+
+	assert.ElementsMatch(t, []string{"log_level", "cache.size", "lease.max_ttl"}, result.Applied)
+	assert.ElementsMatch(t, []string{"server.port", "storage.etcd.addr_list"}, result.RestartRequired)
+
+	assert.Equal(t, "warn", result.Config.LogLevel)
+	assert.Equal(t, 10, result.Config.Cache.Size)
+	assert.Equal(t, time.Minute, result.Config.Lease.MaxTTL)
+	assert.Equal(t, DefaultServerPort, result.Config.Server.Port)
+	assert.Equal(t, running.Storage.Etcd.EtcdAddrList, result.Config.Storage.Etcd.EtcdAddrList)
+
+	assert.Equal(t, DefaultCacheSize, running.Cache.Size, "running configuration must not be modified")
+}
+
+func TestReload_NoChanges(t *testing.T) {
+	result := Reload(NewConfig(), NewConfig())
+
+	assert.Empty(t, result.Applied)
+	assert.Empty(t, result.RestartRequired)
+	assert.Equal(t, NewConfig(), result.Config)
+}
+
+func TestLeaseCfg_ClampTTL(t *testing.T) {
+	policy := LeaseCfg{DefaultTTL: 10 * time.Second, MinTTL: 5 * time.Second, MaxTTL: time.Minute}
+
+	assert.Equal(t, 10*time.Second, policy.ClampTTL(0))
+	assert.Equal(t, 5*time.Second, policy.ClampTTL(time.Second))
+	assert.Equal(t, 30*time.Second, policy.ClampTTL(30*time.Second))
+	assert.Equal(t, time.Minute, policy.ClampTTL(time.Hour))
+
+	policy.MaxTTL = 0
+	assert.Equal(t, time.Hour, policy.ClampTTL(time.Hour))
+}
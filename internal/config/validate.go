@@ -0,0 +1,310 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Validate reports every invalid setting in the configuration, joined into a
+// single error.
+func (c *Config) Validate() error {
+	var errs []error
+
+	port, err := strconv.Atoi(c.Server.Port)
+	if err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port: %q is not a valid TCP port", c.Server.Port))
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.timeout.read", c.Server.Timeout.Read},
+		{"server.timeout.write", c.Server.Timeout.Write},
+		{"server.timeout.idle", c.Server.Timeout.Idle},
+		{"server.timeout.shutdown", c.Server.Timeout.Shutdown},
+	} {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%v: must be a positive duration", timeout.name))
+		}
+	}
+
+	errs = append(errs, c.Server.TLS.validate()...)
+
+	switch c.Storage.Type {
+	case "etcd":
+		if err = checkEtcdEndpointsList(c.Storage.Etcd.EtcdAddrList); err != nil {
+			errs = append(errs, fmt.Errorf("storage.etcd.addr_list: %v", err))
+		}
+		if c.Storage.Etcd.TLSEnabled {
+			errs = append(errs, requirePaths("when TLS is enabled",
+				"storage.etcd.ca_cert_path", c.Storage.Etcd.ServerCACertPath,
+				"storage.etcd.client_cert_path", c.Storage.Etcd.ServerClientCertPath,
+				"storage.etcd.client_key_path", c.Storage.Etcd.ServerClientKeyPath,
+			)...)
+		}
+	case "mock":
+	default:
+		errs = append(errs, fmt.Errorf("storage.type: unsupported storage type %q, use etcd or mock", c.Storage.Type))
+	}
+
+	if c.Storage.MaxConcurrentRequests < 0 {
+		errs = append(errs, fmt.Errorf("storage.max_concurrent_requests: must not be negative"))
+	}
+	if c.Storage.MaxWait < 0 {
+		errs = append(errs, fmt.Errorf("storage.max_wait: must not be negative"))
+	}
+
+	for _, limit := range []struct {
+		name  string
+		value RateCfg
+	}{
+		{"rate_limit.per_client", c.RateLimit.PerClient},
+		{"rate_limit.per_key", c.RateLimit.PerKey},
+	} {
+		if limit.value.Rate < 0 {
+			errs = append(errs, fmt.Errorf("%v.rate: must not be negative", limit.name))
+		}
+		if limit.value.Rate > 0 && limit.value.Burst < 1 {
+			errs = append(errs, fmt.Errorf("%v.burst: must be at least 1 when a rate is set", limit.name))
+		}
+	}
+
+	if c.Cache.Enabled && c.Cache.Size <= 0 {
+		errs = append(errs, fmt.Errorf("cache.size: must be positive when the cache is enabled"))
+	}
+	if c.Cache.Enabled && c.Cache.FallbackTTL <= 0 {
+		errs = append(errs, fmt.Errorf("cache.fallback_ttl: must be a positive duration when the cache is enabled"))
+	}
+	if c.Cache.NegativeTTL < 0 {
+		errs = append(errs, fmt.Errorf("cache.negative_ttl: must not be negative"))
+	}
+
+	if c.Lease.DefaultTTL <= 0 {
+		errs = append(errs, fmt.Errorf("lease.default_ttl: must be a positive duration"))
+	}
+	if c.Lease.MinTTL < 0 || c.Lease.MaxTTL < 0 {
+		errs = append(errs, fmt.Errorf("lease.min_ttl, lease.max_ttl: must not be negative"))
+	}
+	if c.Lease.MaxTTL > 0 && c.Lease.MinTTL > c.Lease.MaxTTL {
+		errs = append(errs, fmt.Errorf("lease.min_ttl: must not exceed lease.max_ttl"))
+	}
+
+	if c.Auth.Enabled && c.Auth.TokensFile == "" && c.Auth.JWT.JWKSFile == "" && !c.Auth.MTLS {
+		errs = append(errs, fmt.Errorf("auth: enabled without any method, set tokens_file, jwt.jwks_file or mtls"))
+	}
+	if c.Auth.Enabled && c.Auth.MTLS && (!c.Server.TLS.Enabled || c.Server.TLS.ClientAuth == "none") {
+		errs = append(errs, fmt.Errorf("auth.mtls: requires server.tls.enabled and server.tls.client_auth request or require"))
+	}
+
+	errs = append(errs, c.Authz.validate()...)
+	errs = append(errs, c.Namespaces.validate()...)
+
+	if c.Tracing.Enabled && c.Tracing.Endpoint == "" {
+		errs = append(errs, fmt.Errorf("tracing.endpoint: must be set when tracing is enabled"))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio: must be between 0 and 1"))
+	}
+	if c.Metrics.MaxKeyPrefixes < 0 {
+		errs = append(errs, fmt.Errorf("metrics.max_key_prefixes: must not be negative"))
+	}
+	if c.Metrics.ActiveLocksInterval < 0 {
+		errs = append(errs, fmt.Errorf("metrics.active_locks_interval: must not be negative"))
+	}
+	errs = append(errs, c.Audit.validate()...)
+	if c.History.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("history.max_age: must not be negative"))
+	}
+	if c.History.MaxEvents < 0 {
+		errs = append(errs, fmt.Errorf("history.max_events: must not be negative"))
+	}
+	if c.History.Enabled && c.History.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("history.buffer_size: must be positive"))
+	}
+	if c.History.PruneInterval < 0 {
+		errs = append(errs, fmt.Errorf("history.prune_interval: must not be negative"))
+	}
+
+	if _, err = log.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %v", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// TLSVersions maps the accepted server.tls.min_version values to their
+// crypto/tls constants.
+var TLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSClientAuthTypes maps the accepted server.tls.client_auth values to
+// their crypto/tls constants.
+var TLSClientAuthTypes = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+func (c ServerTLSCfg) validate() []error {
+	if !c.Enabled {
+		return nil
+	}
+
+	errs := requirePaths("when TLS is enabled",
+		"server.tls.cert_path", c.CertPath,
+		"server.tls.key_path", c.KeyPath,
+	)
+	if _, ok := TLSVersions[c.MinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("server.tls.min_version: unsupported version %q, use 1.2 or 1.3", c.MinVersion))
+	}
+	if _, ok := TLSClientAuthTypes[c.ClientAuth]; !ok {
+		errs = append(errs, fmt.Errorf("server.tls.client_auth: unsupported mode %q, use none, request or require", c.ClientAuth))
+	} else if c.ClientAuth != "none" {
+		errs = append(errs, requirePaths("to verify client certificates", "server.tls.ca_cert_path", c.CACertPath)...)
+	}
+
+	return errs
+}
+
+// requirePaths takes name/value pairs and reports every empty value.
+func requirePaths(reason string, pairs ...string) []error {
+	var errs []error
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			errs = append(errs, fmt.Errorf("%v: required %v", pairs[i], reason))
+		}
+	}
+
+	return errs
+}
+
+func checkEtcdEndpointsList(etcdEndpoints []string) error {
+	if len(etcdEndpoints) == 0 {
+		return fmt.Errorf("no etcd endpoints provided")
+	}
+
+	for _, endpoint := range etcdEndpoints {
+		if strings.ContainsAny(endpoint, ";|") {
+			return fmt.Errorf("invalid separator in etcd endpoints. Use comma (,) to separate endpoints")
+		}
+		if strings.TrimSpace(endpoint) == "" {
+			return fmt.Errorf("empty etcd endpoint provided")
+		}
+	}
+
+	return nil
+}
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9_.-]{0,61}[a-z0-9])?$`)
+
+// IsValidNamespace reports whether name can be used as a namespace: 1 to 63
+// lowercase letters, digits, '-', '_' or '.', starting and ending with a
+// letter or digit.
+func IsValidNamespace(name string) bool {
+	return namespacePattern.MatchString(name)
+}
+
+func (c NamespacesCfg) validate() []error {
+	var errs []error
+
+	if c.Default != "" && !IsValidNamespace(c.Default) {
+		errs = append(errs, fmt.Errorf("namespaces.default: invalid namespace name %q", c.Default))
+	}
+
+	names := make([]string, 0, len(c.Limits))
+	for name := range c.Limits {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if name != "*" && !IsValidNamespace(name) {
+			errs = append(errs, fmt.Errorf("namespaces.limits: invalid namespace name %q", name))
+		}
+		limits := c.Limits[name]
+		if limits.MaxLocks < 0 || limits.MaxLocksPerPrincipal < 0 || limits.MaxLeaseSeconds < 0 || limits.MaxValueBytes < 0 {
+			errs = append(errs, fmt.Errorf("namespaces.limits.%v: limits must not be negative", name))
+		}
+	}
+
+	if c.Enabled && c.QuotaReconcileInterval <= 0 {
+		errs = append(errs, fmt.Errorf("namespaces.quota_reconcile_interval: must be a positive duration"))
+	}
+
+	return errs
+}
+
+// Operations that authorization policies can grant.
+var authzOperations = []string{"acquire", "keepalive", "release", "inspect", "list", "admin", "*"}
+
+func (c AuthzCfg) validate() []error {
+	var errs []error
+	names := make(map[string]bool)
+
+	for i, policy := range c.Policies {
+		field := fmt.Sprintf("authz.policies[%d]", i)
+		if policy.Name == "" {
+			errs = append(errs, fmt.Errorf("%v.name: required", field))
+		} else if names[policy.Name] {
+			errs = append(errs, fmt.Errorf("%v.name: duplicate policy name %q", field, policy.Name))
+		}
+		names[policy.Name] = true
+
+		if policy.Effect != "" && policy.Effect != "allow" && policy.Effect != "deny" {
+			errs = append(errs, fmt.Errorf("%v.effect: must be allow (default) or deny", field))
+		}
+		if len(policy.Principals) == 0 && len(policy.Groups) == 0 {
+			errs = append(errs, fmt.Errorf("%v: at least one principal or group is required", field))
+		}
+		if len(policy.Prefixes) == 0 {
+			errs = append(errs, fmt.Errorf("%v.prefixes: at least one prefix is required", field))
+		}
+		if len(policy.Operations) == 0 {
+			errs = append(errs, fmt.Errorf("%v.operations: at least one operation is required", field))
+		}
+		for _, operation := range policy.Operations {
+			if !slices.Contains(authzOperations, operation) {
+				errs = append(errs, fmt.Errorf("%v.operations: unknown operation %q, use one of %v", field, operation, authzOperations))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (c AuditCfg) validate() []error {
+	var errs []error
+
+	if c.KeepaliveSampleRatio < 0 || c.KeepaliveSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("audit.keepalive_sample_ratio: must be between 0 and 1"))
+	}
+	if !c.Enabled {
+		return errs
+	}
+
+	if !c.Stdout && c.File == "" {
+		errs = append(errs, fmt.Errorf("audit: enable stdout or set file when the audit log is enabled"))
+	}
+	if c.File != "" && c.MaxSizeMB <= 0 {
+		errs = append(errs, fmt.Errorf("audit.max_size_mb: must be positive"))
+	}
+	if c.MaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("audit.max_backups: must not be negative"))
+	}
+	if c.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("audit.buffer_size: must be positive"))
+	}
+
+	return errs
+}
@@ -0,0 +1,313 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/client"
+)
+
+const (
+	serverFlag    = "server"
+	tokenFlag     = "token"
+	namespaceFlag = "namespace"
+	caCertFlag    = "ca-cert"
+	certFlag      = "cert"
+	certKeyFlag   = "cert-key"
+	timeoutFlag   = "timeout"
+	outputFlag    = "output"
+
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// NewAdminCmds returns the operator commands that inspect and release locks
+// through a running server.
+func NewAdminCmds() []*cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list [prefix]",
+		Short: "List the held locks, optionally only those whose key starts with prefix",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			prefix := ""
+			if len(args) > 0 {
+				prefix = args[0]
+			}
+
+			leases, err := c.List(cmd.Context(), prefix)
+			if err != nil {
+				return err
+			}
+
+			return printOutput(cmd, leases, func(w io.Writer) {
+				printLeases(w, leases)
+			})
+		},
+	}
+
+	getCmd := &cobra.Command{
+		Use:   "get <key>",
+		Short: "Show the lock held on key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			lease, err := c.Get(cmd.Context(), args[0])
+			if errors.Is(err, client.ErrNotFound) {
+				return fmt.Errorf("%v is not held", args[0])
+			}
+			if err != nil {
+				return err
+			}
+
+			return printOutput(cmd, lease, func(w io.Writer) {
+				printLeases(w, []leasemanagement.LeaseDetails{*lease})
+			})
+		},
+	}
+
+	releaseCmd := &cobra.Command{
+		Use:   "release <key>",
+		Short: "Release the lock held on key, by a given lease or by force",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := args[0]
+			leaseID, _ := cmd.Flags().GetInt64("id")
+			force, _ := cmd.Flags().GetBool("force")
+			if leaseID == 0 && !force {
+				return fmt.Errorf("either --id or --force is required")
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			if force {
+				leaseID, err = c.ForceRelease(cmd.Context(), key)
+				if errors.Is(err, client.ErrNotFound) {
+					return fmt.Errorf("%v is not held", key)
+				}
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "Released %v held by lease %v\n", key, leaseID)
+				return nil
+			}
+
+			err = c.Release(cmd.Context(), key, leaseID)
+			if errors.Is(err, client.ErrNotFound) {
+				return fmt.Errorf("%v is not held by lease %v", key, leaseID)
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Released %v held by lease %v\n", key, leaseID)
+			return nil
+		},
+	}
+	releaseCmd.Flags().Int64("id", 0, "ID of the lease expected to hold the key")
+	releaseCmd.Flags().Bool("force", false, "Release the key whichever lease holds it")
+
+	transferCmd := &cobra.Command{
+		Use:   "transfer <key> <owner>",
+		Short: "Hand the lock held on key over to owner without releasing it",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, owner := args[0], args[1]
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			leaseID, err := c.Transfer(cmd.Context(), key, owner)
+			if errors.Is(err, client.ErrNotFound) {
+				return fmt.Errorf("%v is not held", key)
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Transferred %v to %v with lease %v\n", key, owner, leaseID)
+			return nil
+		},
+	}
+
+	watchCmd := &cobra.Command{
+		Use:   "watch [prefix]",
+		Short: "Print the locks taken and released, optionally only those whose key starts with prefix",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			prefix := ""
+			if len(args) > 0 {
+				prefix = args[0]
+			}
+			output, _ := cmd.Flags().GetString(outputFlag)
+
+			out := cmd.OutOrStdout()
+			encoder := json.NewEncoder(out)
+			err = c.Watch(cmd.Context(), prefix, func(event leasemanagement.WatchEvent) error {
+				if output == outputJSON {
+					return encoder.Encode(event)
+				}
+				_, err := fmt.Fprintf(out, "%v\t%-8v\t%v\t%v\n", time.Now().Format(time.RFC3339), event.Type, event.Key, formatLeaseID(event.LeaseID))
+				return err
+			})
+			if errors.Is(err, cmd.Context().Err()) {
+				return nil
+			}
+
+			return err
+		},
+	}
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Count the held locks by key prefix and owner",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			stats, err := c.Stats(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			return printOutput(cmd, stats, func(w io.Writer) {
+				fmt.Fprintf(w, "LOCKS\t%d\n\n", stats.Locks)
+				printCounts(w, "PREFIX", stats.Prefixes)
+				fmt.Fprintln(w)
+				printCounts(w, "OWNER", stats.Owners)
+			})
+		},
+	}
+
+	cmds := []*cobra.Command{listCmd, getCmd, releaseCmd, transferCmd, watchCmd, statsCmd}
+	for _, cmd := range cmds {
+		addClientFlags(cmd)
+		cmd.Flags().StringP(outputFlag, "o", outputTable, "Output format, table or json")
+	}
+
+	return cmds
+}
+
+func addClientFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String(serverFlag, envOr("SHARED_LOCK_ADDR", client.DefaultServer), "Base URL of the shared-lock server (env SHARED_LOCK_ADDR)")
+	flags.String(tokenFlag, os.Getenv("SHARED_LOCK_TOKEN"), "Bearer token to authenticate with (env SHARED_LOCK_TOKEN)")
+	flags.StringP(namespaceFlag, "n", os.Getenv("SHARED_LOCK_NAMESPACE"), "Namespace of the locks (env SHARED_LOCK_NAMESPACE)")
+	flags.String(caCertFlag, "", "CA certificate to verify the server with")
+	flags.String(certFlag, "", "Client certificate for mTLS")
+	flags.String(certKeyFlag, "", "Client key for mTLS")
+	flags.Duration(timeoutFlag, 10*time.Second, "Timeout of each request")
+}
+
+func newClient(cmd *cobra.Command) (*client.Client, error) {
+	flags := cmd.Flags()
+	if output, err := flags.GetString(outputFlag); err == nil && output != outputTable && output != outputJSON {
+		return nil, fmt.Errorf("unknown output format %q, use %v or %v", output, outputTable, outputJSON)
+	}
+
+	var opts client.Options
+	opts.Server, _ = flags.GetString(serverFlag)
+	opts.Token, _ = flags.GetString(tokenFlag)
+	opts.Namespace, _ = flags.GetString(namespaceFlag)
+	opts.CACertPath, _ = flags.GetString(caCertFlag)
+	opts.CertPath, _ = flags.GetString(certFlag)
+	opts.KeyPath, _ = flags.GetString(certKeyFlag)
+	opts.Timeout, _ = flags.GetDuration(timeoutFlag)
+
+	return client.New(opts)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// printOutput writes v as JSON or, for the table output, calls printTable
+// with a writer aligning tab separated columns.
+func printOutput(cmd *cobra.Command, v any, printTable func(io.Writer)) error {
+	output, _ := cmd.Flags().GetString(outputFlag)
+	if output == outputJSON {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	printTable(w)
+	return w.Flush()
+}
+
+func printLeases(w io.Writer, leases []leasemanagement.LeaseDetails) {
+	fmt.Fprintln(w, "KEY\tLEASE ID\tTTL\tOWNER\tAGE\tLABELS")
+	for _, lease := range leases {
+		age := "-"
+		if !lease.CreatedAt.IsZero() {
+			age = time.Since(lease.CreatedAt).Truncate(time.Second).String()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%vs/%vs\t%v\t%v\t%v\n",
+			lease.Key, lease.ID, lease.TTL, lease.GrantedTTL, orDash(lease.Owner), age, formatLabels(lease.Labels))
+	}
+}
+
+func printCounts(w io.Writer, title string, counts map[string]int) {
+	fmt.Fprintf(w, "%v\tLOCKS\n", title)
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(w, "%v\t%d\n", orDash(name), counts[name])
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, name+"="+labels[name])
+	}
+
+	return orDash(strings.Join(pairs, ","))
+}
+
+func formatLeaseID(leaseID int64) string {
+	if leaseID == 0 {
+		return "-"
+	}
+
+	return fmt.Sprint(leaseID)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
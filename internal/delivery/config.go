@@ -0,0 +1,46 @@
+package delivery
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/tentens-tech/shared-lock/internal/config"
+)
+
+const configFlag = "config"
+
+func NewConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect service configuration",
+	}
+
+	printCmd := &cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration (defaults, config file and environment overrides)",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			configuration, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			return config.Print(cmd.OutOrStdout(), configuration)
+		},
+	}
+	addConfigFlag(printCmd)
+
+	cmd.AddCommand(printCmd)
+
+	return cmd
+}
+
+func addConfigFlag(cmd *cobra.Command) {
+	cmd.Flags().StringP(configFlag, "c", "", "Path to a YAML (.yaml, .yml) or TOML (.toml) configuration file")
+}
+
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	path, err := cmd.Flags().GetString(configFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.Load(path)
+}
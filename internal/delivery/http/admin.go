@@ -0,0 +1,94 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+)
+
+type adminRequest struct {
+	Key   string `json:"key"`
+	Owner string `json:"owner,omitempty"`
+}
+
+type adminResponse struct {
+	Key   string `json:"key"`
+	ID    int64  `json:"id"`
+	Owner string `json:"owner,omitempty"`
+}
+
+func (s *Server) handleForceRelease(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	leaseID, err := s.app.ForceRelease(r.Context(), request.Key)
+	if writeAdminError(w, err) {
+		return
+	}
+
+	log.Infof("Lease %v on key %v was force-released", leaseID, request.Key)
+	writeJSON(w, http.StatusOK, adminResponse{Key: request.Key, ID: leaseID})
+}
+
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	request, ok := readAdminRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	leaseID, err := s.app.TransferLease(r.Context(), request.Key, request.Owner)
+	if writeAdminError(w, err) {
+		return
+	}
+
+	log.Infof("Key %v was transferred to %v with lease %v", request.Key, request.Owner, leaseID)
+	writeJSON(w, http.StatusOK, adminResponse{Key: request.Key, ID: leaseID, Owner: request.Owner})
+}
+
+func readAdminRequest(w http.ResponseWriter, r *http.Request, withOwner bool) (adminRequest, bool) {
+	var request adminRequest
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Errorf("Failed to read request body, %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return request, false
+	}
+
+	if err = json.Unmarshal(body, &request); err != nil || request.Key == "" || withOwner && request.Owner == "" {
+		log.Errorf("Failed to unmarshal admin request body, %v", err)
+		if withOwner {
+			http.Error(w, "Request body must be a JSON object with key and owner", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Request body must be a JSON object with key", http.StatusBadRequest)
+		}
+		return request, false
+	}
+
+	return request, true
+}
+
+// writeAdminError writes the response for a failed admin operation and
+// reports whether err was one.
+func writeAdminError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	if writeInvalidKey(w, err) || writeDenied(w, err) || writeOverloaded(w, err) {
+		return true
+	}
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		http.Error(w, "Lease not found", http.StatusNotFound)
+		return true
+	}
+
+	log.Errorf("Failed to run admin operation, %v", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	return true
+}
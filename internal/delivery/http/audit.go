@@ -0,0 +1,26 @@
+package http
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/audit"
+)
+
+// audited attaches the client address to the request context for the audit
+// log.
+func (s *Server) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(audit.WithClientAddress(r.Context(), remoteHost(r))))
+	})
+}
+
+// remoteHost is the IP address of the client without the port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+)
+
+// authenticate rejects requests without valid credentials and attaches the
+// authenticated principal to the request context.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.authenticator == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := s.authenticator.Authenticate(r)
+		if err != nil {
+			status := "invalid"
+			if errors.Is(err, auth.ErrNoCredentials) {
+				status = "missing"
+			}
+			metrics.AuthRequests.WithLabelValues("none", status).Inc()
+
+			log.Warnf("Unauthenticated request to %v from %v: %v", r.URL.Path, r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="shared-lock"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		metrics.AuthRequests.WithLabelValues(principal.Method, "success").Inc()
+		log.Debugf("Authenticated %v via %v", principal.Name, principal.Method)
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
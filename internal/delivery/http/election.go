@@ -0,0 +1,132 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+)
+
+type campaignRequest struct {
+	Value string `json:"value"`
+}
+
+type campaignResponse struct {
+	ID     int64 `json:"id"`
+	Leader bool  `json:"leader"`
+}
+
+type resignRequest struct {
+	ID int64 `json:"id"`
+}
+
+func (s *Server) handleCampaign(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var request campaignRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Errorf("Failed to read request body, %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			log.Errorf("Failed to unmarshal campaign request body, %v", err)
+			http.Error(w, "Request body must be a JSON object with the value of the candidate", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s.rateLimited(w, r, "election:"+name) {
+		return
+	}
+
+	leaseTTL, err := time.ParseDuration(r.Header.Get(defaultLeaseTTLHeader))
+	if err != nil {
+		leaseTTL = 0
+	}
+
+	leaseID, leader, err := s.app.Campaign(r.Context(), name, leaseTTL, request.Value)
+	if writeDenied(w, err) || writeQuotaExceeded(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to campaign in election %v, %v", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Debugf("Lease %v campaigns in election %v, leader: %v", leaseID, name, leader)
+	writeJSON(w, http.StatusCreated, campaignResponse{ID: leaseID, Leader: leader})
+}
+
+func (s *Server) handleElection(w http.ResponseWriter, r *http.Request) {
+	election, err := s.app.Election(r.Context(), r.PathValue("name"))
+	if writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to look up election, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if election.Leader == nil {
+		http.Error(w, "Election has no leader", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, election)
+}
+
+func (s *Server) handleObserve(w http.ResponseWriter, r *http.Request) {
+	events, err := s.app.ObserveLeader(r.Context(), r.PathValue("name"))
+	if writeDenied(w, err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to observe election, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeStream(w, events)
+}
+
+func (s *Server) handleResign(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var request resignRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Errorf("Failed to read request body, %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if err = json.Unmarshal(body, &request); err != nil || request.ID == 0 {
+		log.Errorf("Failed to unmarshal resign request body, %v", err)
+		http.Error(w, "Request body must be a JSON object with the lease id of the candidate", http.StatusBadRequest)
+		return
+	}
+
+	err = s.app.Resign(r.Context(), name, request.ID)
+	if writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		http.Error(w, "Lease is not a candidate of the election", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to resign from election %v, %v", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Debugf("Lease %v resigned from election %v", request.ID, name)
+	w.WriteHeader(http.StatusOK)
+}
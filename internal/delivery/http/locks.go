@@ -0,0 +1,189 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+)
+
+// locksPath is the collection of locks in version 1 of the API. A lock is
+// addressed as locksPath + "/" + key.
+const locksPath = "/v1/locks"
+
+type lockRequest struct {
+	Value  string            `json:"value"`
+	Labels map[string]string `json:"labels"`
+}
+
+type lockResponse struct {
+	Key string `json:"key"`
+	ID  int64  `json:"id"`
+}
+
+// handleAcquire takes the lock on the key in the path. A held key is answered
+// with 409 and the lease id of the holder.
+func (s *Server) handleAcquire(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveLeaseOperation(metrics.LeaseOperationGet, start)
+	}()
+
+	key, ok := lockKey(w, r.PathValue("key"))
+	if !ok {
+		return
+	}
+
+	var request lockRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Errorf("Failed to read request body, %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			log.Errorf("Failed to unmarshal lock request body, %v", err)
+			http.Error(w, "Request body must be a JSON object with the value and labels of the lock", http.StatusBadRequest)
+			return
+		}
+	}
+
+	leaseStatus, leaseID, ok := s.createLease(w, r, leasemanagement.Lease{
+		Key:    key,
+		Value:  request.Value,
+		Labels: request.Labels,
+	})
+	if !ok {
+		return
+	}
+
+	switch leaseStatus {
+	case storage.StatusCreated:
+		writeJSON(w, http.StatusCreated, lockResponse{Key: key, ID: leaseID})
+	case storage.StatusAccepted:
+		writeJSON(w, http.StatusConflict, lockResponse{Key: key, ID: leaseID})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handleLock answers the holder of the key in the path.
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request) {
+	if _, ok := lockKey(w, r.PathValue("key")); !ok {
+		return
+	}
+
+	s.handleInspect(w, r)
+}
+
+// handleLockHistory answers the history of the key in the path.
+func (s *Server) handleLockHistory(w http.ResponseWriter, r *http.Request) {
+	if _, ok := lockKey(w, r.PathValue("key")); !ok {
+		return
+	}
+
+	s.handleHistory(w, r)
+}
+
+// handleUnlock releases the key in the path if it is held by the lease in
+// the id parameter.
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveLeaseOperation(metrics.LeaseOperationRelease, start)
+	}()
+
+	key, ok := lockKey(w, r.PathValue("key"))
+	if !ok {
+		return
+	}
+	leaseID, ok := lockLeaseID(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.app.ReleaseLease(r.Context(), key, leaseID)
+	if writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		http.Error(w, "Lock is not held by the given lease id", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Debugf("Lease %v released key %v", leaseID, key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLockKeepalive prolongs the lease in the id parameter, provided that
+// it holds the key in the path.
+func (s *Server) handleLockKeepalive(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveLeaseOperation(metrics.LeaseOperationProlong, start)
+	}()
+
+	key, ok := lockKey(w, r.PathValue("key"))
+	if !ok {
+		return
+	}
+	leaseID, ok := lockLeaseID(w, r)
+	if !ok {
+		return
+	}
+
+	if s.rateLimited(w, r, "lease:"+strconv.FormatInt(leaseID, 10)) {
+		return
+	}
+
+	err := s.app.ReviveLock(r.Context(), key, leaseID)
+	if writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		http.Error(w, "Lock is not held by the given lease id", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Warnf("Failed to prolong lease: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Debugf("Lease %v prolonged successfully", leaseID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockKey answers 400 unless key is one a lock can be taken on.
+func lockKey(w http.ResponseWriter, key string) (string, bool) {
+	if err := leasemanagement.ValidateKey(key); err != nil {
+		writeInvalidKey(w, err)
+		return "", false
+	}
+
+	return key, true
+}
+
+// lockLeaseID reads the lease id from the id parameter, answering 400 if it
+// is missing or not an integer.
+func lockLeaseID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	leaseID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || leaseID == 0 {
+		http.Error(w, "id parameter must be the lease id holding the lock", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return leaseID, true
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/application/namespace"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+)
+
+// namespaced resolves the namespace of the request and attaches it to the
+// request context. It expects the principal to be attached already.
+func (s *Server) namespaced(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.app.Config().Namespaces
+		ns, err := namespace.Resolve(&cfg, auth.PrincipalFromContext(r.Context()), r.Header.Get(namespace.Header))
+
+		var forbidden *namespace.ForbiddenError
+		switch {
+		case errors.As(err, &forbidden):
+			log.Warnf("Namespace denied: %v", err)
+			writeJSON(w, http.StatusForbidden, struct {
+				Error string `json:"error"`
+				*namespace.ForbiddenError
+			}{
+				Error:          "forbidden",
+				ForbiddenError: forbidden,
+			})
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(namespace.WithNamespace(r.Context(), ns)))
+	})
+}
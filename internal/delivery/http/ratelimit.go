@@ -0,0 +1,72 @@
+package http
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/application/namespace"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+)
+
+// rateLimited applies the per-client and per-key rate limits to a request
+// for key and answers 429 when one of them is exhausted. It reports whether
+// the request was rejected.
+func (s *Server) rateLimited(w http.ResponseWriter, r *http.Request, key string) bool {
+	cfg := s.app.Config().RateLimit
+	if !cfg.Enabled {
+		return false
+	}
+
+	limit := "client"
+	allowed, retryAfter := s.clientLimiter.Allow(clientIdentity(r), cfg.PerClient.Rate, cfg.PerClient.Burst)
+	if allowed {
+		limit = "key"
+		allowed, retryAfter = s.keyLimiter.Allow(namespace.FromContext(r.Context())+"/"+key, cfg.PerKey.Rate, cfg.PerKey.Burst)
+	}
+	if allowed {
+		return false
+	}
+
+	metrics.RateLimitRejections.WithLabelValues(limit).Inc()
+	log.Debugf("Rate limited %v request from %v for key %v", limit, clientIdentity(r), key)
+	writeTooManyRequests(w, "rate_limited", limit, retryAfter)
+
+	return true
+}
+
+// writeOverloaded answers 429 when err reports that the storage concurrency
+// limit was reached, and reports whether it did.
+func writeOverloaded(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, storage.ErrOverloaded) {
+		return false
+	}
+
+	writeTooManyRequests(w, "overloaded", "storage", time.Second)
+	return true
+}
+
+func writeTooManyRequests(w http.ResponseWriter, reason, limit string, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeJSON(w, http.StatusTooManyRequests, struct {
+		Error string `json:"error"`
+		Limit string `json:"limit"`
+	}{
+		Error: reason,
+		Limit: limit,
+	})
+}
+
+// clientIdentity is the authenticated principal, otherwise the remote IP.
+func clientIdentity(r *http.Request) string {
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+		return "principal:" + principal.Name
+	}
+
+	return "addr:" + remoteHost(r)
+}
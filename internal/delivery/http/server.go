@@ -1,11 +1,14 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "net/http/pprof"
@@ -13,32 +16,100 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/tentens-tech/shared-lock/internal/application"
+	"github.com/tentens-tech/shared-lock/internal/application/authz"
 	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/application/quota"
 	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/certs"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/ratelimit"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
 )
 
 const (
 	defaultLeaseTTLHeader = "x-lease-ttl"
-	defaultLeaseDuration  = 10 * time.Second
+	idempotencyKeyHeader  = "Idempotency-Key"
+	// maxIdempotencyKeyLength bounds the idempotency keys kept in etcd.
+	maxIdempotencyKeyLength = 255
+
+	historySuffix       = "/history"
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
 )
 
 type Server struct {
-	app    *application.Application
-	Server *http.Server
+	app           *application.Application
+	authenticator auth.Authenticator
+	clientLimiter *ratelimit.Limiter
+	keyLimiter    *ratelimit.Limiter
+	Server        *http.Server
 }
 
-func New(app *application.Application) *Server {
+// New creates the HTTP delivery for app. A nil authenticator disables
+// authentication.
+func New(app *application.Application, authenticator auth.Authenticator) *Server {
 	return &Server{
-		app: app,
+		app:           app,
+		authenticator: authenticator,
+		clientLimiter: ratelimit.New(),
+		keyLimiter:    ratelimit.New(),
 	}
 }
 
 func (s *Server) Start(cfg *config.ServerCfg) error {
+	s.Server = &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      s.Handler(cfg),
+		ReadTimeout:  cfg.Timeout.Read,
+		WriteTimeout: cfg.Timeout.Write,
+		IdleTimeout:  cfg.Timeout.Idle,
+	}
+
+	if !cfg.TLS.Enabled {
+		return s.Server.ListenAndServe()
+	}
+
+	reloader, err := certs.NewReloader(cfg.TLS.CertPath, cfg.TLS.KeyPath, cfg.TLS.CACertPath)
+	if err != nil {
+		return fmt.Errorf("failed to load server TLS certificate: %v", err)
+	}
+	s.Server.TLSConfig = reloader.TLSConfig(config.TLSVersions[cfg.TLS.MinVersion], config.TLSClientAuthTypes[cfg.TLS.ClientAuth])
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go reloader.Watch(watchCtx, certs.DefaultWatchInterval)
+
+	// Certificates come from TLSConfig, so no files are passed here.
+	return s.Server.ListenAndServeTLS("", "")
+}
+
+func (s *Server) Handler(cfg *config.ServerCfg) http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/lease", s.handleLease)
-	mux.HandleFunc("/keepalive", s.handleKeepalive)
+	mux.Handle("PUT "+locksPath+"/{key...}", s.protect(s.handleAcquire))
+	mux.Handle("GET "+locksPath+"/{key...}", s.protect(s.handleLock))
+	mux.Handle("DELETE "+locksPath+"/{key...}", s.protect(s.handleUnlock))
+	mux.Handle("POST "+locksPath+"/{key...}", s.protect(s.handleLockKeepalive))
+	mux.Handle("GET "+locksPath, s.protect(s.handleList))
+	mux.Handle("GET /v1/history/{key...}", s.protect(s.handleLockHistory))
+
+	// The endpoints from before the versioned API remain as aliases.
+	mux.Handle("POST /lease", s.protect(s.handleLease))
+	mux.Handle("POST /keepalive", s.protect(s.handleKeepalive))
+	mux.Handle("POST /release", s.protect(s.handleRelease))
+	// The by-id segment takes precedence over keys as the more specific pattern.
+	mux.Handle("GET /lease/by-id/{id}", s.protect(s.handleLeaseByID))
+	mux.Handle("GET /lease/{key...}", s.protect(s.handleLeaseKey))
+	mux.Handle("GET /leases", s.protect(s.handleList))
+	mux.Handle("GET /watch", s.protect(s.handleWatch))
+	mux.Handle("GET /stats", s.protect(s.handleStats))
+	mux.Handle("POST /admin/release", s.protect(s.handleForceRelease))
+	mux.Handle("POST /admin/transfer", s.protect(s.handleTransfer))
+	mux.Handle("POST /election/{name}", s.protect(s.handleCampaign))
+	mux.Handle("GET /election/{name}", s.protect(s.handleElection))
+	mux.Handle("GET /election/{name}/observe", s.protect(s.handleObserve))
+	mux.Handle("POST /election/{name}/resign", s.protect(s.handleResign))
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.Handle("/metrics", promhttp.Handler())
 
@@ -46,27 +117,23 @@ func (s *Server) Start(cfg *config.ServerCfg) error {
 		mux.HandleFunc("/debug/pprof/", http.HandlerFunc(http.DefaultServeMux.ServeHTTP))
 	}
 
-	s.Server = &http.Server{
-		Addr:         ":" + cfg.Port,
-		Handler:      mux,
-		ReadTimeout:  cfg.Timeout.Read,
-		WriteTimeout: cfg.Timeout.Write,
-		IdleTimeout:  cfg.Timeout.Idle,
-	}
+	return mux
+}
 
-	return s.Server.ListenAndServe()
+// protect wraps the lease API handlers with tracing, authentication and
+// namespace resolution.
+func (s *Server) protect(handler http.HandlerFunc) http.Handler {
+	return s.traced(s.audited(s.authenticate(s.namespaced(handler))))
 }
 
 func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
-		metrics.LeaseOperationDuration.WithLabelValues(metrics.LeaseOperationGet).Observe(time.Since(start).Seconds())
+		metrics.ObserveLeaseOperation(metrics.LeaseOperationGet, start)
 	}()
 
 	var err error
 	var lease leasemanagement.Lease
-	var leaseID int64
-	var leaseStatus string
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -76,22 +143,17 @@ func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Debugf("Request body: %v", string(body))
+	_, decodeSpan := tracing.Start(r.Context(), "http.DecodeLease")
 	err = json.Unmarshal(body, &lease)
+	tracing.End(decodeSpan, err)
 	if err != nil {
 		log.Errorf("Failed to unmarshal request body, %v", err)
 		http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
 		return
 	}
 
-	leaseTTL, err := time.ParseDuration(r.Header.Get(defaultLeaseTTLHeader))
-	if err != nil {
-		log.Warnf("Can't parse value of %v header. Using defaultLeaseDuration for %v", defaultLeaseTTLHeader, lease.Key)
-		leaseTTL = defaultLeaseDuration
-	}
-
-	leaseStatus, leaseID, err = s.app.CreateLease(leaseTTL, lease)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	leaseStatus, leaseID, ok := s.createLease(w, r, lease)
+	if !ok {
 		return
 	}
 
@@ -111,7 +173,56 @@ func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// createLease acquires lease with the TTL and idempotency key of the request.
+// Failures are answered here, and reported by ok being false.
+func (s *Server) createLease(w http.ResponseWriter, r *http.Request, lease leasemanagement.Lease) (leaseStatus string, leaseID int64, ok bool) {
+	if s.rateLimited(w, r, lease.Key) {
+		return "", 0, false
+	}
+
+	ctx := r.Context()
+	if idempotencyKey := r.Header.Get(idempotencyKeyHeader); idempotencyKey != "" {
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("%v must not be longer than %v characters", idempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return "", 0, false
+		}
+		ctx = application.WithIdempotencyKey(ctx, idempotencyKey)
+	}
+
+	leaseTTL, err := time.ParseDuration(r.Header.Get(defaultLeaseTTLHeader))
+	if err != nil {
+		log.Warnf("Can't parse value of %v header. Using default lease TTL for %v", defaultLeaseTTLHeader, lease.Key)
+		leaseTTL = 0
+	}
+
+	leaseStatus, leaseID, err = s.app.CreateLease(ctx, leaseTTL, lease)
+	if err != nil {
+		if writeInvalidKey(w, err) || writeDenied(w, err) || writeQuotaExceeded(w, err) || writeOverloaded(w, err) {
+			return "", 0, false
+		}
+		if errors.Is(err, application.ErrIdempotencyKeyReused) {
+			http.Error(w, fmt.Sprintf("%v was used for another key", idempotencyKeyHeader), http.StatusUnprocessableEntity)
+			return "", 0, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", 0, false
+	}
+
+	// The granted TTL may be shorter than the one asked for, and keepalives
+	// have to keep up with it.
+	if leaseStatus == storage.StatusCreated {
+		w.Header().Set(defaultLeaseTTLHeader, s.app.LeaseTTL(leaseTTL).String())
+	}
+
+	return leaseStatus, leaseID, true
+}
+
 func (s *Server) handleKeepalive(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveLeaseOperation(metrics.LeaseOperationProlong, start)
+	}()
+
 	var err error
 	var leaseID int64
 
@@ -130,8 +241,15 @@ func (s *Server) handleKeepalive(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.rateLimited(w, r, "lease:"+strconv.FormatInt(leaseID, 10)) {
+		return
+	}
+
 	log.Debugf("Trying to revive lease: %v", leaseID)
-	err = s.app.ReviveLease(leaseID)
+	err = s.app.ReviveLease(r.Context(), leaseID)
+	if writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
 	if err != nil {
 		log.Warnf("Failed to prolong lease: %v", err)
 		http.Error(w, "Failed to prolong lease", http.StatusNoContent)
@@ -142,6 +260,280 @@ func (s *Server) handleKeepalive(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+type releaseRequest struct {
+	Key string `json:"key"`
+	ID  int64  `json:"id"`
+}
+
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveLeaseOperation(metrics.LeaseOperationRelease, start)
+	}()
+
+	var request releaseRequest
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Errorf("Failed to read request body, %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	if err = json.Unmarshal(body, &request); err != nil || request.Key == "" || request.ID == 0 {
+		log.Errorf("Failed to unmarshal release request body, %v", err)
+		http.Error(w, "Request body must be a JSON object with key and id", http.StatusBadRequest)
+		return
+	}
+
+	err = s.app.ReleaseLease(r.Context(), request.Key, request.ID)
+	if writeInvalidKey(w, err) || writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		http.Error(w, "Lease is not held by the given lease id", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Debugf("Lease %v released key %v", request.ID, request.Key)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleLeaseKey tells /lease/{key}/history apart from /lease/{key}, since a
+// wildcard must end the pattern. The lock on a key whose last segment is
+// "history" is inspected through /v1/locks/{key} instead.
+func (s *Server) handleLeaseKey(w http.ResponseWriter, r *http.Request) {
+	if key, ok := strings.CutSuffix(r.PathValue("key"), historySuffix); ok && key != "" {
+		r.SetPathValue("key", key)
+		s.handleHistory(w, r)
+		return
+	}
+
+	s.handleInspect(w, r)
+}
+
+func (s *Server) handleInspect(w http.ResponseWriter, r *http.Request) {
+	lease, err := s.app.InspectLease(r.Context(), r.PathValue("key"))
+	if writeInvalidKey(w, err) || writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		http.Error(w, "Lease not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to inspect lease, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, lease)
+}
+
+// handleHistory answers the grants and releases of the key in the path
+// between the RFC 3339 times in the from and to parameters, oldest first.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	query := r.URL.Query()
+
+	var from, to time.Time
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "from must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "to must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultHistoryLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxHistoryLimit {
+			http.Error(w, fmt.Sprintf("limit must be an integer between 1 and %d", maxHistoryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := s.app.LeaseHistory(r.Context(), key, from, to, limit)
+	if writeInvalidKey(w, err) || writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if errors.Is(err, application.ErrHistoryDisabled) {
+		http.Error(w, "Lock history is disabled", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to get lock history, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Key    string                         `json:"key"`
+		Events []leasemanagement.HistoryEvent `json:"events"`
+	}{
+		Key:    key,
+		Events: events,
+	})
+}
+
+func (s *Server) handleLeaseByID(w http.ResponseWriter, r *http.Request) {
+	leaseID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Lease id must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := s.app.LeaseByID(r.Context(), leaseID)
+	if writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if errors.Is(err, storage.ErrLeaseNotFound) {
+		http.Error(w, "Lease not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to look up lease by id, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, lease)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	leases, err := s.app.ListLeases(r.Context(), r.URL.Query().Get("prefix"))
+	if writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to list leases, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, leases)
+}
+
+// handleWatch streams the changes to the keys starting with the prefix
+// parameter as JSON lines until the client goes away.
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	events, err := s.app.WatchKeys(r.Context(), r.URL.Query().Get("prefix"))
+	if writeDenied(w, err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to watch leases, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeStream(w, events)
+}
+
+// writeStream answers with a JSON line per event until events is closed or
+// the client goes away.
+func writeStream[T any](w http.ResponseWriter, events <-chan T) {
+	// The stream outlives the write timeout of ordinary requests.
+	controller := http.NewResponseController(w)
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	_ = controller.Flush()
+
+	encoder := json.NewEncoder(w)
+	for event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.app.Stats(r.Context())
+	if writeDenied(w, err) || writeOverloaded(w, err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to count leases, %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// writeInvalidKey answers 400 when err is about a key a lock cannot be taken
+// on, and reports whether it did.
+func writeInvalidKey(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, leasemanagement.ErrInvalidKey) {
+		return false
+	}
+
+	http.Error(w, err.Error(), http.StatusBadRequest)
+	return true
+}
+
+// writeDenied answers 403 with the denying policy when err is an
+// authorization failure, and reports whether it did.
+func writeDenied(w http.ResponseWriter, err error) bool {
+	var denied *authz.DeniedError
+	if !errors.As(err, &denied) {
+		return false
+	}
+
+	writeJSON(w, http.StatusForbidden, struct {
+		Error string `json:"error"`
+		*authz.DeniedError
+	}{
+		Error:       "forbidden",
+		DeniedError: denied,
+	})
+
+	return true
+}
+
+// writeQuotaExceeded answers 429 with the exhausted quota when err is a
+// quota failure, and reports whether it did.
+func writeQuotaExceeded(w http.ResponseWriter, err error) bool {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+
+	writeJSON(w, http.StatusTooManyRequests, struct {
+		Error string `json:"error"`
+		*quota.ExceededError
+	}{
+		Error:         "quota_exceeded",
+		ExceededError: exceeded,
+	})
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to write JSON response, %v", err)
+	}
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
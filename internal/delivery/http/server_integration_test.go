@@ -0,0 +1,182 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/application"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/etcd"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/etcd/etcdtest"
+)
+
+func newEtcdTestServer(t *testing.T, configure ...func(*config.Config)) *httptest.Server {
+	t.Helper()
+
+	etcdServer := etcdtest.Start(t)
+	cfg := etcdServer.Config()
+	for _, apply := range configure {
+		apply(cfg)
+	}
+
+	etcdStorage, err := etcd.New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = etcdStorage.Client.Close()
+	})
+
+	app := application.New(context.Background(), cfg, etcdStorage, nil)
+	server := httptest.NewServer(New(app, nil).Handler(&cfg.Server))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func post(t *testing.T, url string, header http.Header, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(respBody)
+}
+
+func TestServerEtcd_LeaseLifecycle(t *testing.T) {
+	server := newEtcdTestServer(t)
+	header := http.Header{defaultLeaseTTLHeader: []string{"30s"}}
+	body := `{"key": "http-integration", "value": "holder"}`
+
+	status, leaseID := post(t, server.URL+"/lease", header, body)
+	require.Equal(t, http.StatusCreated, status)
+	_, err := strconv.ParseInt(leaseID, 10, 64)
+	require.NoError(t, err)
+
+	status, heldLeaseID := post(t, server.URL+"/lease", header, body)
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, leaseID, heldLeaseID)
+
+	status, _ = post(t, server.URL+"/keepalive", nil, leaseID)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = post(t, server.URL+"/keepalive", nil, "42")
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+func TestServerEtcd_Health(t *testing.T) {
+	server := newEtcdTestServer(t)
+
+	resp, err := http.Get(server.URL + "/health")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServerEtcd_Election(t *testing.T) {
+	// With authorization, keepalives of candidates are checked against the
+	// name of their election.
+	server := newEtcdTestServer(t, func(cfg *config.Config) {
+		cfg.Authz = config.AuthzCfg{
+			Enabled:  true,
+			Policies: []config.PolicyCfg{{Name: "all", Principals: []string{"*"}, Prefixes: []string{"*"}, Operations: []string{"*"}}},
+		}
+	})
+	header := http.Header{defaultLeaseTTLHeader: []string{"30s"}}
+
+	var first, second struct {
+		ID     int64 `json:"id"`
+		Leader bool  `json:"leader"`
+	}
+	status, body := post(t, server.URL+"/election/reporter", header, `{"value": "pod-a"}`)
+	require.Equal(t, http.StatusCreated, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &first))
+	assert.True(t, first.Leader)
+	status, body = post(t, server.URL+"/election/reporter", header, `{"value": "pod-b"}`)
+	require.Equal(t, http.StatusCreated, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &second))
+	assert.False(t, second.Leader)
+
+	status, _ = post(t, server.URL+"/keepalive", nil, strconv.FormatInt(second.ID, 10))
+	assert.Equal(t, http.StatusOK, status)
+
+	resp, err := http.Get(server.URL + "/election/reporter")
+	require.NoError(t, err)
+	var election application.Election
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&election))
+	resp.Body.Close()
+	require.NotNil(t, election.Leader)
+	assert.Equal(t, first.ID, election.Leader.ID)
+	assert.Equal(t, "pod-a", election.Leader.Value)
+	assert.Equal(t, 2, election.Candidates)
+
+	observed, err := http.Get(server.URL + "/election/reporter/observe")
+	require.NoError(t, err)
+	defer observed.Body.Close()
+	lines := bufio.NewScanner(observed.Body)
+	nextLeader := func() *leasemanagement.Candidate {
+		require.True(t, lines.Scan())
+		var event leasemanagement.LeaderEvent
+		require.NoError(t, json.Unmarshal(lines.Bytes(), &event))
+		return event.Leader
+	}
+	assert.Equal(t, first.ID, nextLeader().ID)
+
+	status, _ = post(t, server.URL+"/election/reporter/resign", nil, fmt.Sprintf(`{"id": %d}`, second.ID+1))
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = post(t, server.URL+"/election/reporter/resign", nil, fmt.Sprintf(`{"id": %d}`, first.ID))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, second.ID, nextLeader().ID, "the next candidate leads without waiting for the TTL")
+
+	status, _ = post(t, server.URL+"/election/reporter/resign", nil, fmt.Sprintf(`{"id": %d}`, second.ID))
+	require.Equal(t, http.StatusOK, status)
+	assert.Nil(t, nextLeader())
+
+	resp, err = http.Get(server.URL + "/election/reporter")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServerEtcd_IdempotencyKey(t *testing.T) {
+	server := newEtcdTestServer(t)
+	header := http.Header{defaultLeaseTTLHeader: []string{"30s"}, idempotencyKeyHeader: []string{"run-42"}}
+	body := `{"key": "idempotent"}`
+
+	status, leaseID := post(t, server.URL+"/lease", header, body)
+	require.Equal(t, http.StatusCreated, status)
+	status, retriedLeaseID := post(t, server.URL+"/lease", header, body)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, leaseID, retriedLeaseID)
+
+	status, _ = post(t, server.URL+"/lease", header, `{"key": "other"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+	status, _ = post(t, server.URL+"/release", nil, fmt.Sprintf(`{"key": "idempotent", "id": %v}`, leaseID))
+	require.Equal(t, http.StatusOK, status)
+
+	// The idempotency key is forgotten with the lease it was granted.
+	status, newLeaseID := post(t, server.URL+"/lease", header, body)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.NotEqual(t, leaseID, newLeaseID)
+}
@@ -7,17 +7,27 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tentens-tech/shared-lock/internal/application"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
 	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/auth"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/cache"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/mock"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 )
 
 func createTestConfig() *config.Config {
@@ -26,7 +36,7 @@ func createTestConfig() *config.Config {
 	return cfg
 }
 
-func createTestApplication(ctx context.Context, cfg *config.Config, storageConnection storage.Storage, leaseCache *cache.Cache) *application.Application {
+func createTestApplication(ctx context.Context, cfg *config.Config, storageConnection storage.Storage, leaseCache *cache.LeaseCache) *application.Application {
 	return application.New(ctx, cfg, storageConnection, leaseCache)
 }
 
@@ -62,10 +72,10 @@ func TestGetLeaseHandler(t *testing.T) {
 			ctx := context.Background()
 			cfg := createTestConfig()
 			storageConnection := mock.New()
-			leaseCache := cache.New(1000)
+			leaseCache := cache.NewLeaseCache(1000)
 
 			app := application.New(ctx, cfg, storageConnection, leaseCache)
-			server := New(app)
+			server := New(app, nil)
 
 			req := httptest.NewRequest(http.MethodPost, "/lease", strings.NewReader(tt.requestBody))
 			rec := httptest.NewRecorder()
@@ -81,7 +91,7 @@ func TestGetLeaseHandler(t *testing.T) {
 func TestGetLeaseHandlerConcurrent(t *testing.T) {
 	ctx := context.Background()
 	cfg := createTestConfig()
-	leaseCache := cache.New(1000)
+	leaseCache := cache.NewLeaseCache(1000)
 	storageConnection := mock.New()
 
 	numRequests := 100
@@ -89,7 +99,7 @@ func TestGetLeaseHandlerConcurrent(t *testing.T) {
 	wg.Add(numRequests)
 
 	app := createTestApplication(ctx, cfg, storageConnection, leaseCache)
-	server := New(app)
+	server := New(app, nil)
 
 	for i := 0; i < numRequests; i++ {
 		go func(i int) {
@@ -124,7 +134,7 @@ func TestGetLeaseHandlerConcurrent(t *testing.T) {
 func TestGetLeaseHandlerMemoryUsage(t *testing.T) {
 	ctx := context.Background()
 	cfg := createTestConfig()
-	leaseCache := cache.New(1000)
+	leaseCache := cache.NewLeaseCache(1000)
 	storageConnection := mock.New()
 
 	var m runtime.MemStats
@@ -134,7 +144,7 @@ func TestGetLeaseHandlerMemoryUsage(t *testing.T) {
 
 	numLeases := 1000
 	app := createTestApplication(ctx, cfg, storageConnection, leaseCache)
-	server := New(app)
+	server := New(app, nil)
 
 	for i := 0; i < numLeases; i++ {
 		leaseBody := map[string]string{
@@ -201,7 +211,7 @@ func TestKeepaliveHandler(t *testing.T) {
 			rr := httptest.NewRecorder()
 
 			app := createTestApplication(ctx, cfg, storageConnection, nil)
-			server := New(app)
+			server := New(app, nil)
 			server.handleKeepalive(rr, req)
 
 			assert.Equal(t, tt.expectedStatus, rr.Code)
@@ -222,7 +232,7 @@ func TestKeepaliveHandlerConcurrent(t *testing.T) {
 	wg.Add(numRequests)
 
 	app := createTestApplication(ctx, cfg, storageConnection, nil)
-	server := New(app)
+	server := New(app, nil)
 
 	for i := 0; i < numRequests; i++ {
 		go func() {
@@ -238,3 +248,573 @@ func TestKeepaliveHandlerConcurrent(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestAuthenticatedHandler(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	assert.NoError(t, os.WriteFile(tokensFile, []byte("secret ci\n"), 0o600))
+	authenticator, err := auth.NewTokenAuthenticator(tokensFile)
+	assert.NoError(t, err)
+
+	cfg := createTestConfig()
+	app := createTestApplication(context.Background(), cfg, mock.New(), nil)
+	handler := New(app, authenticator).Handler(&cfg.Server)
+
+	tests := []struct {
+		name           string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{name: "Missing token", path: "/lease", expectedStatus: http.StatusUnauthorized},
+		{name: "Invalid token", path: "/lease", token: "wrong", expectedStatus: http.StatusUnauthorized},
+		{name: "Valid token", path: "/lease", token: "secret", expectedStatus: http.StatusCreated},
+		{name: "Keepalive requires token", path: "/keepalive", expectedStatus: http.StatusUnauthorized},
+		{name: "Health is public", path: "/health", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"key": "auth-key"}`))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedStatus == http.StatusUnauthorized {
+				assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestLeaseResourceHandlers(t *testing.T) {
+	cfg := createTestConfig()
+	app := createTestApplication(context.Background(), cfg, mock.New(), cache.NewLeaseCache(1000))
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/lease", `{"key": "team/report", "value": "holder", "labels": {"env": "test"}}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = do(http.MethodGet, "/lease/team/report", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var lease struct {
+		Key    string            `json:"key"`
+		Value  string            `json:"value"`
+		Labels map[string]string `json:"labels"`
+		ID     int64             `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &lease))
+	assert.Equal(t, "team/report", lease.Key)
+	assert.Equal(t, "holder", lease.Value)
+	assert.Equal(t, "test", lease.Labels["env"])
+	assert.Equal(t, int64(123), lease.ID)
+
+	rec = do(http.MethodGet, "/lease/by-id/123", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"key":"team/report"`)
+
+	rec = do(http.MethodGet, "/lease/by-id/report", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodGet, "/lease/by-id/123/daily", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "deeper keys under by-id are inspected as keys")
+
+	rec = do(http.MethodGet, "/leases?prefix=team/", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"key":"team/report"`)
+
+	rec = do(http.MethodGet, "/leases?prefix=other/", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[]\n", rec.Body.String())
+
+	rec = do(http.MethodPost, "/release", `{"key": "team/report", "id": 999}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(http.MethodPost, "/release", `{"key": "team/report"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodPost, "/release", `{"key": "team/report", "id": 123}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(http.MethodGet, "/lease/team/report", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(http.MethodGet, "/lease/by-id/123", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestLockHandlers(t *testing.T) {
+	cfg := createTestConfig()
+	app := createTestApplication(context.Background(), cfg, mock.New(), cache.NewLeaseCache(1000))
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/v1/locks/team/report", `{"value": "holder", "labels": {"env": "test"}}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"key": "team/report", "id": 123}`, rec.Body.String())
+	assert.Equal(t, cfg.Lease.DefaultTTL.String(), rec.Header().Get("x-lease-ttl"), "the granted TTL is reported")
+
+	rec = do(http.MethodPut, "/v1/locks/team/report", "")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.JSONEq(t, `{"key": "team/report", "id": 123}`, rec.Body.String())
+
+	rec = do(http.MethodGet, "/v1/locks/team/report", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"value":"holder"`)
+
+	rec = do(http.MethodGet, "/v1/locks", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"key":"team/report"`)
+
+	rec = do(http.MethodPost, "/v1/locks/team/report?id=123", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = do(http.MethodPost, "/v1/locks/team/report?id=999", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(http.MethodPost, "/v1/locks/team/report", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodDelete, "/v1/locks/team/report?id=999", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(http.MethodDelete, "/v1/locks/team/report?id=123", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = do(http.MethodGet, "/v1/locks/team/report", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(http.MethodGet, "/lease", "")
+	assert.NotEqual(t, http.StatusCreated, rec.Code, "GET must not take a lock")
+	rec = do(http.MethodGet, "/v1/locks/team/report", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestLockHandlersInvalidKey(t *testing.T) {
+	cfg := createTestConfig()
+	app := createTestApplication(context.Background(), cfg, mock.New(), nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{name: "empty segment", method: http.MethodPut, path: "/v1/locks/team%2F%2Freport"},
+		{name: "parent segment", method: http.MethodPut, path: "/v1/locks/team/%2E%2E/report"},
+		{name: "disallowed character", method: http.MethodPut, path: "/v1/locks/team/report%20daily"},
+		{name: "too long", method: http.MethodPut, path: "/v1/locks/" + strings.Repeat("a", leasemanagement.MaxKeyLength+1)},
+		{name: "inspect", method: http.MethodGet, path: "/v1/locks/team/%2E%2E"},
+		{name: "history", method: http.MethodGet, path: "/v1/history/team/%2E%2E"},
+		{name: "release", method: http.MethodDelete, path: "/v1/locks/team/%2E%2E?id=123"},
+		{name: "keepalive", method: http.MethodPost, path: "/v1/locks/team/%2E%2E?id=123"},
+		{name: "legacy acquire", method: http.MethodPost, path: "/lease", body: `{"key": "../shared-lock-election/leader"}`},
+		{name: "legacy release", method: http.MethodPost, path: "/release", body: `{"key": "team//report", "id": 123}`},
+		{name: "legacy inspect", method: http.MethodGet, path: "/lease/team/%2E%2E"},
+		{name: "legacy history", method: http.MethodGet, path: "/lease/team/%2E%2E/history"},
+		{name: "force release", method: http.MethodPost, path: "/admin/release", body: `{"key": "../shared-lock-election/leader"}`},
+		{name: "transfer", method: http.MethodPost, path: "/admin/transfer", body: `{"key": "team/report daily", "owner": "worker-2"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "invalid key")
+		})
+	}
+}
+
+func TestAdminHandlers(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Authz = config.AuthzCfg{
+		Enabled: true,
+		Policies: []config.PolicyCfg{
+			{Name: "everyone", Principals: []string{"*"}, Prefixes: []string{"*"}, Operations: []string{"*", "admin"}},
+		},
+	}
+	app := createTestApplication(context.Background(), cfg, mock.New(), cache.NewLeaseCache(1000))
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("/lease", `{"key": "team/report"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = do("/admin/transfer", `{"key": "team/report"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do("/admin/transfer", `{"key": "team/other", "owner": "worker-2"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do("/admin/transfer", `{"key": "team/report", "owner": "worker-2"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"key": "team/report", "id": 124, "owner": "worker-2"}`, rec.Body.String())
+
+	rec = do("/admin/release", `{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do("/admin/release", `{"key": "team/report"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"key": "team/report", "id": 124}`, rec.Body.String())
+
+	rec = do("/admin/release", `{"key": "team/report"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminHandlersAuthzDisabled(t *testing.T) {
+	cfg := createTestConfig()
+	app := createTestApplication(context.Background(), cfg, mock.New(), nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("/lease", `{"key": "team/report"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = do("/admin/transfer", `{"key": "team/report", "owner": "worker-2"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"rule":"authz-disabled"`)
+
+	rec = do("/admin/release", `{"key": "team/report"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"rule":"authz-disabled"`)
+
+	rec = do("/lease", `{"key": "team/report"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code, "the lock is still held")
+}
+
+func TestHistoryHandler(t *testing.T) {
+	cfg := createTestConfig()
+	storageConnection := mock.New()
+	app := createTestApplication(context.Background(), cfg, storageConnection, nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("/lease/team/report/history")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "history is disabled")
+
+	app.SetHistory(storageConnection)
+	req := httptest.NewRequest(http.MethodPost, "/lease", strings.NewReader(`{"key": "team/report"}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	app.Close()
+
+	rec = do("/lease/team/report/history?from=2000-01-01T00:00:00Z&limit=10")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var history struct {
+		Key    string                         `json:"key"`
+		Events []leasemanagement.HistoryEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	assert.Equal(t, "team/report", history.Key)
+	require.Len(t, history.Events, 1)
+	assert.Equal(t, leasemanagement.HistoryGranted, history.Events[0].Type)
+
+	rec = do("/lease/team/report/history?to=2000-01-01T00:00:00Z")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"events":[]`)
+
+	for _, query := range []string{"from=yesterday", "to=1700000000", "limit=0", "limit=100000"} {
+		rec = do("/lease/team/report/history?" + query)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+
+	rec = do("/v1/history/team/report")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"key":"team/report"`)
+}
+
+func TestInspectKeyNamedHistory(t *testing.T) {
+	cfg := createTestConfig()
+	app := createTestApplication(context.Background(), cfg, mock.New(), nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/locks/jobs/history", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/locks/jobs/history", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"key":"jobs/history"`)
+
+	app.SetHistory(mock.New())
+	for path, key := range map[string]string{
+		"/lease/jobs/history":         "jobs",
+		"/lease/jobs/history/history": "jobs/history",
+		"/v1/history/jobs/history":    "jobs/history",
+	} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), `"key":"`+key+`"`, path)
+		assert.Contains(t, rec.Body.String(), `"events":[]`, path)
+	}
+	app.Close()
+}
+
+// watchingStorage reports the changes sent on events to the first watcher.
+type watchingStorage struct {
+	*mock.Storage
+	events chan storage.LeaseEvent
+}
+
+func (s *watchingStorage) WatchLeases(ctx context.Context, prefix string) (<-chan storage.LeaseEvent, error) {
+	return s.events, nil
+}
+
+func TestWatchHandler(t *testing.T) {
+	cfg := createTestConfig()
+	storageConnection := &watchingStorage{Storage: mock.New(), events: make(chan storage.LeaseEvent, 2)}
+	app := createTestApplication(context.Background(), cfg, storageConnection, nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	storageConnection.events <- storage.LeaseEvent{Key: "/shared-lock/team/report", LeaseID: 7}
+	storageConnection.events <- storage.LeaseEvent{Key: "/shared-lock/team/report", Deleted: true}
+	close(storageConnection.events)
+
+	req := httptest.NewRequest(http.MethodGet, "/watch?prefix=team/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"type":"acquired","key":"team/report","lease_id":7}`+"\n"+`{"type":"released","key":"team/report"}`+"\n", rec.Body.String())
+}
+
+func TestAuthorizationDenied(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Authz = config.AuthzCfg{
+		Enabled: true,
+		Policies: []config.PolicyCfg{
+			{Name: "team-a", Groups: []string{"team-a"}, Prefixes: []string{"teamA/"}, Operations: []string{"*"}},
+		},
+	}
+	storageConnection := mock.New()
+	storageConnection.ExistingLeases["/shared-lock/teamB/held"] = 456
+	app := createTestApplication(context.Background(), cfg, storageConnection, nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+	principal := &auth.Principal{Name: "ci", Groups: []string{"team-a"}}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "Acquire own prefix", method: http.MethodPost, path: "/lease", body: `{"key": "teamA/job"}`, expectedStatus: http.StatusCreated},
+		{name: "Acquire foreign prefix", method: http.MethodPost, path: "/lease", body: `{"key": "teamB/job"}`, expectedStatus: http.StatusForbidden},
+		{name: "Inspect foreign prefix", method: http.MethodGet, path: "/lease/teamB/job", expectedStatus: http.StatusForbidden},
+		{name: "Release foreign prefix", method: http.MethodPost, path: "/release", body: `{"key": "teamB/job", "id": 1}`, expectedStatus: http.StatusForbidden},
+		{name: "List foreign prefix", method: http.MethodGet, path: "/leases?prefix=teamB/", expectedStatus: http.StatusForbidden},
+		{name: "Keepalive", method: http.MethodPost, path: "/keepalive", body: "123", expectedStatus: http.StatusOK},
+		{name: "Keepalive foreign lease", method: http.MethodPost, path: "/keepalive", body: "456", expectedStatus: http.StatusForbidden},
+		{name: "Lease by id foreign lease", method: http.MethodGet, path: "/lease/by-id/456", expectedStatus: http.StatusForbidden},
+		{name: "Force release own prefix", method: http.MethodPost, path: "/admin/release", body: `{"key": "teamA/job"}`, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedStatus == http.StatusForbidden {
+				assert.Contains(t, rec.Body.String(), `"rule":"default-deny"`)
+			}
+		})
+	}
+}
+
+func TestStartTLSWithMissingCertificate(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Server.Port = "0"
+	cfg.Server.TLS = config.ServerTLSCfg{
+		Enabled:    true,
+		CertPath:   filepath.Join(t.TempDir(), "missing.crt"),
+		KeyPath:    filepath.Join(t.TempDir(), "missing.key"),
+		MinVersion: config.DefaultServerTLSMinVersion,
+		ClientAuth: config.DefaultServerTLSClientAuth,
+	}
+
+	err := New(nil, nil).Start(&cfg.Server)
+
+	assert.ErrorContains(t, err, "failed to load server TLS certificate")
+}
+
+func TestNamespacedHandler(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Namespaces = config.NamespacesCfg{
+		Enabled: true,
+		Default: config.DefaultNamespace,
+		Limits:  map[string]config.NamespaceLimits{"*": {MaxLocks: 1}},
+	}
+	app := createTestApplication(context.Background(), cfg, mock.New(), nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+	tenant := &auth.Principal{Name: "payments-job", Tenant: "payments"}
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		namespace      string
+		key            string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Default namespace", key: "job", expectedStatus: http.StatusCreated},
+		{name: "Requested namespace", namespace: "team-a", key: "job", expectedStatus: http.StatusCreated},
+		{name: "Tenant namespace", principal: tenant, key: "job", expectedStatus: http.StatusCreated},
+		{name: "Tenant in foreign namespace", principal: tenant, namespace: "team-a", key: "job", expectedStatus: http.StatusForbidden, expectedBody: `"tenant":"payments"`},
+		{name: "Invalid namespace", namespace: "Team A", key: "job", expectedStatus: http.StatusBadRequest},
+		{name: "Quota exhausted", namespace: "team-a", key: "other-job", expectedStatus: http.StatusTooManyRequests, expectedBody: `"quota":"max_locks"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/lease", strings.NewReader(fmt.Sprintf(`{"key": %q}`, tt.key)))
+			if tt.namespace != "" {
+				req.Header.Set("x-namespace", tt.namespace)
+			}
+			if tt.principal != nil {
+				req = req.WithContext(auth.WithPrincipal(req.Context(), tt.principal))
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.expectedBody)
+		})
+	}
+}
+
+func TestRateLimitedHandler(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.RateLimit = config.RateLimitCfg{
+		Enabled:   true,
+		PerClient: config.RateCfg{Rate: 0.01, Burst: 3},
+		PerKey:    config.RateCfg{Rate: 0.01, Burst: 2},
+	}
+	app := createTestApplication(context.Background(), cfg, mock.New(), nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		path           string
+		body           string
+		expectedStatus int
+		expectedLimit  string
+	}{
+		{name: "First request", remoteAddr: "10.0.0.1:1000", path: "/lease", body: `{"key": "hot"}`, expectedStatus: http.StatusCreated},
+		{name: "Same key from another client", remoteAddr: "10.0.0.2:1000", path: "/lease", body: `{"key": "hot"}`, expectedStatus: http.StatusAccepted},
+		{name: "Key exhausted", remoteAddr: "10.0.0.3:1000", path: "/lease", body: `{"key": "hot"}`, expectedStatus: http.StatusTooManyRequests, expectedLimit: "key"},
+		{name: "Other key", remoteAddr: "10.0.0.1:2000", path: "/lease", body: `{"key": "cold"}`, expectedStatus: http.StatusCreated},
+		{name: "Keepalive", remoteAddr: "10.0.0.1:3000", path: "/keepalive", body: "123", expectedStatus: http.StatusOK},
+		{name: "Client exhausted", remoteAddr: "10.0.0.1:4000", path: "/lease", body: `{"key": "warm"}`, expectedStatus: http.StatusTooManyRequests, expectedLimit: "client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedLimit != "" {
+				assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+				assert.Contains(t, rec.Body.String(), fmt.Sprintf(`"limit":%q`, tt.expectedLimit))
+			}
+		})
+	}
+}
+
+func TestTracedHandler(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewTracerProvider(config.TracingCfg{SampleRatio: 1}, sdktrace.WithSyncer(exporter))
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	_, err := tracing.Setup(context.Background(), config.TracingCfg{})
+	require.NoError(t, err)
+
+	cfg := createTestConfig()
+	app := createTestApplication(context.Background(), cfg, mock.New(), nil)
+	handler := New(app, nil).Handler(&cfg.Server)
+
+	req := httptest.NewRequest(http.MethodPost, "/lease", strings.NewReader(`{"key": "traced-key"}`))
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range exporter.GetSpans().Snapshots() {
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext().TraceID().String())
+		spans[span.Name()] = span
+	}
+
+	server, ok := spans["POST /lease"]
+	require.True(t, ok, "server span not recorded")
+	assert.Equal(t, "b7ad6b7169203331", server.Parent().SpanID().String())
+	assert.Contains(t, server.Attributes(), semconv.HTTPStatusCode(http.StatusCreated))
+
+	for child, parent := range map[string]string{
+		"application.CreateLease":    "POST /lease",
+		"leasemanagement.ClaimLease": "application.CreateLease",
+		"mock.CreateLease":           "leasemanagement.ClaimLease",
+	} {
+		require.Contains(t, spans, child)
+		assert.Equal(t, spans[parent].SpanContext().SpanID(), spans[child].Parent().SpanID(), child)
+	}
+}
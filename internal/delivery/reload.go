@@ -0,0 +1,117 @@
+package delivery
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/application"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+)
+
+const configWatchInterval = 5 * time.Second
+
+// configReloader re-reads the configuration file on SIGHUP or when its
+// content changes and applies the settings that are safe to change at runtime.
+type configReloader struct {
+	path     string
+	app      *application.Application
+	checksum [sha256.Size]byte
+}
+
+func newConfigReloader(path string, app *application.Application) *configReloader {
+	reloader := &configReloader{
+		path: path,
+		app:  app,
+	}
+	reloader.checksum, _ = fileChecksum(path)
+
+	return reloader
+}
+
+func (r *configReloader) Run(ctx context.Context, hangup <-chan os.Signal) {
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			r.reload("SIGHUP")
+		case <-ticker.C:
+			if r.path == "" {
+				continue
+			}
+			checksum, err := fileChecksum(r.path)
+			if err != nil {
+				log.Warnf("Failed to read configuration file %v: %v", r.path, err)
+				continue
+			}
+			if checksum != r.checksum {
+				r.checksum = checksum
+				r.reload("file change")
+			}
+		}
+	}
+}
+
+func (r *configReloader) reload(reason string) {
+	if r.path == "" {
+		log.Warnf("Ignoring configuration reload on %v: no configuration file was given", reason)
+		return
+	}
+
+	loaded, err := config.Load(r.path)
+	if err != nil {
+		log.Errorf("Configuration reload on %v failed, keeping the running configuration: %v", reason, err)
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return
+	}
+
+	result := config.Reload(r.app.Config(), loaded)
+	configureLogging(result.Config)
+	r.app.ApplyConfig(result.Config)
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+
+	if len(result.Applied) > 0 {
+		log.Infof("Configuration reloaded on %v, applied: %v", reason, result.Applied)
+	} else {
+		log.Infof("Configuration reloaded on %v, no runtime settings changed", reason)
+	}
+
+	if len(result.RestartRequired) > 0 {
+		log.Warnf("Configuration changes that require a restart were not applied: %v", result.RestartRequired)
+		metrics.ConfigRestartRequired.Set(1)
+	} else {
+		metrics.ConfigRestartRequired.Set(0)
+	}
+}
+
+func configureLogging(cfg *config.Config) {
+	level, err := log.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		level = log.InfoLevel
+	}
+	if cfg.Debug {
+		level = log.DebugLevel
+	}
+
+	log.SetLevel(level)
+}
+
+func fileChecksum(path string) ([sha256.Size]byte, error) {
+	if path == "" {
+		return [sha256.Size]byte{}, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	return sha256.Sum256(content), nil
+}
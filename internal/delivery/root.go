@@ -9,6 +9,8 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "shared-lock",
 	Short: "shared-lock server",
+	// Errors such as invalid configuration are not usage mistakes.
+	SilenceUsage: true,
 }
 
 func Execute(ctx context.Context) error {
@@ -24,5 +26,8 @@ func Execute(ctx context.Context) error {
 func initCommands(rootCmd *cobra.Command) {
 	rootCmd.AddCommand(
 		NewServe(),
+		NewConfigCmd(),
+		NewRunCmd(),
 	)
+	rootCmd.AddCommand(NewAdminCmds()...)
 }
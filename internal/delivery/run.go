@@ -0,0 +1,205 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/client"
+)
+
+const (
+	onBusyWait = "wait"
+	onBusySkip = "skip"
+	onBusyFail = "fail"
+)
+
+// forwardedSignals are passed on to the program run while holding a lock.
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// ExitError makes the process exit with Code without printing anything.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// NewRunCmd returns the command running a program while holding a lock.
+func NewRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run --key <key> [flags] -- <command> [args...]",
+		Short: "Run a command while holding a lock",
+		Long: `Run a command while holding a lock.
+
+The lock is acquired through the server and kept alive while the command
+runs; it is released when the command exits. Signals are forwarded to the
+command. Should the lock be lost, the command is terminated and killed after
+--kill-timeout. The exit status is the one of the command.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runLocked,
+	}
+
+	flags := cmd.Flags()
+	flags.String("key", "", "Key of the lock")
+	flags.Duration("ttl", 30*time.Second, "TTL of the lease, kept alive every third of it")
+	flags.String("value", "", "Value stored with the lock")
+	flags.StringToString("label", nil, "Labels stored with the lock, as key=value")
+	flags.String("on-busy", onBusyWait, "What to do when the lock is held: wait, skip (exit 0) or fail (exit 1)")
+	flags.Duration("retry-interval", 2*time.Second, "Interval between attempts while waiting for the lock")
+	flags.Duration("kill-timeout", 10*time.Second, "Time the command is given to exit after the lock is lost")
+	_ = cmd.MarkFlagRequired("key")
+	addClientFlags(cmd)
+
+	return cmd
+}
+
+func runLocked(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	key, _ := flags.GetString("key")
+	ttl, _ := flags.GetDuration("ttl")
+	onBusy, _ := flags.GetString("on-busy")
+	retryInterval, _ := flags.GetDuration("retry-interval")
+	killTimeout, _ := flags.GetDuration("kill-timeout")
+	if ttl < time.Second {
+		return fmt.Errorf("--ttl must be at least 1s")
+	}
+	if onBusy != onBusyWait && onBusy != onBusySkip && onBusy != onBusyFail {
+		return fmt.Errorf("unknown --on-busy %q, use %v, %v or %v", onBusy, onBusyWait, onBusySkip, onBusyFail)
+	}
+
+	lease := leasemanagement.Lease{Key: key}
+	lease.Value, _ = flags.GetString("value")
+	lease.Labels, _ = flags.GetStringToString("label")
+
+	c, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	ctx := cmd.Context()
+	var leaseID int64
+	var granted time.Duration
+	for {
+		leaseID, granted, err = c.Acquire(ctx, lease, ttl)
+		if !errors.Is(err, client.ErrLocked) {
+			break
+		}
+		switch onBusy {
+		case onBusySkip:
+			fmt.Fprintf(cmd.ErrOrStderr(), "%v is held by another lease, skipping\n", key)
+			return nil
+		case onBusyFail:
+			return fmt.Errorf("%v is held by another lease", key)
+		}
+
+		select {
+		case <-time.After(retryInterval):
+		case sig := <-signals:
+			return fmt.Errorf("interrupted by %v while waiting for %v", sig, key)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire %v: %v", key, err)
+	}
+	// Keepalives follow the TTL of the lease, not the one asked for, or the
+	// lease would expire in between when the server grants less.
+	if granted < ttl {
+		fmt.Fprintf(cmd.ErrOrStderr(), "The server granted %v a TTL of %v instead of %v, keeping it alive every %v\n", key, granted, ttl, granted/3)
+	}
+	ttl = granted
+
+	// The command writes to stderr from a copy goroutine unless it is a file,
+	// while the lock is reported on it from here.
+	stderr := syncWriter(cmd.ErrOrStderr())
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, cmd.OutOrStdout(), stderr
+	if err := child.Start(); err != nil {
+		releaseLock(cmd.Context(), stderr, c, key, leaseID)
+		return err
+	}
+
+	holdCtx, stopHold := context.WithCancel(ctx)
+	defer stopHold()
+	lost := make(chan error, 1)
+	go func() { lost <- c.Hold(holdCtx, leaseID, ttl) }()
+	exited := make(chan error, 1)
+	go func() { exited <- child.Wait() }()
+
+	var lostErr error
+	var kill <-chan time.Time
+	for {
+		select {
+		case sig := <-signals:
+			_ = child.Process.Signal(sig)
+		case lostErr = <-lost:
+			lost = nil
+			fmt.Fprintf(stderr, "Lost the lock on %v, stopping the command: %v\n", key, lostErr)
+			_ = child.Process.Signal(syscall.SIGTERM)
+			kill = time.After(killTimeout)
+		case <-kill:
+			_ = child.Process.Kill()
+		case err := <-exited:
+			if lost != nil {
+				stopHold()
+				<-lost
+				releaseLock(cmd.Context(), stderr, c, key, leaseID)
+			}
+			if lostErr != nil {
+				return fmt.Errorf("lost the lock on %v: %v", key, lostErr)
+			}
+
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				cmd.SilenceErrors = true
+				return &ExitError{Code: max(exitErr.ExitCode(), 1)}
+			}
+			return err
+		}
+	}
+}
+
+func releaseLock(ctx context.Context, stderr io.Writer, c *client.Client, key string, leaseID int64) {
+	if err := c.Release(context.WithoutCancel(ctx), key, leaseID); err != nil {
+		fmt.Fprintf(stderr, "Failed to release %v, it expires with its lease: %v\n", key, err)
+	}
+}
+
+// lockedWriter serializes writes to a writer shared with a command.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+// syncWriter returns w safe for concurrent writes. Files are returned as
+// they are, so that a command writes to them directly.
+func syncWriter(w io.Writer) io.Writer {
+	if _, ok := w.(*os.File); ok {
+		return w
+	}
+
+	return &lockedWriter{w: w}
+}
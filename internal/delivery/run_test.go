@@ -0,0 +1,182 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/application"
+	"github.com/tentens-tech/shared-lock/internal/application/command/leasemanagement"
+	"github.com/tentens-tech/shared-lock/internal/client"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	httpserver "github.com/tentens-tech/shared-lock/internal/delivery/http"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/mock"
+)
+
+const testKey = "jobs/nightly"
+
+func newTestServer(t *testing.T) (*httptest.Server, *client.Client) {
+	t.Helper()
+
+	cfg := config.NewConfig()
+	cfg.Storage.Type = "mock"
+	app := application.New(context.Background(), cfg, mock.New(), nil)
+	t.Cleanup(app.Close)
+
+	server := httptest.NewServer(httpserver.New(app, nil).Handler(&cfg.Server))
+	t.Cleanup(server.Close)
+
+	c, err := client.New(client.Options{Server: server.URL})
+	require.NoError(t, err)
+
+	return server, c
+}
+
+// newLosingServer grants every lease for ttl and reports it gone on the
+// first keepalive.
+func newLosingServer(t *testing.T, ttl string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /lease", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-lease-ttl", ttl)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("123"))
+	})
+	mux.HandleFunc("POST /keepalive", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /release", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Lease is not held by the given lease id", http.StatusNotFound)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// runCommand runs the run command against server and returns its error and
+// what it wrote to stderr.
+func runCommand(server string, args ...string) (string, error) {
+	cmd := NewRunCmd()
+	var stdout, stderr bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(append([]string{"--server", server, "--key", testKey}, args...))
+
+	err := cmd.ExecuteContext(context.Background())
+
+	return stderr.String(), err
+}
+
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "the command did not start")
+}
+
+func TestRun_ExitStatus(t *testing.T) {
+	server, c := newTestServer(t)
+	marker := filepath.Join(t.TempDir(), "ran")
+
+	_, err := runCommand(server.URL, "--", "sh", "-c", `touch "$1"; exit 3`, "sh", marker)
+
+	var exitErr *ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.Code)
+	assert.FileExists(t, marker)
+
+	_, _, err = c.Acquire(context.Background(), leasemanagement.Lease{Key: testKey}, time.Minute)
+	assert.NoError(t, err, "the lock is released once the command exits")
+}
+
+func TestRun_OnBusy(t *testing.T) {
+	tests := []struct {
+		onBusy        string
+		expectedError string
+		expectedRun   bool
+	}{
+		{onBusy: onBusySkip},
+		{onBusy: onBusyFail, expectedError: "is held by another lease"},
+		{onBusy: onBusyWait, expectedRun: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.onBusy, func(t *testing.T) {
+			server, c := newTestServer(t)
+			ctx := context.Background()
+			leaseID, _, err := c.Acquire(ctx, leasemanagement.Lease{Key: testKey}, time.Minute)
+			require.NoError(t, err)
+			if tt.onBusy == onBusyWait {
+				time.AfterFunc(200*time.Millisecond, func() { _ = c.Release(ctx, testKey, leaseID) })
+			}
+			marker := filepath.Join(t.TempDir(), "ran")
+
+			_, err = runCommand(server.URL, "--on-busy", tt.onBusy, "--retry-interval", "50ms",
+				"--", "sh", "-c", `touch "$1"`, "sh", marker)
+
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			_, statErr := os.Stat(marker)
+			assert.Equal(t, tt.expectedRun, statErr == nil, "whether the command ran")
+		})
+	}
+}
+
+func TestRun_ForwardsSignals(t *testing.T) {
+	server, _ := newTestServer(t)
+	started := filepath.Join(t.TempDir(), "started")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := runCommand(server.URL, "--", "sh", "-c",
+			`trap 'exit 7' HUP; touch "$1"; while :; do sleep 0.05; done`, "sh", started)
+		done <- err
+	}()
+	waitForFile(t, started)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	var exitErr *ExitError
+	require.ErrorAs(t, <-done, &exitErr)
+	assert.Equal(t, 7, exitErr.Code, "the command exits from its SIGHUP trap")
+}
+
+func TestRun_LockLost(t *testing.T) {
+	server := newLosingServer(t, "150ms")
+	dir := t.TempDir()
+	terminated := filepath.Join(dir, "terminated")
+
+	stderr, err := runCommand(server.URL, "--ttl", "1m", "--", "sh", "-c",
+		`trap 'touch "$1"; exit 0' TERM; while :; do sleep 0.05; done`, "sh", terminated)
+
+	assert.ErrorContains(t, err, "lost the lock")
+	assert.FileExists(t, terminated, "the command is terminated")
+	assert.Contains(t, stderr, "granted jobs/nightly a TTL of 150ms instead of 1m0s", "keepalives follow the granted TTL")
+}
+
+func TestRun_KillTimeout(t *testing.T) {
+	server := newLosingServer(t, "150ms")
+
+	start := time.Now()
+	_, err := runCommand(server.URL, "--kill-timeout", "300ms", "--", "sh", "-c",
+		`trap '' TERM; while :; do sleep 0.05; done`)
+
+	assert.ErrorContains(t, err, "lost the lock")
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond, "the command ignoring SIGTERM is killed after the timeout")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
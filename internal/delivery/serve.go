@@ -6,45 +6,104 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/tentens-tech/shared-lock/internal/bootstrap"
-	"github.com/tentens-tech/shared-lock/internal/config"
 	httpserver "github.com/tentens-tech/shared-lock/internal/delivery/http"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/spf13/cobra"
 )
 
 func NewServe() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "serve",
 		Short: "Start HTTP server",
 		RunE:  sharedLockProcess,
 	}
+	addConfigFlag(cmd)
+
+	return cmd
 }
 
 func sharedLockProcess(cmd *cobra.Command, _ []string) error {
 	ctx := cmd.Context()
 
+	configuration, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
 	errGroup, errGroupCtx := errgroup.WithContext(ctx)
 
 	var runChan = make(chan os.Signal, 1)
 	signal.Notify(runChan, os.Interrupt)
 
-	configuration := config.NewConfig()
-	if configuration.Debug {
-		log.SetLevel(log.DebugLevel)
+	configureLogging(configuration)
+
+	shutdownTracing, err := tracing.Setup(errGroupCtx, configuration.Tracing)
+	if err != nil {
+		log.Errorf("Failed to configure tracing: %v", err)
+		return err
 	}
+	defer func() {
+		// The group context is done by now, pending spans get their own deadline.
+		ctxWithTimeout, cancel := context.WithTimeout(context.Background(), configuration.Server.Timeout.Shutdown)
+		defer cancel()
+		if err := shutdownTracing(ctxWithTimeout); err != nil {
+			log.Warnf("Failed to flush traces: %v", err)
+		}
+	}()
 
 	app, err := bootstrap.NewApplication(errGroupCtx, configuration)
 	if err != nil {
 		log.Errorf("Failed to create application instance: %v", err)
 		return err
 	}
+	defer app.Close()
+
+	authenticator, err := bootstrap.NewAuthenticator(configuration)
+	if err != nil {
+		log.Errorf("Failed to configure authentication: %v", err)
+		return err
+	}
+
+	configPath, _ := cmd.Flags().GetString(configFlag)
+	reloadCtx, stopReload := context.WithCancel(errGroupCtx)
+	var hangupChan = make(chan os.Signal, 1)
+	signal.Notify(hangupChan, syscall.SIGHUP)
+
+	errGroup.Go(func() error {
+		newConfigReloader(configPath, app).Run(reloadCtx, hangupChan)
+		return nil
+	})
 
 	errGroup.Go(func() error {
-		server := httpserver.New(app)
+		app.ReconcileQuotas(reloadCtx)
+		return nil
+	})
+
+	errGroup.Go(func() error {
+		app.WatchLeases(reloadCtx)
+		return nil
+	})
+
+	errGroup.Go(func() error {
+		app.ReportActiveLocks(reloadCtx)
+		return nil
+	})
+
+	errGroup.Go(func() error {
+		app.PruneHistory(reloadCtx)
+		return nil
+	})
+
+	errGroup.Go(func() error {
+		defer stopReload()
+
+		server := httpserver.New(app, authenticator)
 
 		log.Printf("Server is starting on %s\n", configuration.Server.Port)
 		serverErrChan := make(chan error, 1)
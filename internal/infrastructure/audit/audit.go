@@ -0,0 +1,175 @@
+// Package audit records lock operations as JSON lines for later review of
+// who held which lock when.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+)
+
+// Event types.
+const (
+	EventAcquire   = "acquire"
+	EventDeny      = "deny"
+	EventKeepalive = "keepalive"
+	EventRelease   = "release"
+	EventExpire    = "expire"
+	// EventForceRelease and EventTransfer are administrative operations on
+	// a lock held by someone else.
+	EventForceRelease = "force_release"
+	EventTransfer     = "transfer"
+)
+
+// maxBatchSize bounds the number of events written to the sinks at once.
+const maxBatchSize = 256
+
+// Event is a single audit record.
+type Event struct {
+	Time          time.Time         `json:"time"`
+	Type          string            `json:"event"`
+	Outcome       string            `json:"outcome"`
+	Operation     string            `json:"operation,omitempty"`
+	Namespace     string            `json:"namespace,omitempty"`
+	Key           string            `json:"key,omitempty"`
+	LeaseID       int64             `json:"lease_id,omitempty"`
+	Principal     string            `json:"principal,omitempty"`
+	Owner         string            `json:"owner,omitempty"`
+	ClientAddress string            `json:"client_address,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Reason        string            `json:"reason,omitempty"`
+}
+
+// Logger writes events to its sinks in the background. Events that do not
+// fit in the buffer are dropped rather than slowing down the request that
+// logged them.
+type Logger struct {
+	sinks  []io.Writer
+	events chan Event
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New starts a logger buffering up to bufferSize events for sinks.
+func New(bufferSize int, sinks ...io.Writer) *Logger {
+	logger := &Logger{
+		sinks:  sinks,
+		events: make(chan Event, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go logger.run()
+
+	return logger
+}
+
+// Log queues event without blocking. A nil logger discards events.
+func (l *Logger) Log(event Event) {
+	if l == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+
+	select {
+	case l.events <- event:
+	default:
+		metrics.AuditEvents.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Close writes the queued events and closes the sinks that are io.Closers.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.events)
+	l.mu.Unlock()
+
+	<-l.done
+
+	var firstErr error
+	for _, sink := range l.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+
+	var batch bytes.Buffer
+	encoder := json.NewEncoder(&batch)
+	for event := range l.events {
+		batch.Reset()
+		count := 0
+		for {
+			if err := encoder.Encode(event); err != nil {
+				log.Warnf("Failed to encode audit event: %v", err)
+				metrics.AuditEvents.WithLabelValues("failed").Inc()
+			} else {
+				count++
+			}
+			if count >= maxBatchSize {
+				break
+			}
+
+			var ok bool
+			select {
+			case event, ok = <-l.events:
+			default:
+			}
+			if !ok {
+				break
+			}
+		}
+
+		l.write(batch.Bytes(), count)
+	}
+}
+
+func (l *Logger) write(batch []byte, count int) {
+	status := "written"
+	for _, sink := range l.sinks {
+		if _, err := sink.Write(batch); err != nil {
+			log.Warnf("Failed to write audit log: %v", err)
+			status = "failed"
+		}
+	}
+	metrics.AuditEvents.WithLabelValues(status).Add(float64(count))
+}
+
+type clientAddressKey struct{}
+
+// WithClientAddress attaches the address of the calling client to ctx.
+func WithClientAddress(ctx context.Context, address string) context.Context {
+	return context.WithValue(ctx, clientAddressKey{}, address)
+}
+
+// ClientAddressFromContext returns the client address attached to ctx, or "".
+func ClientAddressFromContext(ctx context.Context) string {
+	address, _ := ctx.Value(clientAddressKey{}).(string)
+	return address
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+)
+
+// blockingSink holds every write until it is released.
+type blockingSink struct {
+	release chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (s *blockingSink) Write(p []byte) (int, error) {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *blockingSink) Close() error {
+	s.once.Do(func() { close(s.release) })
+	return nil
+}
+
+func decodeEvents(t *testing.T, data []byte) []Event {
+	t.Helper()
+
+	var events []Event
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+
+	return events
+}
+
+func TestLogger_WritesJSONLines(t *testing.T) {
+	var first, second bytes.Buffer
+	logger := New(16, &first, &second)
+
+	logger.Log(Event{Type: EventAcquire, Outcome: "accepted", Key: "billing/invoice-1", LeaseID: 42, Labels: map[string]string{"owner": "worker-1"}})
+	logger.Log(Event{Type: EventRelease, Outcome: "success", Key: "billing/invoice-1", LeaseID: 42})
+	require.NoError(t, logger.Close())
+
+	assert.Equal(t, first.String(), second.String())
+	events := decodeEvents(t, first.Bytes())
+	require.Len(t, events, 2)
+	assert.Equal(t, EventAcquire, events[0].Type)
+	assert.Equal(t, "worker-1", events[0].Labels["owner"])
+	assert.Equal(t, int64(42), events[0].LeaseID)
+	assert.False(t, events[0].Time.IsZero())
+	assert.Equal(t, EventRelease, events[1].Type)
+	assert.NotContains(t, first.String(), "client_address")
+}
+
+func TestLogger_DropsWhenFull(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	logger := New(1, sink)
+	dropped := testutil.ToFloat64(metrics.AuditEvents.WithLabelValues("dropped"))
+
+	// The first event may already be held by the blocked writer, so up to
+	// two are accepted.
+	for range 5 {
+		logger.Log(Event{Type: EventKeepalive, Outcome: "success"})
+	}
+
+	assert.GreaterOrEqual(t, testutil.ToFloat64(metrics.AuditEvents.WithLabelValues("dropped"))-dropped, 3.0)
+	require.NoError(t, sink.Close())
+	require.NoError(t, logger.Close())
+	assert.LessOrEqual(t, len(decodeEvents(t, sink.buf.Bytes())), 2)
+}
+
+func TestLogger_Nil(t *testing.T) {
+	var logger *Logger
+
+	assert.NotPanics(t, func() { logger.Log(Event{Type: EventDeny}) })
+}
+
+func TestLogger_LogAfterClose(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(4, &out)
+	require.NoError(t, logger.Close())
+
+	logger.Log(Event{Type: EventAcquire})
+
+	assert.Empty(t, out.String())
+	assert.NoError(t, logger.Close())
+}
+
+func TestClientAddressFromContext(t *testing.T) {
+	assert.Equal(t, "", ClientAddressFromContext(context.Background()))
+	assert.Equal(t, "10.0.0.1", ClientAddressFromContext(WithClientAddress(context.Background(), "10.0.0.1")))
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := NewRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestRotatingFile_NoBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := NewRotatingFile(path, 10, 0)
+	require.NoError(t, err)
+
+	_, err = file.Write([]byte(strings.Repeat("a", 8)))
+	require.NoError(t, err)
+	_, err = file.Write([]byte("bb"))
+	require.NoError(t, err)
+	_, err = file.Write([]byte("ccc"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "ccc", string(data))
+	assert.NoFileExists(t, path+".1")
+}
+
+func TestRotatingFile_Appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("existing\n"), 0o600))
+
+	file, err := NewRotatingFile(path, 1<<20, 1)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("appended\n"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "existing\nappended\n", string(data))
+
+	_, err = file.Write([]byte("late\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
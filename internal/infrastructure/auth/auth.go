@@ -0,0 +1,93 @@
+// Package auth authenticates callers of the HTTP API and carries the
+// authenticated principal through the request context.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+const (
+	MethodToken = "token"
+	MethodJWT   = "jwt"
+	MethodMTLS  = "mtls"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request does not
+// carry credentials for its method, so that the next one can be tried.
+var ErrNoCredentials = errors.New("no credentials provided")
+
+// Principal is an authenticated caller. Tenant, when set, confines the
+// caller to the namespace of the same name.
+type Principal struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+	Method string   `json:"method"`
+}
+
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the authenticated principal of the request,
+// or nil when authentication is disabled.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Chain tries each authenticator in order. The first one that finds
+// credentials decides the outcome: invalid credentials are not retried with
+// the other methods.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+
+	return nil, ErrNoCredentials
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !equalFoldASCII(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return header[len(prefix):], true
+}
+
+func equalFoldASCII(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithToken(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/lease", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	path := writeFile(t, "tokens", "# static tokens\nsecret-a team-a-ci team-a,ci\n\nsecret-b billing\nsecret-c payments-job - payments\n")
+	authenticator, err := NewTokenAuthenticator(path)
+	require.NoError(t, err)
+
+	principal, err := authenticator.Authenticate(requestWithToken("secret-a"))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "team-a-ci", Groups: []string{"team-a", "ci"}, Method: MethodToken}, principal)
+
+	principal, err = authenticator.Authenticate(requestWithToken("secret-b"))
+	require.NoError(t, err)
+	assert.Equal(t, "billing", principal.Name)
+
+	principal, err = authenticator.Authenticate(requestWithToken("secret-c"))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "payments-job", Tenant: "payments", Method: MethodToken}, principal)
+
+	_, err = authenticator.Authenticate(requestWithToken("wrong"))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoCredentials)
+
+	_, err = authenticator.Authenticate(requestWithToken(""))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	_, err = authenticator.Authenticate(requestWithToken("a.b.c"))
+	assert.ErrorIs(t, err, ErrNoCredentials, "JWT-shaped tokens are left to the JWT authenticator")
+}
+
+func TestTokenAuthenticator_InvalidFile(t *testing.T) {
+	_, err := NewTokenAuthenticator(writeFile(t, "tokens", "lonely-token\n"))
+	assert.Error(t, err)
+
+	_, err = NewTokenAuthenticator(writeFile(t, "tokens", "dup one\ndup two\n"))
+	assert.Error(t, err)
+}
+
+func newJWTFixture(t *testing.T) (*rsa.PrivateKey, *JWTAuthenticator) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	require.NoError(t, err)
+
+	authenticator, err := NewJWTAuthenticator(JWTOptions{
+		JWKSPath: writeFile(t, "jwks.json", string(jwks)),
+		Issuer:   "https://issuer.example",
+		Audience: "shared-lock",
+	})
+	require.NoError(t, err)
+
+	return key, authenticator
+}
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, authenticator := newJWTFixture(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":    "deploy-bot",
+			"iss":    "https://issuer.example",
+			"aud":    "shared-lock",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"groups": []string{"team-a"},
+			"tenant": "team-a",
+		}
+	}
+
+	principal, err := authenticator.Authenticate(requestWithToken(signJWT(t, key, "test-key", validClaims())))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "deploy-bot", Groups: []string{"team-a"}, Tenant: "team-a", Method: MethodJWT}, principal)
+
+	tests := []struct {
+		name   string
+		mutate func(jwt.MapClaims)
+		key    *rsa.PrivateKey
+		kid    string
+	}{
+		{name: "expired", mutate: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "missing expiry", mutate: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "wrong issuer", mutate: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{name: "wrong audience", mutate: func(c jwt.MapClaims) { c["aud"] = "other" }},
+		{name: "missing subject", mutate: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "unknown key", kid: "other-key"},
+		{name: "bad signature", key: otherKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			if tt.mutate != nil {
+				tt.mutate(claims)
+			}
+			signingKey, kid := key, "test-key"
+			if tt.key != nil {
+				signingKey = tt.key
+			}
+			if tt.kid != "" {
+				kid = tt.kid
+			}
+
+			_, err := authenticator.Authenticate(requestWithToken(signJWT(t, signingKey, kid, claims)))
+			assert.Error(t, err)
+			assert.NotErrorIs(t, err, ErrNoCredentials)
+		})
+	}
+
+	_, err = authenticator.Authenticate(requestWithToken("opaque-token"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestMTLSAuthenticator(t *testing.T) {
+	authenticator := NewMTLSAuthenticator()
+	certificate := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "billing-worker", Organization: []string{"finance"}, OrganizationalUnit: []string{"billing"}},
+	}
+
+	_, err := authenticator.Authenticate(httptest.NewRequest(http.MethodPost, "/lease", nil))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	unverified := httptest.NewRequest(http.MethodPost, "/lease", nil)
+	unverified.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+	_, err = authenticator.Authenticate(unverified)
+	assert.Error(t, err)
+
+	verified := httptest.NewRequest(http.MethodPost, "/lease", nil)
+	verified.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{certificate},
+		VerifiedChains:   [][]*x509.Certificate{{certificate}},
+	}
+	principal, err := authenticator.Authenticate(verified)
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "billing-worker", Groups: []string{"billing"}, Tenant: "finance", Method: MethodMTLS}, principal)
+}
+
+func TestChain(t *testing.T) {
+	key, jwtAuthenticator := newJWTFixture(t)
+	tokenAuthenticator, err := NewTokenAuthenticator(writeFile(t, "tokens", "secret ci\n"))
+	require.NoError(t, err)
+	chain := Chain{tokenAuthenticator, jwtAuthenticator, NewMTLSAuthenticator()}
+
+	principal, err := chain.Authenticate(requestWithToken("secret"))
+	require.NoError(t, err)
+	assert.Equal(t, MethodToken, principal.Method)
+
+	token := signJWT(t, key, "test-key", jwt.MapClaims{
+		"sub": "bot", "iss": "https://issuer.example", "aud": "shared-lock", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	principal, err = chain.Authenticate(requestWithToken(token))
+	require.NoError(t, err)
+	assert.Equal(t, MethodJWT, principal.Method)
+
+	_, err = chain.Authenticate(requestWithToken(""))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
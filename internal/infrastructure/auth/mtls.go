@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MTLSAuthenticator identifies callers by the client certificate verified
+// during the TLS handshake: the subject common name becomes the principal
+// name, the organizational units its groups and the first organization its
+// tenant.
+type MTLSAuthenticator struct{}
+
+func NewMTLSAuthenticator() *MTLSAuthenticator {
+	return &MTLSAuthenticator{}
+}
+
+func (a *MTLSAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+	// Only certificates verified against the configured client CA count.
+	if len(r.TLS.VerifiedChains) == 0 {
+		return nil, fmt.Errorf("client certificate was not verified")
+	}
+
+	certificate := r.TLS.VerifiedChains[0][0]
+	if certificate.Subject.CommonName == "" {
+		return nil, fmt.Errorf("client certificate has no common name")
+	}
+
+	principal := &Principal{
+		Name:   certificate.Subject.CommonName,
+		Groups: certificate.Subject.OrganizationalUnit,
+		Method: MethodMTLS,
+	}
+	if len(certificate.Subject.Organization) > 0 {
+		principal.Tenant = certificate.Subject.Organization[0]
+	}
+
+	return principal, nil
+}
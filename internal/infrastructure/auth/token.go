@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TokenAuthenticator accepts static bearer tokens listed in a file, one per
+// line as "<token> <principal> [group1,group2] [tenant]", where "-" stands
+// for no groups. Blank lines and lines starting with # are ignored.
+type TokenAuthenticator struct {
+	principals map[[sha256.Size]byte]*Principal
+}
+
+func NewTokenAuthenticator(path string) (*TokenAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokens file: %v", err)
+	}
+	defer file.Close()
+
+	authenticator := &TokenAuthenticator{principals: make(map[[sha256.Size]byte]*Principal)}
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("tokens file line %d: expected \"<token> <principal> [groups] [tenant]\"", lineNumber)
+		}
+
+		principal := &Principal{Name: fields[1], Method: MethodToken}
+		if len(fields) >= 3 && fields[2] != "-" {
+			principal.Groups = strings.Split(fields[2], ",")
+		}
+		if len(fields) == 4 {
+			principal.Tenant = fields[3]
+		}
+
+		digest := sha256.Sum256([]byte(fields[0]))
+		if _, exists := authenticator.principals[digest]; exists {
+			return nil, fmt.Errorf("tokens file line %d: duplicate token", lineNumber)
+		}
+		authenticator.principals[digest] = principal
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %v", err)
+	}
+
+	return authenticator, nil
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	// Tokens are looked up by digest so that the lookup time does not depend
+	// on how much of a guessed token matches.
+	if principal, exists := a.principals[sha256.Sum256([]byte(token))]; exists {
+		return principal, nil
+	}
+	if isJWT(token) {
+		return nil, ErrNoCredentials
+	}
+
+	return nil, fmt.Errorf("invalid bearer token")
+}
+
+// isJWT tells compact JWS tokens apart from opaque static tokens.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -1,29 +1,48 @@
 package cache
 
 import (
+	"container/heap"
 	"container/list"
+	"hash/maphash"
 	"sync"
 	"time"
 
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
 )
 
-type Cache struct {
-	mu       sync.RWMutex
-	items    map[string]*CacheItem
-	maxSize  int
-	lruList  *list.List
-	keyToLRU map[string]*list.Element
+const (
+	// shardCount is the number of independently locked shards. Each shard
+	// holds its share of the maximum size and evicts its least recently
+	// used item when full.
+	shardCount = 16
+
+	cleanupInterval = time.Second
+)
+
+// Hasher maps a key to a shard.
+type Hasher[K comparable] func(K) uint64
+
+var stringSeed = maphash.MakeSeed()
+
+// StringHasher hashes string keys.
+func StringHasher(key string) uint64 {
+	return maphash.String(stringSeed, key)
 }
 
-type CacheItem struct {
-	Value      interface{}
-	Expiration time.Time
-	key        string
+// Int64Hasher hashes integer keys such as lease IDs.
+func Int64Hasher(key int64) uint64 {
+	// Fibonacci hashing spreads sequential IDs over the shards.
+	return uint64(key) * 0x9e3779b97f4a7c15 >> 32
 }
 
-type lruItem struct {
-	key string
+// Cache is a size-bounded cache with per-item expiration. Expired items are
+// removed in the background until Close is called.
+type Cache[K comparable, V any] struct {
+	name      string
+	shards    [shardCount]*shard[K, V]
+	hash      Hasher[K]
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 type LeaseCacheRecord struct {
@@ -31,113 +50,236 @@ type LeaseCacheRecord struct {
 	ID     int64
 }
 
-func New(cacheSize int) *Cache {
-	cache := &Cache{
-		items:    make(map[string]*CacheItem),
-		maxSize:  cacheSize,
-		lruList:  list.New(),
-		keyToLRU: make(map[string]*list.Element),
+// LeaseCache caches the lease held on a key.
+type LeaseCache = Cache[string, LeaseCacheRecord]
+
+// NewLeaseCache returns a lease cache holding at most cacheSize leases.
+func NewLeaseCache(cacheSize int) *LeaseCache {
+	return New[string, LeaseCacheRecord]("leases", cacheSize, StringHasher)
+}
+
+// New returns a cache holding at most cacheSize items. Its operations are
+// counted under name, which tells the caches of a process apart.
+func New[K comparable, V any](name string, cacheSize int, hash Hasher[K]) *Cache[K, V] {
+	cache := &Cache[K, V]{
+		name: name,
+		hash: hash,
+		done: make(chan struct{}),
+	}
+	for i := range cache.shards {
+		cache.shards[i] = &shard[K, V]{
+			name:    name,
+			items:   make(map[K]*entry[K, V]),
+			lruList: list.New(),
+		}
 	}
+	cache.SetMaxSize(cacheSize)
 
 	go cache.cleanup()
 
 	return cache
 }
 
-func (c *Cache) Set(key string, value interface{}, ttl time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *Cache[K, V]) shardFor(key K) *shard[K, V] {
+	return c.shards[c.hash(key)%shardCount]
+}
 
-	if item, exists := c.items[key]; exists {
-		item.Value = value
-		item.Expiration = time.Now().Add(ttl)
-		if elem, ok := c.keyToLRU[key]; ok {
-			c.lruList.MoveToFront(elem)
-		}
-		metrics.CacheOperations.WithLabelValues("set", "update").Inc()
+func (c *Cache[K, V]) Set(key K, value V, ttl time.Duration) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiration := time.Now().Add(ttl)
+	if item, exists := s.items[key]; exists {
+		item.value = value
+		item.expiration = expiration
+		heap.Fix(&s.expiry, item.heapIndex)
+		s.lruList.MoveToFront(item.lru)
+		metrics.CacheOperations.WithLabelValues(s.name, "set", "update").Inc()
 		return
 	}
 
-	if len(c.items) >= c.maxSize {
-		c.evictOldest()
+	if s.maxSize <= 0 {
+		return
+	}
+	if len(s.items) >= s.maxSize {
+		s.evictOldest()
 	}
 
-	c.items[key] = &CacheItem{
-		Value:      value,
-		Expiration: time.Now().Add(ttl),
+	item := &entry[K, V]{
 		key:        key,
+		value:      value,
+		expiration: expiration,
 	}
+	item.lru = s.lruList.PushFront(item)
+	heap.Push(&s.expiry, item)
+	s.items[key] = item
 
-	elem := c.lruList.PushFront(&lruItem{key: key})
-	c.keyToLRU[key] = elem
-
-	metrics.CacheOperations.WithLabelValues("set", "success").Inc()
+	metrics.CacheOperations.WithLabelValues(s.name, "set", "success").Inc()
 }
 
-func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	item, exists := c.items[key]
+	var zero V
+	item, exists := s.items[key]
 	if !exists {
-		metrics.CacheOperations.WithLabelValues("get", "miss").Inc()
-		return nil, false
+		metrics.CacheOperations.WithLabelValues(s.name, "get", "miss").Inc()
+		return zero, false
 	}
 
-	if time.Now().After(item.Expiration) {
-		c.removeItem(key)
-		metrics.CacheOperations.WithLabelValues("get", "expired").Inc()
-		return nil, false
+	if time.Now().After(item.expiration) {
+		s.removeItem(item)
+		metrics.CacheOperations.WithLabelValues(s.name, "get", "expired").Inc()
+		return zero, false
 	}
 
-	if elem, ok := c.keyToLRU[key]; ok {
-		c.lruList.MoveToFront(elem)
+	s.lruList.MoveToFront(item.lru)
+
+	metrics.CacheOperations.WithLabelValues(s.name, "get", "hit").Inc()
+	return item.value, true
+}
+
+func (c *Cache[K, V]) Delete(key K) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item, exists := s.items[key]; exists {
+		s.removeItem(item)
+		metrics.CacheOperations.WithLabelValues(s.name, "delete", "success").Inc()
 	}
+}
 
-	metrics.CacheOperations.WithLabelValues("get", "hit").Inc()
-	return item.Value, true
+// Clear removes all items.
+func (c *Cache[K, V]) Clear() {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = make(map[K]*entry[K, V])
+		s.lruList.Init()
+		s.expiry = nil
+		s.mu.Unlock()
+	}
+	metrics.CacheOperations.WithLabelValues(c.name, "clear", "success").Inc()
 }
 
-func (c *Cache) evictOldest() {
-	if elem := c.lruList.Back(); elem != nil {
-		if lruItem, ok := elem.Value.(*lruItem); ok {
-			c.removeItem(lruItem.key)
-			metrics.CacheOperations.WithLabelValues("evict", "size_limit").Inc()
-		}
+// Len returns the number of items, including expired ones not removed yet.
+func (c *Cache[K, V]) Len() int {
+	size := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		size += len(s.items)
+		s.mu.Unlock()
 	}
+
+	return size
 }
 
-func (c *Cache) removeItem(key string) {
-	delete(c.items, key)
-	if elem, ok := c.keyToLRU[key]; ok {
-		c.lruList.Remove(elem)
-		delete(c.keyToLRU, key)
+// SetMaxSize changes the maximum number of items, evicting the least
+// recently used ones of each shard that holds more than its share.
+func (c *Cache[K, V]) SetMaxSize(cacheSize int) {
+	for i, s := range c.shards {
+		s.mu.Lock()
+		s.maxSize = cacheSize / shardCount
+		if i < cacheSize%shardCount {
+			s.maxSize++
+		}
+		for len(s.items) > s.maxSize {
+			s.evictOldest()
+		}
+		s.mu.Unlock()
 	}
 }
 
-func (c *Cache) cleanup() {
-	ticker := time.NewTicker(time.Second)
+// Close stops the background removal of expired items.
+func (c *Cache[K, V]) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *Cache[K, V]) cleanup() {
+	ticker := time.NewTicker(cleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		c.mu.Lock()
-		now := time.Now()
-		for key, item := range c.items {
-			if now.After(item.Expiration) {
-				c.removeItem(key)
-				metrics.CacheOperations.WithLabelValues("cleanup", "expired").Inc()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			for _, s := range c.shards {
+				s.removeExpired(now)
 			}
 		}
-		c.mu.Unlock()
 	}
 }
 
-func (c *Cache) SetMaxSize(cacheSize int) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.maxSize = cacheSize
+type shard[K comparable, V any] struct {
+	name    string
+	mu      sync.Mutex
+	items   map[K]*entry[K, V]
+	maxSize int
+	lruList *list.List
+	expiry  expiryHeap[K, V]
+}
+
+type entry[K comparable, V any] struct {
+	key        K
+	value      V
+	expiration time.Time
+	lru        *list.Element
+	heapIndex  int
+}
+
+func (s *shard[K, V]) evictOldest() {
+	if elem := s.lruList.Back(); elem != nil {
+		s.removeItem(elem.Value.(*entry[K, V]))
+		metrics.CacheOperations.WithLabelValues(s.name, "evict", "size_limit").Inc()
+	}
+}
+
+func (s *shard[K, V]) removeExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	for len(c.items) > c.maxSize {
-		c.evictOldest()
+	for len(s.expiry) > 0 && now.After(s.expiry[0].expiration) {
+		s.removeItem(s.expiry[0])
+		metrics.CacheOperations.WithLabelValues(s.name, "cleanup", "expired").Inc()
 	}
 }
+
+func (s *shard[K, V]) removeItem(item *entry[K, V]) {
+	delete(s.items, item.key)
+	s.lruList.Remove(item.lru)
+	heap.Remove(&s.expiry, item.heapIndex)
+}
+
+// expiryHeap orders the items of a shard by expiration, soonest first.
+type expiryHeap[K comparable, V any] []*entry[K, V]
+
+func (h expiryHeap[K, V]) Len() int { return len(h) }
+
+func (h expiryHeap[K, V]) Less(i, j int) bool { return h[i].expiration.Before(h[j].expiration) }
+
+func (h expiryHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *expiryHeap[K, V]) Push(x any) {
+	item := x.(*entry[K, V])
+	item.heapIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap[K, V]) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return item
+}
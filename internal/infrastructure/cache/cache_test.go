@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+)
+
+func TestCache_SetGetDelete(t *testing.T) {
+	c := NewLeaseCache(100)
+	defer c.Close()
+
+	_, exists := c.Get("key")
+	assert.False(t, exists)
+
+	c.Set("key", LeaseCacheRecord{Status: "created", ID: 1}, time.Minute)
+	record, exists := c.Get("key")
+	assert.True(t, exists)
+	assert.Equal(t, LeaseCacheRecord{Status: "created", ID: 1}, record)
+
+	c.Set("key", LeaseCacheRecord{Status: "accepted", ID: 2}, time.Minute)
+	record, _ = c.Get("key")
+	assert.Equal(t, int64(2), record.ID)
+	assert.Equal(t, 1, c.Len())
+
+	c.Delete("key")
+	_, exists = c.Get("key")
+	assert.False(t, exists)
+	assert.Zero(t, c.Len())
+}
+
+func TestCache_Expiration(t *testing.T) {
+	c := NewLeaseCache(100)
+	defer c.Close()
+
+	c.Set("short", LeaseCacheRecord{ID: 1}, 10*time.Millisecond)
+	c.Set("long", LeaseCacheRecord{ID: 2}, time.Hour)
+	time.Sleep(20 * time.Millisecond)
+
+	_, exists := c.Get("short")
+	assert.False(t, exists, "expired items must not be returned")
+
+	c.Set("refreshed", LeaseCacheRecord{ID: 3}, 10*time.Millisecond)
+	c.Set("refreshed", LeaseCacheRecord{ID: 3}, time.Hour)
+	c.Set("expiring", LeaseCacheRecord{ID: 4}, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	for _, s := range c.shards {
+		s.removeExpired(time.Now())
+	}
+	assert.Equal(t, 2, c.Len())
+	_, exists = c.Get("refreshed")
+	assert.True(t, exists, "updating an item must extend its expiration")
+}
+
+func TestCache_LRUEviction(t *testing.T) {
+	c := NewLeaseCache(shardCount * 2)
+	defer c.Close()
+
+	// Fill one shard beyond its share of the maximum size.
+	var keys []string
+	target := c.shardFor("key-0")
+	for i := 0; len(keys) < 3; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if c.shardFor(key) == target {
+			keys = append(keys, key)
+		}
+	}
+
+	c.Set(keys[0], LeaseCacheRecord{ID: 0}, time.Minute)
+	c.Set(keys[1], LeaseCacheRecord{ID: 1}, time.Minute)
+	c.Get(keys[0])
+	c.Set(keys[2], LeaseCacheRecord{ID: 2}, time.Minute)
+
+	_, exists := c.Get(keys[1])
+	assert.False(t, exists, "least recently used item must be evicted")
+	_, exists = c.Get(keys[0])
+	assert.True(t, exists)
+	_, exists = c.Get(keys[2])
+	assert.True(t, exists)
+}
+
+func TestCache_SetMaxSizeAndClear(t *testing.T) {
+	c := NewLeaseCache(1000)
+	defer c.Close()
+
+	for i := 0; i < 500; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), LeaseCacheRecord{ID: int64(i)}, time.Minute)
+	}
+	assert.Equal(t, 500, c.Len())
+
+	c.SetMaxSize(100)
+	assert.LessOrEqual(t, c.Len(), 100)
+
+	c.Clear()
+	assert.Zero(t, c.Len())
+
+	c.SetMaxSize(0)
+	c.Set("key", LeaseCacheRecord{ID: 1}, time.Minute)
+	assert.Zero(t, c.Len())
+}
+
+func TestCache_Close(t *testing.T) {
+	c := New[int, string]("test", 10, func(key int) uint64 { return uint64(key) })
+
+	c.Set(1, "one", time.Minute)
+	c.Close()
+	c.Close()
+
+	value, exists := c.Get(1)
+	assert.True(t, exists)
+	assert.Equal(t, "one", value)
+}
+
+func TestCache_OperationsByName(t *testing.T) {
+	leases := New[string, int]("test-leases", 10, StringHasher)
+	defer leases.Close()
+	waits := New[string, int]("test-waits", 10, StringHasher)
+	defer waits.Close()
+
+	leases.Set("a", 1, time.Minute)
+	leases.Get("a")
+	waits.Get("a")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("test-leases", "get", "hit")))
+	assert.Zero(t, testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("test-leases", "get", "miss")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("test-waits", "get", "miss")),
+		"misses of another cache do not skew the hit ratio")
+}
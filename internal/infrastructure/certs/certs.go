@@ -0,0 +1,155 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+)
+
+// DefaultWatchInterval is how often Watch checks the files for changes.
+const DefaultWatchInterval = 10 * time.Second
+
+// Reloader serves a server certificate, and optionally a client CA pool,
+// from files on disk and picks up replaced files without a restart, e.g.
+// when cert-manager rotates a mounted secret.
+type Reloader struct {
+	certPath   string
+	keyPath    string
+	caCertPath string
+
+	current atomic.Pointer[material]
+}
+
+type material struct {
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	contents    []byte
+}
+
+// NewReloader loads the certificate, key and optional client CA. A broken
+// initial set of files is an error; later broken files keep the previous
+// material in use.
+func NewReloader(certPath, keyPath, caCertPath string) (*Reloader, error) {
+	r := &Reloader{
+		certPath:   certPath,
+		keyPath:    keyPath,
+		caCertPath: caCertPath,
+	}
+
+	loaded, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(loaded)
+
+	return r, nil
+}
+
+// TLSConfig returns a server TLS configuration that always uses the most
+// recently loaded material. A zero clientAuth disables client certificates.
+func (r *Reloader) TLSConfig(minVersion uint16, clientAuth tls.ClientAuthType) *tls.Config {
+	// The per-connection configuration replaces the server's, so it has to
+	// advertise HTTP/2 itself.
+	nextProtos := []string{"h2", "http/1.1"}
+
+	return &tls.Config{
+		MinVersion: minVersion,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			current := r.current.Load()
+			return &tls.Config{
+				MinVersion:   minVersion,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*current.certificate},
+				ClientAuth:   clientAuth,
+				ClientCAs:    current.clientCAs,
+			}, nil
+		},
+	}
+}
+
+// Certificate returns the certificate currently served.
+func (r *Reloader) Certificate() *tls.Certificate {
+	return r.current.Load().certificate
+}
+
+// Watch checks the files every interval until ctx is done and reloads them
+// when their content changes.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Reload()
+		}
+	}
+}
+
+// Reload re-reads the files and swaps in the new material if it changed.
+// It reports whether new material is now served.
+func (r *Reloader) Reload() bool {
+	loaded, err := r.load()
+	if err != nil {
+		log.Errorf("Failed to reload TLS certificate, keeping the current one: %v", err)
+		metrics.CertificateReloads.WithLabelValues("failure").Inc()
+		return false
+	}
+
+	if bytes.Equal(loaded.contents, r.current.Load().contents) {
+		return false
+	}
+
+	r.current.Store(loaded)
+	metrics.CertificateReloads.WithLabelValues("success").Inc()
+	log.Infof("Reloaded TLS certificate from %v", r.certPath)
+
+	return true
+}
+
+func (r *Reloader) load() (*material, error) {
+	certPEM, err := os.ReadFile(r.certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+	keyPEM, err := os.ReadFile(r.keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key pair %v, %v: %v", r.certPath, r.keyPath, err)
+	}
+
+	loaded := &material{
+		certificate: &certificate,
+		contents:    bytes.Join([][]byte{certPEM, keyPEM}, nil),
+	}
+
+	if r.caCertPath != "" {
+		caPEM, err := os.ReadFile(r.caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA certificate: %v", err)
+		}
+
+		loaded.clientCAs = x509.NewCertPool()
+		if !loaded.clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA file %v", r.caCertPath)
+		}
+		loaded.contents = append(loaded.contents, caPEM...)
+	}
+
+	return loaded, nil
+}
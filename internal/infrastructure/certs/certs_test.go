@@ -0,0 +1,120 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/etcd/etcdtest"
+)
+
+func serveTLS(t *testing.T, config *tls.Config) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	return "https://" + listener.Addr().String()
+}
+
+func client(t *testing.T, certs *etcdtest.Certs, withClientCert bool) *http.Client {
+	t.Helper()
+
+	caPEM, err := os.ReadFile(certs.CACertPath)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPEM))
+
+	config := &tls.Config{RootCAs: roots}
+	if withClientCert {
+		certificate, err := tls.LoadX509KeyPair(certs.ClientCertPath, certs.ClientKeyPath)
+		require.NoError(t, err)
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}}
+}
+
+func copyFile(t *testing.T, from, to string) {
+	t.Helper()
+
+	data, err := os.ReadFile(from)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(to, data, 0o600))
+}
+
+func TestReloader_ReloadsChangedFiles(t *testing.T) {
+	served, err := etcdtest.GenerateCerts(t.TempDir())
+	require.NoError(t, err)
+	rotated, err := etcdtest.GenerateCerts(t.TempDir())
+	require.NoError(t, err)
+
+	reloader, err := NewReloader(served.ServerCertPath, served.ServerKeyPath, "")
+	require.NoError(t, err)
+	url := serveTLS(t, reloader.TLSConfig(tls.VersionTLS12, tls.NoClientCert))
+
+	resp, err := client(t, served, false).Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.False(t, reloader.Reload(), "unchanged files must not be reloaded")
+
+	copyFile(t, rotated.ServerCertPath, served.ServerCertPath)
+	require.False(t, reloader.Reload(), "a certificate without its key must be rejected")
+
+	copyFile(t, rotated.ServerKeyPath, served.ServerKeyPath)
+	require.True(t, reloader.Reload())
+
+	_, err = client(t, served, false).Get(url)
+	assert.Error(t, err, "the old CA must not verify the rotated certificate")
+
+	resp, err = client(t, rotated, false).Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestReloader_ClientAuthentication(t *testing.T) {
+	certs, err := etcdtest.GenerateCerts(t.TempDir())
+	require.NoError(t, err)
+
+	reloader, err := NewReloader(certs.ServerCertPath, certs.ServerKeyPath, certs.CACertPath)
+	require.NoError(t, err)
+	url := serveTLS(t, reloader.TLSConfig(tls.VersionTLS13, tls.RequireAndVerifyClientCert))
+
+	resp, err := client(t, certs, true).Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, tls.VersionTLS13, int(resp.TLS.Version))
+	assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+
+	_, err = client(t, certs, false).Get(url)
+	assert.Error(t, err, "clients without a certificate must be rejected")
+}
+
+func TestNewReloader_InvalidFiles(t *testing.T) {
+	certs, err := etcdtest.GenerateCerts(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewReloader(certs.ServerCertPath, certs.ClientKeyPath, "")
+	assert.Error(t, err)
+
+	_, err = NewReloader(certs.ServerCertPath, certs.ServerKeyPath, certs.ServerKeyPath)
+	assert.Error(t, err)
+}
@@ -1,43 +1,254 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
 	LeaseOperationProlong = "prolong"
 	LeaseOperationGet     = "get"
+	LeaseOperationRelease = "release"
 )
 
+// latencyBuckets cover request latencies from half a millisecond to about
+// 16 seconds.
+var latencyBuckets = prometheus.ExponentialBuckets(0.0005, 2, 16)
+
 var (
 	LeaseOperations = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "shared_lock_lease_operations_total",
 			Help: "Total number of lease operations",
 		},
-		[]string{"operation", "status"},
+		[]string{"operation", "status", "namespace"},
 	)
 
 	LeaseOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "shared_lock_lease_operation_duration_seconds",
+			Help:    "Duration of lease API requests in seconds",
+			Buckets: latencyBuckets,
+		},
+		[]string{"operation"},
+	)
+
+	// LeaseGrantDuration is the name LeaseOperationDuration was exported under
+	// before, kept with its buckets for existing dashboards and alerts.
+	//
+	// Deprecated: use LeaseOperationDuration.
+	LeaseGrantDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "shared_lock_lease_grant_duration_seconds",
-			Help:    "Duration of lease grant in seconds",
+			Help:    "Duration of lease grant in seconds (deprecated, use shared_lock_lease_operation_duration_seconds)",
 			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
 		},
 		[]string{"operation"},
 	)
 
+	StorageRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "shared_lock_storage_request_duration_seconds",
+			Help:    "Duration of storage requests in seconds",
+			Buckets: latencyBuckets,
+		},
+		[]string{"operation"},
+	)
+
+	ActiveLocks = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shared_lock_active_locks",
+			Help: "Locks currently held, as last counted in storage",
+		},
+		[]string{"namespace", "prefix"},
+	)
+
+	LockHoldDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "shared_lock_lock_hold_duration_seconds",
+			Help:    "Time locks were held until they were released, in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+		},
+		[]string{"namespace", "prefix"},
+	)
+
+	LockWaitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "shared_lock_lock_wait_duration_seconds",
+			Help:    "Time from the first refused request of a caller for a key until it was granted, in seconds",
+			Buckets: append([]float64{0}, prometheus.ExponentialBuckets(0.01, 4, 10)...),
+		},
+		[]string{"namespace", "prefix"},
+	)
+
+	LeasesExpired = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_leases_expired_total",
+			Help: "Total number of leases found expired by a keepalive or release instead of being released",
+		},
+		[]string{"namespace", "prefix"},
+	)
+
+	AcquireCoalesced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_acquire_coalesced_total",
+			Help: "Total number of lease requests answered from a concurrent request for the same key",
+		},
+		[]string{"namespace"},
+	)
+
+	IdempotentReplays = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_idempotent_replays_total",
+			Help: "Total number of lease requests answered with the lease granted to an earlier request with the same idempotency key",
+		},
+		[]string{"namespace"},
+	)
+
+	LeaseRacesLost = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_lease_races_lost_total",
+			Help: "Total number of lease creations that lost the race for a key to another holder",
+		},
+		[]string{"namespace", "prefix"},
+	)
+
 	CacheOperations = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "shared_lock_cache_operations_total",
 			Help: "Total number of cache operations",
 		},
-		[]string{"operation", "status"},
+		[]string{"cache", "operation", "status"},
+	)
+
+	CacheInvalidations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_cache_invalidations_total",
+			Help: "Total number of lease cache entries invalidated by storage changes",
+		},
+		[]string{"reason"},
+	)
+
+	CacheNegativeHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_cache_negative_hits_total",
+			Help: "Total number of requests answered from cached dead leases or free keys",
+		},
+		[]string{"kind"},
+	)
+
+	AuthRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_auth_requests_total",
+			Help: "Total number of authentication attempts by method and outcome",
+		},
+		[]string{"method", "status"},
+	)
+
+	AuthzDenials = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_authz_denials_total",
+			Help: "Total number of operations denied by authorization policies",
+		},
+		[]string{"operation"},
+	)
+
+	QuotaRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_quota_rejections_total",
+			Help: "Total number of lock requests rejected because a namespace quota was exhausted",
+		},
+		[]string{"namespace", "quota"},
+	)
+
+	QuotaUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shared_lock_quota_usage",
+			Help: "Locks and lease seconds currently held per namespace, as counted for quotas",
+		},
+		[]string{"namespace", "resource"},
+	)
+
+	RateLimitRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_rate_limit_rejections_total",
+			Help: "Total number of requests rejected by rate limits or the storage concurrency limit",
+		},
+		[]string{"limit"},
+	)
+
+	AuditEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_audit_events_total",
+			Help: "Total number of audit events by whether they were written, dropped or failed to be written",
+		},
+		[]string{"status"},
+	)
+
+	HistoryEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_history_events_total",
+			Help: "Total number of lock history events by whether they were stored, dropped or failed to be stored",
+		},
+		[]string{"status"},
+	)
+
+	ConfigReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_config_reloads_total",
+			Help: "Total number of configuration reload attempts",
+		},
+		[]string{"status"},
+	)
+
+	CertificateReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shared_lock_tls_certificate_reloads_total",
+			Help: "Total number of TLS certificate reloads after the files changed",
+		},
+		[]string{"status"},
+	)
+
+	ConfigRestartRequired = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "shared_lock_config_restart_required",
+			Help: "Whether the configuration on disk has changes that only apply after a restart",
+		},
 	)
 )
 
 func init() {
 	prometheus.MustRegister(LeaseOperations)
 	prometheus.MustRegister(LeaseOperationDuration)
+	prometheus.MustRegister(LeaseGrantDuration)
+	prometheus.MustRegister(StorageRequestDuration)
+	prometheus.MustRegister(ActiveLocks)
+	prometheus.MustRegister(LockHoldDuration)
+	prometheus.MustRegister(LockWaitDuration)
+	prometheus.MustRegister(LeasesExpired)
 	prometheus.MustRegister(CacheOperations)
+	prometheus.MustRegister(CacheInvalidations)
+	prometheus.MustRegister(CacheNegativeHits)
+	prometheus.MustRegister(AcquireCoalesced)
+	prometheus.MustRegister(IdempotentReplays)
+	prometheus.MustRegister(LeaseRacesLost)
+	prometheus.MustRegister(AuthRequests)
+	prometheus.MustRegister(AuthzDenials)
+	prometheus.MustRegister(QuotaRejections)
+	prometheus.MustRegister(QuotaUsage)
+	prometheus.MustRegister(RateLimitRejections)
+	prometheus.MustRegister(AuditEvents)
+	prometheus.MustRegister(HistoryEvents)
+	prometheus.MustRegister(ConfigReloads)
+	prometheus.MustRegister(ConfigRestartRequired)
+	prometheus.MustRegister(CertificateReloads)
+}
+
+// ObserveLeaseOperation records the duration of a lease request for
+// operation that started at start.
+func ObserveLeaseOperation(operation string, start time.Time) {
+	seconds := time.Since(start).Seconds()
+	LeaseOperationDuration.WithLabelValues(operation).Observe(seconds)
+	LeaseGrantDuration.WithLabelValues(operation).Observe(seconds)
 }
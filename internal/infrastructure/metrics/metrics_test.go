@@ -0,0 +1,17 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveLeaseOperation(t *testing.T) {
+	ObserveLeaseOperation(LeaseOperationRelease, time.Now())
+
+	assert.Equal(t, 1, testutil.CollectAndCount(LeaseOperationDuration, "shared_lock_lease_operation_duration_seconds"))
+	assert.Equal(t, 1, testutil.CollectAndCount(LeaseGrantDuration, "shared_lock_lease_grant_duration_seconds"),
+		"the deprecated name is still exported")
+}
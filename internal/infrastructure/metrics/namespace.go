@@ -0,0 +1,51 @@
+package metrics
+
+import "sync"
+
+// NamespaceOther labels namespaces that are not named in the configuration.
+const NamespaceOther = "other"
+
+// Namespaces maps namespaces to a bounded set of label values. Callers may
+// pick any namespace, so only the configured ones get series of their own.
+type Namespaces struct {
+	mu    sync.RWMutex
+	known map[string]struct{}
+}
+
+// NewNamespaces reports the namespaces in names as themselves and all
+// others as NamespaceOther.
+func NewNamespaces(names ...string) *Namespaces {
+	n := &Namespaces{}
+	n.Set(names...)
+
+	return n
+}
+
+// Set replaces the namespaces reported as themselves with names, for a
+// reloaded configuration.
+func (n *Namespaces) Set(names ...string) {
+	known := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		known[name] = struct{}{}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.known = known
+}
+
+// Label returns the namespace label value of namespace. The empty namespace
+// of a server without namespaces is kept as is.
+func (n *Namespaces) Label(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if _, known := n.known[namespace]; known {
+		return namespace
+	}
+
+	return NamespaceOther
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaces_Label(t *testing.T) {
+	namespaces := NewNamespaces("default", "team-a")
+
+	tests := []struct {
+		name      string
+		namespace string
+		expected  string
+	}{
+		{name: "configured", namespace: "team-a", expected: "team-a"},
+		{name: "default", namespace: "default", expected: "default"},
+		{name: "chosen by the caller", namespace: "team-x", expected: NamespaceOther},
+		{name: "namespaces disabled", namespace: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, namespaces.Label(tt.namespace))
+		})
+	}
+}
+
+func TestNamespaces_Set(t *testing.T) {
+	namespaces := NewNamespaces("default", "team-a")
+
+	namespaces.Set("default", "team-x")
+
+	assert.Equal(t, NamespaceOther, namespaces.Label("team-a"))
+	assert.Equal(t, "team-x", namespaces.Label("team-x"))
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	// PrefixNone labels keys without a prefix.
+	PrefixNone = "none"
+	// PrefixOther labels keys whose prefix was first seen after the limit
+	// of distinct prefixes was reached.
+	PrefixOther = "other"
+	// PrefixUnknown labels leases whose key is not known.
+	PrefixUnknown = "unknown"
+)
+
+// KeyPrefixes maps lock keys to a bounded set of prefix label values, so
+// that labelling metrics by key does not grow the number of series without
+// limit.
+type KeyPrefixes struct {
+	separator string
+	max       int
+
+	mu   sync.RWMutex
+	seen map[string]struct{}
+}
+
+// NewKeyPrefixes labels keys by the part before separator and reports at
+// most max distinct prefixes.
+func NewKeyPrefixes(separator string, max int) *KeyPrefixes {
+	return &KeyPrefixes{
+		separator: separator,
+		max:       max,
+		seen:      make(map[string]struct{}),
+	}
+}
+
+// Label returns the prefix label value of key.
+func (p *KeyPrefixes) Label(key string) string {
+	if key == "" {
+		return PrefixUnknown
+	}
+	if p.separator == "" {
+		return PrefixNone
+	}
+	prefix, _, found := strings.Cut(key, p.separator)
+	if !found || prefix == "" {
+		return PrefixNone
+	}
+
+	p.mu.RLock()
+	_, seen := p.seen[prefix]
+	p.mu.RUnlock()
+	if seen {
+		return prefix
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, seen = p.seen[prefix]; !seen {
+		if len(p.seen) >= p.max {
+			return PrefixOther
+		}
+		p.seen[prefix] = struct{}{}
+	}
+
+	return prefix
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPrefixes_Label(t *testing.T) {
+	prefixes := NewKeyPrefixes("/", 2)
+
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{name: "first prefix", key: "billing/invoice-1", expected: "billing"},
+		{name: "second prefix", key: "reports/daily", expected: "reports"},
+		{name: "known prefix", key: "billing/invoice-2", expected: "billing"},
+		{name: "prefix over the limit", key: "exports/weekly", expected: PrefixOther},
+		{name: "known prefix after the limit", key: "reports/weekly", expected: "reports"},
+		{name: "no separator", key: "standalone", expected: PrefixNone},
+		{name: "leading separator", key: "/rooted", expected: PrefixNone},
+		{name: "unknown key", key: "", expected: PrefixUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, prefixes.Label(tt.key))
+		})
+	}
+}
+
+func TestKeyPrefixes_NoSeparator(t *testing.T) {
+	prefixes := NewKeyPrefixes("", 10)
+
+	assert.Equal(t, PrefixNone, prefixes.Label("billing/invoice-1"))
+}
@@ -0,0 +1,88 @@
+// Package ratelimit applies token-bucket rate limits to arbitrary keys such
+// as client identities or lock keys.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is how often buckets that are full again are dropped, so
+// that the number of tracked keys stays bounded by the recently active ones.
+const sweepInterval = time.Minute
+
+// Limiter keeps one token bucket per key. All buckets share the same rate
+// and burst; changing them resets every bucket.
+type Limiter struct {
+	mu        sync.Mutex
+	rate      rate.Limit
+	burst     int
+	buckets   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets:   make(map[string]*rate.Limiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key, refilled at perSecond tokens
+// per second up to burst. When the bucket is empty it returns false and how
+// long until a token is available. A non-positive perSecond allows
+// everything.
+func (l *Limiter) Allow(key string, perSecond float64, burst int) (bool, time.Duration) {
+	if perSecond <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate != rate.Limit(perSecond) || l.burst != burst {
+		l.rate, l.burst = rate.Limit(perSecond), burst
+		l.buckets = make(map[string]*rate.Limiter)
+	}
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = rate.NewLimiter(l.rate, l.burst)
+		l.buckets[key] = bucket
+	}
+
+	reservation := bucket.ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// Len returns the number of tracked keys.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buckets)
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.TokensAt(now) >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
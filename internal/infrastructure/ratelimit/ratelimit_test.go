@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	limiter := New()
+
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.Allow("client-a", 1, 3)
+		assert.True(t, allowed, "request %d is within the burst", i)
+	}
+
+	allowed, retryAfter := limiter.Allow("client-a", 1, 3)
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter, time.Duration(0))
+	assert.LessOrEqual(t, retryAfter, time.Second)
+
+	allowed, _ = limiter.Allow("client-b", 1, 3)
+	assert.True(t, allowed, "keys have separate buckets")
+
+	allowed, _ = limiter.Allow("client-a", 1, 5)
+	assert.True(t, allowed, "changing the limit resets the buckets")
+
+	allowed, _ = limiter.Allow("client-a", 0, 0)
+	assert.True(t, allowed, "a zero rate disables the limit")
+}
+
+func TestLimiter_SweepsFullBuckets(t *testing.T) {
+	limiter := New()
+	limiter.Allow("idle", 100, 1)
+	time.Sleep(20 * time.Millisecond)
+	limiter.Allow("busy", 100, 1)
+
+	limiter.lastSweep = time.Now().Add(-2 * sweepInterval)
+	limiter.Allow("new", 100, 1)
+
+	assert.Equal(t, 2, limiter.Len(), "only the refilled bucket is dropped")
+	_, exists := limiter.buckets["idle"]
+	assert.False(t, exists)
+}
@@ -3,13 +3,18 @@ package etcd
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/tentens-tech/shared-lock/internal/config"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
@@ -17,6 +22,8 @@ import (
 const (
 	defaultLeaseValue  = "lock-value"
 	defaultDialTimeout = 5 * time.Second
+
+	unusedLeaseRevokeTimeout = 5 * time.Second
 )
 
 type Etcd struct {
@@ -65,9 +72,11 @@ func generateTLSConfig(cfg *config.Config) (*tls.Config, error) {
 	return tlsConfig, nil
 }
 
-func (etcd *Etcd) CheckLeasePresence(ctx context.Context, key string) (int64, error) {
-	var err error
-	var leaseID int64
+func (etcd *Etcd) CheckLeasePresence(ctx context.Context, key string) (leaseID int64, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.CheckLeasePresence", tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+	defer observe("check_presence", time.Now())
+
 	getCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 
 	resp, err := etcd.Client.Get(getCtx, key)
@@ -84,9 +93,11 @@ func (etcd *Etcd) CheckLeasePresence(ctx context.Context, key string) (int64, er
 	return leaseID, nil
 }
 
-func (etcd *Etcd) CreateLease(ctx context.Context, key string, leaseTTL int64, data []byte) (string, int64, error) {
+func (etcd *Etcd) CreateLease(ctx context.Context, key string, leaseTTL int64, data []byte) (_ string, _ int64, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.CreateLease", tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
 	var leaseResp *clientv3.LeaseGrantResponse
-	var err error
 	var value string
 
 	if data == nil {
@@ -96,38 +107,302 @@ func (etcd *Etcd) CreateLease(ctx context.Context, key string, leaseTTL int64, d
 	}
 
 	log.Debugf("Creating lease for the key: %v", key)
-	leaseResp, err = etcd.Client.Grant(ctx, leaseTTL)
+	grantCtx, grantSpan := tracing.Start(ctx, "etcd.Grant")
+	grantStart := time.Now()
+	leaseResp, err = etcd.Client.Grant(grantCtx, leaseTTL)
+	observe("grant", grantStart)
+	tracing.End(grantSpan, err)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to create lease: %v", err)
 	}
 
 	var TxnResp *clientv3.TxnResponse
-	TxnResp, err = etcd.Client.Txn(ctx).
+	txnCtx, txnSpan := tracing.Start(ctx, "etcd.Txn", tracing.LeaseIDAttribute.Int64(int64(leaseResp.ID)))
+	txnStart := time.Now()
+	TxnResp, err = etcd.Client.Txn(txnCtx).
 		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
 		Then(clientv3.OpPut(key, value, clientv3.WithLease(leaseResp.ID))).
+		Else(clientv3.OpGet(key)).
 		Commit()
+	observe("create", txnStart)
+	tracing.End(txnSpan, err)
 	if err != nil {
+		etcd.revokeUnusedLease(ctx, leaseResp.ID)
 		return "", 0, err
 	}
 
 	if !TxnResp.Succeeded {
-		log.Warnf("Lease race")
-		return storage.StatusAccepted, 0, nil
+		etcd.revokeUnusedLease(ctx, leaseResp.ID)
+
+		var winnerID int64
+		if kvs := TxnResp.Responses[0].GetResponseRange().GetKvs(); len(kvs) > 0 {
+			winnerID = kvs[0].Lease
+		}
+		log.Debugf("Lease race for the key %v lost to lease %v", key, winnerID)
+
+		return storage.StatusAccepted, winnerID, nil
 	}
 
 	log.Printf("%v key created with a new lease %v", key, leaseResp.ID)
 	return storage.StatusCreated, int64(leaseResp.ID), nil
 }
 
-func (etcd *Etcd) KeepLeaseOnce(ctx context.Context, leaseID int64) error {
+// revokeUnusedLease revokes a lease granted for a key that was not created,
+// so it does not linger in etcd until its TTL. It runs even when the request
+// context has been canceled.
+func (etcd *Etcd) revokeUnusedLease(ctx context.Context, leaseID clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), unusedLeaseRevokeTimeout)
+	defer cancel()
+
+	if _, err := etcd.Client.Revoke(ctx, leaseID); err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		log.Warnf("Failed to revoke unused lease %v: %v", leaseID, err)
+	}
+}
+
+func (etcd *Etcd) KeepLeaseOnce(ctx context.Context, leaseID int64) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.KeepLeaseOnce", tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+	defer observe("keepalive", time.Now())
+
 	ctxWithCancel, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	_, err := etcd.Client.KeepAliveOnce(ctxWithCancel, clientv3.LeaseID(leaseID))
+	resp, err := etcd.Client.KeepAliveOnce(ctxWithCancel, clientv3.LeaseID(leaseID))
 	if err != nil {
-		return err
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return 0, storage.ErrLeaseNotFound
+		}
+		return 0, err
 	}
 
 	log.Debugf("KeepAlive lease: %v", leaseID)
+	return resp.TTL, nil
+}
+
+func (etcd *Etcd) RevokeLease(ctx context.Context, key string, leaseID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "etcd.RevokeLease", tracing.KeyAttribute.String(key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+	defer observe("revoke", time.Now())
+
+	txnResp, err := etcd.Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(key), "=", leaseID)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("failed to release key: %v", err)
+	}
+	if !txnResp.Succeeded {
+		return storage.ErrLeaseNotFound
+	}
+
+	if _, err = etcd.Client.Revoke(ctx, clientv3.LeaseID(leaseID)); err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		log.Warnf("Key %v released but revoking lease %v failed: %v", key, leaseID, err)
+	}
+
+	log.Printf("%v key released by lease %v", key, leaseID)
+	return nil
+}
+
+func (etcd *Etcd) TransferLease(ctx context.Context, key string, leaseID, leaseTTL int64, data []byte) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.TransferLease", tracing.KeyAttribute.String(key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+	defer observe("transfer", time.Now())
+
+	leaseResp, err := etcd.Client.Grant(ctx, leaseTTL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create lease: %v", err)
+	}
+
+	// Putting the key again moves it to the new lease and raises its
+	// revision, so the new holder orders after the previous one.
+	txnResp, err := etcd.Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.LeaseValue(key), "=", leaseID)).
+		Then(clientv3.OpPut(key, string(data), clientv3.WithLease(leaseResp.ID))).
+		Commit()
+	if err != nil {
+		etcd.revokeUnusedLease(ctx, leaseResp.ID)
+		return 0, fmt.Errorf("failed to transfer key: %v", err)
+	}
+	if !txnResp.Succeeded {
+		etcd.revokeUnusedLease(ctx, leaseResp.ID)
+		return 0, storage.ErrLeaseNotFound
+	}
+
+	if _, err = etcd.Client.Revoke(ctx, clientv3.LeaseID(leaseID)); err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		log.Warnf("Key %v transferred but revoking lease %v failed: %v", key, leaseID, err)
+	}
+
+	log.Printf("%v key transferred from lease %v to lease %v", key, leaseID, leaseResp.ID)
+	return int64(leaseResp.ID), nil
+}
+
+func (etcd *Etcd) AttachToLease(ctx context.Context, key string, leaseID int64, data []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "etcd.AttachToLease", tracing.KeyAttribute.String(key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+	defer observe("attach", time.Now())
+
+	_, err = etcd.Client.Put(ctx, key, string(data), clientv3.WithLease(clientv3.LeaseID(leaseID)))
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return storage.ErrLeaseNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put key to etcd: %v", err)
+	}
+
 	return nil
 }
+
+func (etcd *Etcd) GetLease(ctx context.Context, key string) (_ *storage.LeaseInfo, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.GetLease", tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+	defer observe("get", time.Now())
+
+	resp, err := etcd.Client.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key from etcd: %v", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, storage.ErrLeaseNotFound
+	}
+
+	info := etcd.leaseInfo(ctx, resp.Kvs[0])
+	return &info, nil
+}
+
+func (etcd *Etcd) LeaseKeys(ctx context.Context, leaseID int64) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.LeaseKeys", tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+	defer observe("lease_keys", time.Now())
+
+	resp, err := etcd.Client.TimeToLive(ctx, clientv3.LeaseID(leaseID), clientv3.WithAttachedKeys())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys of lease %v: %v", leaseID, err)
+	}
+	// A lease that does not exist is reported with a TTL of -1.
+	if resp.TTL < 0 {
+		return nil, storage.ErrLeaseNotFound
+	}
+
+	keys := make([]string, 0, len(resp.Keys))
+	for _, key := range resp.Keys {
+		keys = append(keys, string(key))
+	}
+
+	return keys, nil
+}
+
+func (etcd *Etcd) ListLeases(ctx context.Context, prefix string) (_ []storage.LeaseInfo, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.ListLeases", tracing.KeyAttribute.String(prefix))
+	defer func() { tracing.End(span, err) }()
+	defer observe("list", time.Now())
+
+	resp, err := etcd.list(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make([]storage.LeaseInfo, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		leases = append(leases, etcd.leaseInfo(ctx, kv))
+	}
+
+	return leases, nil
+}
+
+func (etcd *Etcd) ListKeys(ctx context.Context, prefix string) (_ []storage.LeaseInfo, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.ListKeys", tracing.KeyAttribute.String(prefix))
+	defer func() { tracing.End(span, err) }()
+	defer observe("list_keys", time.Now())
+
+	resp, err := etcd.list(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make([]storage.LeaseInfo, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		leases = append(leases, keyInfo(kv))
+	}
+
+	return leases, nil
+}
+
+func (etcd *Etcd) list(ctx context.Context, prefix string) (*clientv3.GetResponse, error) {
+	resp, err := etcd.Client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys from etcd: %v", err)
+	}
+
+	return resp, nil
+}
+
+func (etcd *Etcd) WatchLeases(ctx context.Context, prefix string) (<-chan storage.LeaseEvent, error) {
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	watchChan := etcd.Client.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+
+	created, ok := <-watchChan
+	if !ok || created.Err() != nil {
+		cancel()
+		if ok {
+			return nil, fmt.Errorf("failed to watch %v: %v", prefix, created.Err())
+		}
+		return nil, fmt.Errorf("failed to watch %v: %v", prefix, ctx.Err())
+	}
+
+	events := make(chan storage.LeaseEvent)
+	go func() {
+		defer close(events)
+		defer cancel()
+
+		for resp := range watchChan {
+			if err := resp.Err(); err != nil {
+				log.Warnf("Watch on %v broke: %v", prefix, err)
+				return
+			}
+			for _, event := range resp.Events {
+				leaseEvent := storage.LeaseEvent{
+					Key:     string(event.Kv.Key),
+					LeaseID: event.Kv.Lease,
+					Deleted: event.Type == mvccpb.DELETE,
+				}
+				select {
+				case events <- leaseEvent:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// observe records the duration of a storage request started at start.
+func observe(operation string, start time.Time) {
+	metrics.StorageRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func keyInfo(kv *mvccpb.KeyValue) storage.LeaseInfo {
+	return storage.LeaseInfo{
+		Key:            string(kv.Key),
+		LeaseID:        kv.Lease,
+		Value:          kv.Value,
+		CreateRevision: kv.CreateRevision,
+	}
+}
+
+func (etcd *Etcd) leaseInfo(ctx context.Context, kv *mvccpb.KeyValue) storage.LeaseInfo {
+	info := keyInfo(kv)
+
+	if kv.Lease != 0 {
+		ttlResp, err := etcd.Client.TimeToLive(ctx, clientv3.LeaseID(kv.Lease))
+		if err != nil {
+			log.Warnf("Failed to get TTL of lease %v: %v", kv.Lease, err)
+		} else {
+			info.TTL = ttlResp.TTL
+			info.GrantedTTL = ttlResp.GrantedTTL
+		}
+	}
+
+	return info
+}
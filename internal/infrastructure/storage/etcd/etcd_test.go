@@ -0,0 +1,335 @@
+package etcd
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/etcd/etcdtest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func newTestStorage(t *testing.T, opts ...etcdtest.Option) *Etcd {
+	t.Helper()
+
+	server := etcdtest.Start(t, opts...)
+	etcdStorage, err := New(server.Config())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = etcdStorage.Client.Close()
+	})
+
+	return etcdStorage
+}
+
+func TestGenerateTLSConfig(t *testing.T) {
+	certs, err := etcdtest.GenerateCerts(t.TempDir())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		etcdCfg     config.EtcdCfg
+		expectNil   bool
+		expectError bool
+	}{
+		{
+			name:      "TLS disabled",
+			etcdCfg:   config.EtcdCfg{TLSEnabled: false},
+			expectNil: true,
+		},
+		{
+			name: "TLS enabled with valid certificates",
+			etcdCfg: config.EtcdCfg{
+				TLSEnabled:           true,
+				ServerCACertPath:     certs.CACertPath,
+				ServerClientCertPath: certs.ClientCertPath,
+				ServerClientKeyPath:  certs.ClientKeyPath,
+			},
+		},
+		{
+			name: "TLS enabled with missing CA",
+			etcdCfg: config.EtcdCfg{
+				TLSEnabled:           true,
+				ServerCACertPath:     filepath.Join(t.TempDir(), "missing.crt"),
+				ServerClientCertPath: certs.ClientCertPath,
+				ServerClientKeyPath:  certs.ClientKeyPath,
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Storage: config.StorageCfg{Type: "etcd", Etcd: tt.etcdCfg}}
+
+			tlsConfig, err := generateTLSConfig(cfg)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.expectNil {
+				assert.Nil(t, tlsConfig)
+				return
+			}
+			require.NotNil(t, tlsConfig)
+			assert.NotNil(t, tlsConfig.RootCAs)
+			assert.NotNil(t, tlsConfig.GetClientCertificate)
+		})
+	}
+}
+
+func TestEtcd_CreateLease(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+	key := "/shared-lock/create-lease"
+
+	leaseID, err := etcdStorage.CheckLeasePresence(ctx, key)
+	require.NoError(t, err)
+	assert.Zero(t, leaseID)
+
+	status, leaseID, err := etcdStorage.CreateLease(ctx, key, 10, []byte("holder"))
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status)
+	assert.NotZero(t, leaseID)
+
+	presentLeaseID, err := etcdStorage.CheckLeasePresence(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, leaseID, presentLeaseID)
+
+	resp, err := etcdStorage.Client.Get(ctx, key)
+	require.NoError(t, err)
+	require.Len(t, resp.Kvs, 1)
+	assert.Equal(t, "holder", string(resp.Kvs[0].Value))
+}
+
+func TestEtcd_CreateLeaseDefaultValue(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+	key := "/shared-lock/default-value"
+
+	_, _, err := etcdStorage.CreateLease(ctx, key, 10, nil)
+	require.NoError(t, err)
+
+	resp, err := etcdStorage.Client.Get(ctx, key)
+	require.NoError(t, err)
+	require.Len(t, resp.Kvs, 1)
+	assert.Equal(t, defaultLeaseValue, string(resp.Kvs[0].Value))
+}
+
+func TestEtcd_CreateLeaseRace(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+	key := "/shared-lock/race"
+
+	numContenders := 20
+	type result struct {
+		status  string
+		leaseID int64
+	}
+	results := make(chan result, numContenders)
+	var wg sync.WaitGroup
+	wg.Add(numContenders)
+
+	for i := 0; i < numContenders; i++ {
+		go func() {
+			defer wg.Done()
+
+			status, leaseID, err := etcdStorage.CreateLease(ctx, key, 10, nil)
+			assert.NoError(t, err)
+			results <- result{status, leaseID}
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	var winnerID int64
+	created := 0
+	var acceptedIDs []int64
+	for r := range results {
+		if r.status == storage.StatusCreated {
+			created++
+			winnerID = r.leaseID
+		} else {
+			assert.Equal(t, storage.StatusAccepted, r.status)
+			acceptedIDs = append(acceptedIDs, r.leaseID)
+		}
+	}
+	assert.Equal(t, 1, created)
+	for _, leaseID := range acceptedIDs {
+		assert.Equal(t, winnerID, leaseID)
+	}
+
+	leases, err := etcdStorage.Client.Leases(ctx)
+	require.NoError(t, err)
+	require.Len(t, leases.Leases, 1, "leases granted to losing contenders must be revoked")
+	assert.Equal(t, winnerID, int64(leases.Leases[0].ID))
+}
+
+func TestEtcd_WatchLeases(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := etcdStorage.WatchLeases(ctx, "/shared-lock/")
+	require.NoError(t, err)
+
+	key := "/shared-lock/watched"
+	_, leaseID, err := etcdStorage.CreateLease(ctx, key, 10, nil)
+	require.NoError(t, err)
+	_, err = etcdStorage.Client.Put(ctx, "/other/ignored", "value")
+	require.NoError(t, err)
+	require.NoError(t, etcdStorage.RevokeLease(ctx, key, leaseID))
+
+	assert.Equal(t, storage.LeaseEvent{Key: key, LeaseID: leaseID}, <-events)
+	assert.Equal(t, storage.LeaseEvent{Key: key, Deleted: true}, <-events)
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestEtcd_KeepLeaseOnce(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+
+	_, leaseID, err := etcdStorage.CreateLease(ctx, "/shared-lock/keepalive", 10, nil)
+	require.NoError(t, err)
+
+	ttl, err := etcdStorage.KeepLeaseOnce(ctx, leaseID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), ttl)
+
+	_, err = etcdStorage.Client.Revoke(ctx, clientv3.LeaseID(leaseID))
+	require.NoError(t, err)
+
+	_, err = etcdStorage.KeepLeaseOnce(ctx, leaseID)
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound)
+}
+
+func TestEtcd_LeaseKeys(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+	key := "/shared-lock/team/by-id"
+
+	_, leaseID, err := etcdStorage.CreateLease(ctx, key, 10, nil)
+	require.NoError(t, err)
+
+	keys, err := etcdStorage.LeaseKeys(ctx, leaseID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, keys)
+
+	require.NoError(t, etcdStorage.RevokeLease(ctx, key, leaseID))
+	_, err = etcdStorage.LeaseKeys(ctx, leaseID)
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound)
+}
+
+func TestEtcd_TLS(t *testing.T) {
+	etcdStorage := newTestStorage(t, etcdtest.WithTLS())
+	ctx := context.Background()
+	key := "/shared-lock/tls"
+
+	status, leaseID, err := etcdStorage.CreateLease(ctx, key, 10, nil)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCreated, status)
+
+	presentLeaseID, err := etcdStorage.CheckLeasePresence(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, leaseID, presentLeaseID)
+}
+
+func TestEtcd_TLSRejectsClientWithoutCertificate(t *testing.T) {
+	server := etcdtest.Start(t, etcdtest.WithTLS())
+	cfg := server.Config()
+	cfg.Storage.Etcd.TLSEnabled = false
+
+	etcdStorage, err := New(cfg)
+	require.NoError(t, err)
+	defer etcdStorage.Client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+
+	_, err = etcdStorage.CheckLeasePresence(ctx, "/shared-lock/plain")
+	assert.Error(t, err)
+}
+
+func TestEtcd_GetListAndRevokeLease(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+
+	_, leaseA, err := etcdStorage.CreateLease(ctx, "/shared-lock/team/a", 30, []byte("a"))
+	require.NoError(t, err)
+	_, leaseB, err := etcdStorage.CreateLease(ctx, "/shared-lock/team/b", 30, []byte("b"))
+	require.NoError(t, err)
+
+	info, err := etcdStorage.GetLease(ctx, "/shared-lock/team/a")
+	require.NoError(t, err)
+	assert.Equal(t, leaseA, info.LeaseID)
+	assert.Equal(t, "a", string(info.Value))
+	assert.Positive(t, info.TTL)
+	assert.LessOrEqual(t, info.TTL, int64(30))
+	assert.Equal(t, int64(30), info.GrantedTTL)
+
+	_, err = etcdStorage.GetLease(ctx, "/shared-lock/team/missing")
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound)
+
+	leases, err := etcdStorage.ListLeases(ctx, "/shared-lock/team/")
+	require.NoError(t, err)
+	require.Len(t, leases, 2)
+	assert.Equal(t, "/shared-lock/team/a", leases[0].Key)
+	assert.Equal(t, leaseB, leases[1].LeaseID)
+	assert.Equal(t, int64(30), leases[1].GrantedTTL)
+
+	keys, err := etcdStorage.ListKeys(ctx, "/shared-lock/team/")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, storage.LeaseInfo{Key: "/shared-lock/team/b", LeaseID: leaseB, Value: []byte("b"), CreateRevision: leases[1].CreateRevision}, keys[1])
+
+	assert.ErrorIs(t, etcdStorage.RevokeLease(ctx, "/shared-lock/team/a", leaseB), storage.ErrLeaseNotFound)
+	require.NoError(t, etcdStorage.RevokeLease(ctx, "/shared-lock/team/a", leaseA))
+
+	_, err = etcdStorage.GetLease(ctx, "/shared-lock/team/a")
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound)
+	_, err = etcdStorage.KeepLeaseOnce(ctx, leaseA)
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound, "released lease must be revoked")
+}
+
+func TestEtcd_TransferLease(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+	key := "/shared-lock/team/transfer"
+
+	_, oldLeaseID, err := etcdStorage.CreateLease(ctx, key, 30, []byte("old"))
+	require.NoError(t, err)
+	before, err := etcdStorage.Client.Get(ctx, key)
+	require.NoError(t, err)
+
+	_, err = etcdStorage.TransferLease(ctx, key, oldLeaseID+1, 30, []byte("wrong"))
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound)
+
+	newLeaseID, err := etcdStorage.TransferLease(ctx, key, oldLeaseID, 30, []byte("new"))
+	require.NoError(t, err)
+	assert.NotEqual(t, oldLeaseID, newLeaseID)
+
+	after, err := etcdStorage.Client.Get(ctx, key)
+	require.NoError(t, err)
+	require.Len(t, after.Kvs, 1)
+	assert.Equal(t, "new", string(after.Kvs[0].Value))
+	assert.Equal(t, newLeaseID, after.Kvs[0].Lease)
+	assert.Equal(t, before.Kvs[0].CreateRevision, after.Kvs[0].CreateRevision, "the key must not be free in between")
+	assert.Greater(t, after.Kvs[0].ModRevision, before.Kvs[0].ModRevision)
+
+	info, err := etcdStorage.GetLease(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), info.GrantedTTL)
+	_, err = etcdStorage.KeepLeaseOnce(ctx, oldLeaseID)
+	assert.ErrorIs(t, err, storage.ErrLeaseNotFound, "the previous lease must be revoked")
+}
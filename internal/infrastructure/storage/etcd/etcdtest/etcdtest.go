@@ -0,0 +1,256 @@
+// Package etcdtest starts an embedded, single-member etcd server for
+// integration tests of the etcd storage backend and the layers above it.
+package etcdtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+const startTimeout = 30 * time.Second
+
+// Server is a running embedded etcd instance.
+type Server struct {
+	Etcd      *embed.Etcd
+	Endpoints []string
+	// Certs is set when the server was started WithTLS.
+	Certs *Certs
+}
+
+// Certs holds the paths of the PEM files generated for a TLS-enabled server.
+type Certs struct {
+	CACertPath     string
+	ServerCertPath string
+	ServerKeyPath  string
+	ClientCertPath string
+	ClientKeyPath  string
+}
+
+type options struct {
+	tls bool
+}
+
+type Option func(*options)
+
+// WithTLS serves the client endpoint over TLS with freshly generated
+// certificates and requires clients to present a certificate signed by the
+// same CA.
+func WithTLS() Option {
+	return func(o *options) {
+		o.tls = true
+	}
+}
+
+// Start launches an embedded etcd server and registers its shutdown with
+// t.Cleanup. The test is skipped in -short mode.
+func Start(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping embedded etcd integration test in short mode")
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	dir := t.TempDir()
+	scheme := "http"
+
+	cfg := embed.NewConfig()
+	cfg.Name = "shared-lock-test"
+	cfg.Dir = filepath.Join(dir, "data")
+	cfg.LogLevel = "error"
+
+	server := &Server{}
+	if o.tls {
+		certs, err := GenerateCerts(filepath.Join(dir, "certs"))
+		if err != nil {
+			t.Fatalf("failed to generate certificates: %v", err)
+		}
+		server.Certs = certs
+		scheme = "https"
+
+		cfg.ClientTLSInfo = transport.TLSInfo{
+			CertFile:       certs.ServerCertPath,
+			KeyFile:        certs.ServerKeyPath,
+			TrustedCAFile:  certs.CACertPath,
+			ClientCertAuth: true,
+		}
+	}
+
+	clientURL := mustURL(t, fmt.Sprintf("%s://%s", scheme, freeAddr(t)))
+	peerURL := mustURL(t, fmt.Sprintf("http://%s", freeAddr(t)))
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("failed to start embedded etcd: %v", err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(startTimeout):
+		e.Server.Stop()
+		t.Fatalf("embedded etcd did not become ready within %v", startTimeout)
+	}
+
+	server.Etcd = e
+	server.Endpoints = []string{clientURL.String()}
+
+	return server
+}
+
+// Config returns a service configuration pointing the etcd storage backend
+// at this server, including client certificates when TLS is enabled.
+func (s *Server) Config() *config.Config {
+	cfg := config.NewConfig()
+	cfg.Storage.Type = "etcd"
+	cfg.Storage.Etcd.EtcdAddrList = s.Endpoints
+	cfg.Storage.Etcd.TLSEnabled = s.Certs != nil
+
+	if s.Certs != nil {
+		cfg.Storage.Etcd.ServerCACertPath = s.Certs.CACertPath
+		cfg.Storage.Etcd.ServerClientCertPath = s.Certs.ClientCertPath
+		cfg.Storage.Etcd.ServerClientKeyPath = s.Certs.ClientKeyPath
+	}
+
+	return cfg
+}
+
+// GenerateCerts writes a self-signed CA together with a server certificate
+// valid for localhost/127.0.0.1 and a client certificate into dir.
+func GenerateCerts(dir string) (*Certs, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "shared-lock-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := &Certs{
+		CACertPath:     filepath.Join(dir, "ca.crt"),
+		ServerCertPath: filepath.Join(dir, "server.crt"),
+		ServerKeyPath:  filepath.Join(dir, "server.key"),
+		ClientCertPath: filepath.Join(dir, "client.crt"),
+		ClientKeyPath:  filepath.Join(dir, "client.key"),
+	}
+	if err = writePEM(certs.CACertPath, "CERTIFICATE", caDER); err != nil {
+		return nil, err
+	}
+
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if err = issue(serverTemplate, caCert, caKey, certs.ServerCertPath, certs.ServerKeyPath); err != nil {
+		return nil, err
+	}
+
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "shared-lock-test-client"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if err = issue(clientTemplate, caCert, caKey, certs.ClientCertPath, certs.ClientKeyPath); err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+func issue(template, ca *x509.Certificate, caKey *ecdsa.PrivateKey, certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err = writePEM(certPath, "CERTIFICATE", der); err != nil {
+		return err
+	}
+	return writePEM(keyPath, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(path, blockType string, der []byte) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+}
+
+func freeAddr(t testing.TB) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to allocate a free port: %v", err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func mustURL(t testing.TB, raw string) url.URL {
+	t.Helper()
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("failed to parse url %q: %v", raw, err)
+	}
+
+	return *u
+}
@@ -0,0 +1,160 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// History records are stored under the history key followed by the time of
+// the record in nanoseconds, zero padded so that the keys sort by time.
+const historyTimeFormat = "%s/%020d"
+
+func historyRecordKey(key string, t time.Time) string {
+	return fmt.Sprintf(historyTimeFormat, key, t.UnixNano())
+}
+
+func (etcd *Etcd) AppendHistory(ctx context.Context, key string, t time.Time, value []byte, retention storage.HistoryRetention) (err error) {
+	ctx, span := tracing.Start(ctx, "etcd.AppendHistory", tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+	defer observe("append_history", time.Now())
+
+	// Records at the same nanosecond are moved apart rather than overwritten.
+	recordKey := historyRecordKey(key, t)
+	for {
+		resp, err := etcd.Client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(recordKey), "=", 0)).
+			Then(clientv3.OpPut(recordKey, string(value))).
+			Commit()
+		if err != nil {
+			return fmt.Errorf("failed to append history of %v: %v", key, err)
+		}
+		if resp.Succeeded {
+			break
+		}
+		t = t.Add(time.Nanosecond)
+		recordKey = historyRecordKey(key, t)
+	}
+
+	return etcd.pruneHistory(ctx, key, retention)
+}
+
+// pruneHistory deletes the records of the history at key that are older than
+// the retention allows or beyond its newest MaxRecords.
+func (etcd *Etcd) pruneHistory(ctx context.Context, key string, retention storage.HistoryRetention) error {
+	prefix := key + "/"
+
+	if retention.MaxAge > 0 {
+		end := historyRecordKey(key, time.Now().Add(-retention.MaxAge))
+		if _, err := etcd.Client.Delete(ctx, prefix, clientv3.WithRange(end)); err != nil {
+			return fmt.Errorf("failed to prune history of %v: %v", key, err)
+		}
+	}
+
+	if retention.MaxRecords > 0 {
+		resp, err := etcd.Client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend), clientv3.WithLimit(int64(retention.MaxRecords)+1))
+		if err != nil {
+			return fmt.Errorf("failed to prune history of %v: %v", key, err)
+		}
+		if len(resp.Kvs) > retention.MaxRecords {
+			end := string(resp.Kvs[retention.MaxRecords].Key) + "\x00"
+			if _, err := etcd.Client.Delete(ctx, prefix, clientv3.WithRange(end)); err != nil {
+				return fmt.Errorf("failed to prune history of %v: %v", key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (etcd *Etcd) PruneHistories(ctx context.Context, prefix string, retention storage.HistoryRetention) (err error) {
+	ctx, span := tracing.Start(ctx, "etcd.PruneHistories")
+	defer func() { tracing.End(span, err) }()
+	defer observe("prune_histories", time.Now())
+
+	if retention.MaxAge <= 0 && retention.MaxRecords <= 0 {
+		return nil
+	}
+
+	// The records of a history are contiguous and sorted by time, so those to
+	// drop are found from the keys alone and deleted as one range each.
+	resp, err := etcd.Client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return fmt.Errorf("failed to list histories under %v: %v", prefix, err)
+	}
+
+	cutoff := time.Now().Add(-retention.MaxAge)
+	for start := 0; start < len(resp.Kvs); {
+		recordKey := string(resp.Kvs[start].Key)
+		key := recordKey[:strings.LastIndex(recordKey, "/")]
+		end := start + 1
+		for end < len(resp.Kvs) && strings.HasPrefix(string(resp.Kvs[end].Key), key+"/") {
+			end++
+		}
+		records := resp.Kvs[start:end]
+		start = end
+
+		drop := 0
+		if retention.MaxAge > 0 {
+			oldest := historyRecordKey(key, cutoff)
+			for drop < len(records) && string(records[drop].Key) < oldest {
+				drop++
+			}
+		}
+		if retention.MaxRecords > 0 {
+			drop = max(drop, len(records)-retention.MaxRecords)
+		}
+		if drop == 0 {
+			continue
+		}
+
+		if _, err := etcd.Client.Delete(ctx, key+"/", clientv3.WithRange(string(records[drop-1].Key)+"\x00")); err != nil {
+			return fmt.Errorf("failed to prune history of %v: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (etcd *Etcd) History(ctx context.Context, key string, from, to time.Time, limit int) (_ []storage.HistoryRecord, err error) {
+	ctx, span := tracing.Start(ctx, "etcd.History", tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+	defer observe("history", time.Now())
+
+	start := key + "/"
+	if !from.IsZero() {
+		start = historyRecordKey(key, from)
+	}
+	end := clientv3.GetPrefixRangeEnd(key + "/")
+	if !to.IsZero() {
+		end = historyRecordKey(key, to)
+	}
+
+	opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend)}
+	if limit > 0 {
+		opts = append(opts, clientv3.WithLimit(int64(limit)))
+	}
+	resp, err := etcd.Client.Get(ctx, start, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history of %v: %v", key, err)
+	}
+
+	records := make([]storage.HistoryRecord, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		nanos, err := strconv.ParseInt(strings.TrimPrefix(string(kv.Key), key+"/"), 10, 64)
+		if err != nil {
+			continue
+		}
+		records = append(records, storage.HistoryRecord{Time: time.Unix(0, nanos).UTC(), Value: kv.Value})
+	}
+
+	return records, nil
+}
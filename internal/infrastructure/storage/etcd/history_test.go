@@ -0,0 +1,94 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+)
+
+func historyValues(records []storage.HistoryRecord) []string {
+	values := make([]string, 0, len(records))
+	for _, record := range records {
+		values = append(values, string(record.Value))
+	}
+
+	return values
+}
+
+func TestEtcd_History(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+	key := "/shared-lock-history/team/nightly-billing"
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	for i, value := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, etcdStorage.AppendHistory(ctx, key, start.Add(time.Duration(i)*time.Minute), []byte(value), storage.HistoryRetention{}))
+	}
+	// Records of the same instant are all kept.
+	require.NoError(t, etcdStorage.AppendHistory(ctx, key, start.Add(3*time.Minute), []byte("e"), storage.HistoryRetention{}))
+	require.NoError(t, etcdStorage.AppendHistory(ctx, key+"%2Fchild", start, []byte("child"), storage.HistoryRetention{}))
+
+	records, err := etcdStorage.History(ctx, key, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, historyValues(records))
+	assert.True(t, start.Equal(records[0].Time))
+
+	records, err = etcdStorage.History(ctx, key, start.Add(time.Minute), start.Add(3*time.Minute), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, historyValues(records))
+
+	records, err = etcdStorage.History(ctx, key, time.Time{}, time.Time{}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, historyValues(records))
+}
+
+func TestEtcd_HistoryRetention(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+	key := "/shared-lock-history/team/reports"
+	now := time.Now()
+
+	require.NoError(t, etcdStorage.AppendHistory(ctx, key, now.Add(-48*time.Hour), []byte("old"), storage.HistoryRetention{}))
+	for i, value := range []string{"a", "b", "c"} {
+		require.NoError(t, etcdStorage.AppendHistory(ctx, key, now.Add(time.Duration(i)*time.Second), []byte(value), storage.HistoryRetention{}))
+	}
+	retention := storage.HistoryRetention{MaxAge: 24 * time.Hour, MaxRecords: 2}
+	require.NoError(t, etcdStorage.AppendHistory(ctx, key, now.Add(3*time.Second), []byte("d"), retention))
+
+	records, err := etcdStorage.History(ctx, key, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, historyValues(records))
+}
+
+func TestEtcd_PruneHistories(t *testing.T) {
+	etcdStorage := newTestStorage(t)
+	ctx := context.Background()
+	prefix := "/shared-lock-history/prune/"
+	now := time.Now()
+
+	require.NoError(t, etcdStorage.AppendHistory(ctx, prefix+"stale", now.Add(-48*time.Hour), []byte("old"), storage.HistoryRetention{}))
+	require.NoError(t, etcdStorage.AppendHistory(ctx, prefix+"busy", now.Add(-48*time.Hour), []byte("old"), storage.HistoryRetention{}))
+	for i, value := range []string{"a", "b", "c"} {
+		require.NoError(t, etcdStorage.AppendHistory(ctx, prefix+"busy", now.Add(time.Duration(i)*time.Second), []byte(value), storage.HistoryRetention{}))
+	}
+	require.NoError(t, etcdStorage.AppendHistory(ctx, prefix+"quiet", now, []byte("a"), storage.HistoryRetention{}))
+	require.NoError(t, etcdStorage.AppendHistory(ctx, "/shared-lock-history/other/stale", now.Add(-48*time.Hour), []byte("old"), storage.HistoryRetention{}))
+
+	require.NoError(t, etcdStorage.PruneHistories(ctx, prefix, storage.HistoryRetention{MaxAge: 24 * time.Hour, MaxRecords: 2}))
+
+	expected := map[string][]string{
+		prefix + "stale":                   {},
+		prefix + "busy":                    {"b", "c"},
+		prefix + "quiet":                   {"a"},
+		"/shared-lock-history/other/stale": {"old"},
+	}
+	for key, values := range expected {
+		records, err := etcdStorage.History(ctx, key, time.Time{}, time.Time{}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, values, historyValues(records), key)
+	}
+}
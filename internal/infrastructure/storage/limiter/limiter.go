@@ -0,0 +1,194 @@
+// Package limiter caps the number of concurrent requests sent to a storage
+// backend.
+package limiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/metrics"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
+)
+
+// Storage passes calls through to the wrapped storage while at most a fixed
+// number of them are in flight. Calls that cannot start within the maximum
+// wait fail with storage.ErrOverloaded.
+type Storage struct {
+	storage.Storage
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+func New(inner storage.Storage, maxConcurrent int, maxWait time.Duration) *Storage {
+	return &Storage{
+		Storage: inner,
+		slots:   make(chan struct{}, maxConcurrent),
+		maxWait: maxWait,
+	}
+}
+
+func (s *Storage) acquire(ctx context.Context) (err error) {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	// Only a call that has to wait for a slot gets a span.
+	_, span := tracing.Start(ctx, "limiter.Wait")
+	defer func() { tracing.End(span, err) }()
+
+	timer := time.NewTimer(s.maxWait)
+	defer timer.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		metrics.RateLimitRejections.WithLabelValues("storage").Inc()
+		return storage.ErrOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Storage) release() {
+	<-s.slots
+}
+
+func (s *Storage) CheckLeasePresence(ctx context.Context, key string) (int64, error) {
+	if err := s.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer s.release()
+
+	return s.Storage.CheckLeasePresence(ctx, key)
+}
+
+func (s *Storage) CreateLease(ctx context.Context, key string, leaseTTL int64, data []byte) (string, int64, error) {
+	if err := s.acquire(ctx); err != nil {
+		return "", 0, err
+	}
+	defer s.release()
+
+	return s.Storage.CreateLease(ctx, key, leaseTTL, data)
+}
+
+func (s *Storage) KeepLeaseOnce(ctx context.Context, leaseID int64) (int64, error) {
+	if err := s.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer s.release()
+
+	return s.Storage.KeepLeaseOnce(ctx, leaseID)
+}
+
+func (s *Storage) RevokeLease(ctx context.Context, key string, leaseID int64) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+
+	return s.Storage.RevokeLease(ctx, key, leaseID)
+}
+
+func (s *Storage) TransferLease(ctx context.Context, key string, leaseID, leaseTTL int64, data []byte) (int64, error) {
+	if err := s.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer s.release()
+
+	return s.Storage.TransferLease(ctx, key, leaseID, leaseTTL, data)
+}
+
+func (s *Storage) AttachToLease(ctx context.Context, key string, leaseID int64, data []byte) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+
+	return s.Storage.AttachToLease(ctx, key, leaseID, data)
+}
+
+func (s *Storage) GetLease(ctx context.Context, key string) (*storage.LeaseInfo, error) {
+	if err := s.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	return s.Storage.GetLease(ctx, key)
+}
+
+func (s *Storage) LeaseKeys(ctx context.Context, leaseID int64) ([]string, error) {
+	if err := s.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	return s.Storage.LeaseKeys(ctx, leaseID)
+}
+
+func (s *Storage) ListLeases(ctx context.Context, prefix string) ([]storage.LeaseInfo, error) {
+	if err := s.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	return s.Storage.ListLeases(ctx, prefix)
+}
+
+func (s *Storage) ListKeys(ctx context.Context, prefix string) ([]storage.LeaseInfo, error) {
+	if err := s.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	return s.Storage.ListKeys(ctx, prefix)
+}
+
+// WatchLeases does not take a slot, as a watch stays open for the lifetime
+// of the process.
+func (s *Storage) WatchLeases(ctx context.Context, prefix string) (<-chan storage.LeaseEvent, error) {
+	return s.Storage.WatchLeases(ctx, prefix)
+}
+
+// History passes calls through to the wrapped history storage while holding
+// a slot of the Storage it was made from, so that history and lock requests
+// share one limit.
+type History struct {
+	storage.HistoryStorage
+	limiter *Storage
+}
+
+// WrapHistory limits the requests to inner together with those to s.
+func (s *Storage) WrapHistory(inner storage.HistoryStorage) *History {
+	return &History{HistoryStorage: inner, limiter: s}
+}
+
+func (h *History) AppendHistory(ctx context.Context, key string, t time.Time, value []byte, retention storage.HistoryRetention) error {
+	if err := h.limiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer h.limiter.release()
+
+	return h.HistoryStorage.AppendHistory(ctx, key, t, value, retention)
+}
+
+func (h *History) PruneHistories(ctx context.Context, prefix string, retention storage.HistoryRetention) error {
+	if err := h.limiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer h.limiter.release()
+
+	return h.HistoryStorage.PruneHistories(ctx, prefix, retention)
+}
+
+func (h *History) History(ctx context.Context, key string, from, to time.Time, limit int) ([]storage.HistoryRecord, error) {
+	if err := h.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer h.limiter.release()
+
+	return h.HistoryStorage.History(ctx, key, from, to, limit)
+}
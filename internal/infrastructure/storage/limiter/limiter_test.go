@@ -0,0 +1,81 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage/mock"
+)
+
+type blockingStorage struct {
+	*mock.Storage
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (s *blockingStorage) KeepLeaseOnce(ctx context.Context, leaseID int64) (int64, error) {
+	s.entered <- struct{}{}
+	<-s.unblock
+	return s.Storage.KeepLeaseOnce(ctx, leaseID)
+}
+
+func TestStorage_LimitsConcurrentRequests(t *testing.T) {
+	inner := &blockingStorage{Storage: mock.New(), entered: make(chan struct{}), unblock: make(chan struct{})}
+	limited := New(inner, 1, 20*time.Millisecond)
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() {
+		_, err := limited.KeepLeaseOnce(ctx, 1)
+		done <- err
+	}()
+	<-inner.entered
+
+	_, err := limited.KeepLeaseOnce(ctx, 2)
+	assert.ErrorIs(t, err, storage.ErrOverloaded)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = limited.CheckLeasePresence(cancelled, "key")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(inner.unblock)
+	require.NoError(t, <-done)
+
+	status, _, err := limited.CreateLease(ctx, "key", 10, nil)
+	require.NoError(t, err, "the slot is free again")
+	assert.Equal(t, storage.StatusCreated, status)
+}
+
+func TestHistory_SharesLimit(t *testing.T) {
+	inner := &blockingStorage{Storage: mock.New(), entered: make(chan struct{}), unblock: make(chan struct{})}
+	limited := New(inner, 1, 20*time.Millisecond)
+	history := limited.WrapHistory(inner)
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() {
+		_, err := limited.KeepLeaseOnce(ctx, 1)
+		done <- err
+	}()
+	<-inner.entered
+
+	err := history.AppendHistory(ctx, "key", time.Now(), []byte("event"), storage.HistoryRetention{})
+	assert.ErrorIs(t, err, storage.ErrOverloaded)
+	_, err = history.History(ctx, "key", time.Time{}, time.Time{}, 10)
+	assert.ErrorIs(t, err, storage.ErrOverloaded)
+	err = history.PruneHistories(ctx, "", storage.HistoryRetention{})
+	assert.ErrorIs(t, err, storage.ErrOverloaded)
+
+	close(inner.unblock)
+	require.NoError(t, <-done)
+
+	require.NoError(t, history.AppendHistory(ctx, "key", time.Now(), []byte("event"), storage.HistoryRetention{}), "the slot is free again")
+	records, err := history.History(ctx, "key", time.Time{}, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+}
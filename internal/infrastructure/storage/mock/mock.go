@@ -2,10 +2,13 @@ package mock
 
 import (
 	"context"
-	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/tentens-tech/shared-lock/internal/infrastructure/storage"
+	"github.com/tentens-tech/shared-lock/internal/infrastructure/tracing"
 )
 
 const DefaultPrefix = "/shared-lock/"
@@ -13,15 +16,25 @@ const DefaultPrefix = "/shared-lock/"
 type Storage struct {
 	mu             sync.RWMutex
 	ExistingLeases map[string]int64
+	Values         map[string][]byte
+	Histories      map[string][]storage.HistoryRecord
+	Revisions      map[string]int64
+	revision       int64
 }
 
 func New() *Storage {
 	return &Storage{
 		ExistingLeases: make(map[string]int64),
+		Values:         make(map[string][]byte),
+		Histories:      make(map[string][]storage.HistoryRecord),
+		Revisions:      make(map[string]int64),
 	}
 }
 
 func (s *Storage) CheckLeasePresence(ctx context.Context, key string) (int64, error) {
+	_, span := tracing.Start(ctx, "mock.CheckLeasePresence", tracing.KeyAttribute.String(key))
+	defer span.End()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	leaseKey := DefaultPrefix + key
@@ -32,6 +45,9 @@ func (s *Storage) CheckLeasePresence(ctx context.Context, key string) (int64, er
 }
 
 func (s *Storage) CreateLease(ctx context.Context, key string, leaseTTL int64, data []byte) (string, int64, error) {
+	_, span := tracing.Start(ctx, "mock.CreateLease", tracing.KeyAttribute.String(key))
+	defer span.End()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -40,13 +56,213 @@ func (s *Storage) CreateLease(ctx context.Context, key string, leaseTTL int64, d
 	}
 
 	leaseID := int64(123)
+	s.revision++
 	s.ExistingLeases[key] = leaseID
+	s.Values[key] = data
+	s.Revisions[key] = s.revision
 	return storage.StatusCreated, leaseID, nil
 }
 
-func (s *Storage) KeepLeaseOnce(ctx context.Context, leaseID int64) error {
+func (s *Storage) KeepLeaseOnce(ctx context.Context, leaseID int64) (_ int64, err error) {
+	_, span := tracing.Start(ctx, "mock.KeepLeaseOnce", tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
 	if leaseID == 999 {
-		return fmt.Errorf("lease not found")
+		return 0, storage.ErrLeaseNotFound
+	}
+	return 0, nil
+}
+
+func (s *Storage) RevokeLease(ctx context.Context, key string, leaseID int64) (err error) {
+	_, span := tracing.Start(ctx, "mock.RevokeLease", tracing.KeyAttribute.String(key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existingLeaseID, exists := s.ExistingLeases[key]; !exists || existingLeaseID != leaseID {
+		return storage.ErrLeaseNotFound
 	}
+
+	delete(s.ExistingLeases, key)
+	delete(s.Values, key)
+	delete(s.Revisions, key)
+	return nil
+}
+
+func (s *Storage) AttachToLease(ctx context.Context, key string, leaseID int64, data []byte) (err error) {
+	_, span := tracing.Start(ctx, "mock.AttachToLease", tracing.KeyAttribute.String(key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	if leaseID == 999 {
+		return storage.ErrLeaseNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ExistingLeases[key] = leaseID
+	s.Values[key] = data
 	return nil
 }
+
+func (s *Storage) GetLease(ctx context.Context, key string) (_ *storage.LeaseInfo, err error) {
+	_, span := tracing.Start(ctx, "mock.GetLease", tracing.KeyAttribute.String(key))
+	defer func() { tracing.End(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	leaseID, exists := s.ExistingLeases[key]
+	if !exists {
+		return nil, storage.ErrLeaseNotFound
+	}
+
+	return &storage.LeaseInfo{Key: key, LeaseID: leaseID, Value: s.Values[key], CreateRevision: s.Revisions[key]}, nil
+}
+
+// TransferLease gives key the next lease ID after 123, counting up with
+// every transfer.
+func (s *Storage) TransferLease(ctx context.Context, key string, leaseID, leaseTTL int64, data []byte) (_ int64, err error) {
+	_, span := tracing.Start(ctx, "mock.TransferLease", tracing.KeyAttribute.String(key), tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existingLeaseID, exists := s.ExistingLeases[key]; !exists || existingLeaseID != leaseID {
+		return 0, storage.ErrLeaseNotFound
+	}
+
+	newLeaseID := leaseID + 1
+	s.ExistingLeases[key] = newLeaseID
+	s.Values[key] = data
+	return newLeaseID, nil
+}
+
+func (s *Storage) LeaseKeys(ctx context.Context, leaseID int64) (_ []string, err error) {
+	_, span := tracing.Start(ctx, "mock.LeaseKeys", tracing.LeaseIDAttribute.Int64(leaseID))
+	defer func() { tracing.End(span, err) }()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0)
+	for key, existingLeaseID := range s.ExistingLeases {
+		if existingLeaseID == leaseID {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, storage.ErrLeaseNotFound
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// WatchLeases returns a channel without events that is closed when ctx is
+// done.
+func (s *Storage) WatchLeases(ctx context.Context, prefix string) (<-chan storage.LeaseEvent, error) {
+	events := make(chan storage.LeaseEvent)
+	go func() {
+		<-ctx.Done()
+		close(events)
+	}()
+
+	return events, nil
+}
+
+func (s *Storage) ListLeases(ctx context.Context, prefix string) ([]storage.LeaseInfo, error) {
+	_, span := tracing.Start(ctx, "mock.ListLeases", tracing.KeyAttribute.String(prefix))
+	defer span.End()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	leases := make([]storage.LeaseInfo, 0)
+	for key, leaseID := range s.ExistingLeases {
+		if strings.HasPrefix(key, prefix) {
+			leases = append(leases, storage.LeaseInfo{Key: key, LeaseID: leaseID, Value: s.Values[key], CreateRevision: s.Revisions[key]})
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Key < leases[j].Key })
+
+	return leases, nil
+}
+
+// ListKeys is ListLeases, as the mock knows no TTLs.
+func (s *Storage) ListKeys(ctx context.Context, prefix string) ([]storage.LeaseInfo, error) {
+	return s.ListLeases(ctx, prefix)
+}
+
+func (s *Storage) AppendHistory(ctx context.Context, key string, t time.Time, value []byte, retention storage.HistoryRetention) error {
+	_, span := tracing.Start(ctx, "mock.AppendHistory", tracing.KeyAttribute.String(key))
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := append(s.Histories[key], storage.HistoryRecord{Time: t, Value: value})
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	s.Histories[key] = retain(records, retention)
+
+	return nil
+}
+
+func (s *Storage) PruneHistories(ctx context.Context, prefix string, retention storage.HistoryRetention) error {
+	_, span := tracing.Start(ctx, "mock.PruneHistories")
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, records := range s.Histories {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if records = retain(records, retention); len(records) == 0 {
+			delete(s.Histories, key)
+			continue
+		}
+		s.Histories[key] = records
+	}
+
+	return nil
+}
+
+// retain drops the records sorted by time that fall out of retention.
+func retain(records []storage.HistoryRecord, retention storage.HistoryRetention) []storage.HistoryRecord {
+	if retention.MaxAge > 0 {
+		cutoff := time.Now().Add(-retention.MaxAge)
+		for len(records) > 0 && records[0].Time.Before(cutoff) {
+			records = records[1:]
+		}
+	}
+	if retention.MaxRecords > 0 && len(records) > retention.MaxRecords {
+		records = records[len(records)-retention.MaxRecords:]
+	}
+
+	return records
+}
+
+func (s *Storage) History(ctx context.Context, key string, from, to time.Time, limit int) ([]storage.HistoryRecord, error) {
+	_, span := tracing.Start(ctx, "mock.History", tracing.KeyAttribute.String(key))
+	defer span.End()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]storage.HistoryRecord, 0)
+	for _, record := range s.Histories[key] {
+		if record.Time.Before(from) || (!to.IsZero() && !record.Time.Before(to)) {
+			continue
+		}
+		if limit > 0 && len(records) == limit {
+			break
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
 const (
@@ -9,8 +11,89 @@ const (
 	StatusCreated  = "created"
 )
 
+var (
+	// ErrLeaseNotFound is returned when a key is not held, or not held by
+	// the given lease.
+	ErrLeaseNotFound = errors.New("lease not found")
+	// ErrOverloaded is returned when too many storage requests are in
+	// flight to start another one.
+	ErrOverloaded = errors.New("storage overloaded")
+)
+
+// LeaseInfo is the stored state of a held key.
+type LeaseInfo struct {
+	Key     string
+	LeaseID int64
+	Value   []byte
+	// TTL is the remaining lifetime of the lease in seconds.
+	TTL int64
+	// GrantedTTL is the lifetime in seconds the lease was granted with.
+	GrantedTTL int64
+	// CreateRevision orders keys by the time they were created: it grows
+	// with every key created in the storage.
+	CreateRevision int64
+}
+
+// LeaseEvent is a change to a key under a watched prefix.
+type LeaseEvent struct {
+	Key string
+	// LeaseID is the lease now holding the key, zero when Deleted.
+	LeaseID int64
+	// Deleted is set when the key was released or its lease expired.
+	Deleted bool
+}
+
+// HistoryRecord is an entry in the history of a key.
+type HistoryRecord struct {
+	Time  time.Time
+	Value []byte
+}
+
+// HistoryRetention bounds the history kept per key. Zero disables a limit.
+type HistoryRetention struct {
+	MaxAge     time.Duration
+	MaxRecords int
+}
+
+// HistoryStorage keeps a bounded log of records per history key.
+type HistoryStorage interface {
+	// AppendHistory adds a record at t to the history at key and drops the
+	// records that fall out of retention.
+	AppendHistory(ctx context.Context, key string, t time.Time, value []byte, retention HistoryRetention) error
+	// PruneHistories drops the records that fall out of retention from every
+	// history under prefix, including those no longer appended to.
+	PruneHistories(ctx context.Context, prefix string, retention HistoryRetention) error
+	// History returns up to limit records of the history at key from from
+	// (inclusive) until to (exclusive), oldest first. A zero to means now.
+	History(ctx context.Context, key string, from, to time.Time, limit int) ([]HistoryRecord, error)
+}
+
 type Storage interface {
 	CheckLeasePresence(ctx context.Context, key string) (leaseID int64, err error)
 	CreateLease(ctx context.Context, key string, leaseTTL int64, data []byte) (leaseStatus string, leaseID int64, err error)
-	KeepLeaseOnce(ctx context.Context, leaseID int64) error
+	// KeepLeaseOnce renews leaseID once and returns its remaining TTL in
+	// seconds. It returns ErrLeaseNotFound when the lease has expired or was
+	// revoked.
+	KeepLeaseOnce(ctx context.Context, leaseID int64) (ttl int64, err error)
+	// RevokeLease releases key if it is currently held by leaseID.
+	RevokeLease(ctx context.Context, key string, leaseID int64) error
+	// TransferLease moves key from leaseID to a new lease of leaseTTL
+	// seconds storing data, in one step, and revokes leaseID. It returns
+	// ErrLeaseNotFound when key is not held by leaseID.
+	TransferLease(ctx context.Context, key string, leaseID, leaseTTL int64, data []byte) (newLeaseID int64, err error)
+	// AttachToLease stores data at key for as long as leaseID lives. It
+	// returns ErrLeaseNotFound when the lease has expired or was revoked.
+	AttachToLease(ctx context.Context, key string, leaseID int64, data []byte) error
+	GetLease(ctx context.Context, key string) (*LeaseInfo, error)
+	// LeaseKeys returns the keys held by leaseID. It returns
+	// ErrLeaseNotFound when the lease has expired or was revoked.
+	LeaseKeys(ctx context.Context, leaseID int64) ([]string, error)
+	ListLeases(ctx context.Context, prefix string) ([]LeaseInfo, error)
+	// ListKeys is ListLeases without TTL and GrantedTTL, which take a
+	// request per lease to look up.
+	ListKeys(ctx context.Context, prefix string) ([]LeaseInfo, error)
+	// WatchLeases streams the changes to the keys under prefix. It returns
+	// once the watch is established; the channel is closed when ctx is done
+	// or the watch breaks.
+	WatchLeases(ctx context.Context, prefix string) (<-chan LeaseEvent, error)
 }
@@ -0,0 +1,86 @@
+// Package tracing sets up OpenTelemetry tracing and provides the helpers the
+// HTTP, application and storage layers use to record spans.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/tentens-tech/shared-lock"
+
+// Span attribute keys.
+const (
+	KeyAttribute       = attribute.Key("shared_lock.key")
+	NamespaceAttribute = attribute.Key("shared_lock.namespace")
+	LeaseIDAttribute   = attribute.Key("shared_lock.lease_id")
+
+	LeaseStatusAttribute = attribute.Key("shared_lock.lease_status")
+)
+
+// Setup installs the W3C trace context propagator and, when tracing is
+// enabled, a tracer provider exporting over OTLP/gRPC. The returned function
+// flushes pending spans and stops the exporter.
+func Setup(ctx context.Context, cfg config.TracingCfg) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %v", err)
+	}
+
+	provider := NewTracerProvider(cfg, sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// NewTracerProvider returns a tracer provider for cfg that hands spans to
+// the given span processors.
+func NewTracerProvider(cfg config.TracingCfg, options ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	options = append(options,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))),
+	)
+
+	return sdktrace.NewTracerProvider(options...)
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// StartServer starts a server span for an incoming request.
+func StartServer(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+}
+
+// End records err, if any, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
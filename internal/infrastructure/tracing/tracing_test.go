@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tentens-tech/shared-lock/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func useTracerProvider(t *testing.T, cfg config.TracingCfg) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := NewTracerProvider(cfg, sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+
+	return exporter
+}
+
+func TestStartEnd(t *testing.T) {
+	exporter := useTracerProvider(t, config.TracingCfg{SampleRatio: 1, ServiceName: "test"})
+
+	ctx, parent := Start(context.Background(), "parent", KeyAttribute.String("key"))
+	_, child := Start(ctx, "child")
+	End(child, errors.New("storage unavailable"))
+	End(parent, nil)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "storage unavailable", spans[0].Status.Description)
+	require.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "exception", spans[0].Events[0].Name)
+
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+	assert.Contains(t, spans[1].Attributes, KeyAttribute.String("key"))
+	assert.Contains(t, spans[1].Resource.Attributes(), semconv.ServiceName("test"))
+}
+
+func TestSampling(t *testing.T) {
+	tests := []struct {
+		name     string
+		sampled  *bool
+		ratio    float64
+		exported int
+	}{
+		{name: "never sampled without parent", ratio: 0, exported: 0},
+		{name: "always sampled without parent", ratio: 1, exported: 1},
+		{name: "sampled parent wins over ratio", sampled: ptr(true), ratio: 0, exported: 1},
+		{name: "unsampled parent wins over ratio", sampled: ptr(false), ratio: 1, exported: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := useTracerProvider(t, config.TracingCfg{SampleRatio: tt.ratio})
+
+			ctx := context.Background()
+			if tt.sampled != nil {
+				spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+					TraceID: trace.TraceID{1},
+					SpanID:  trace.SpanID{1},
+					Remote:  true,
+				})
+				if *tt.sampled {
+					spanContext = spanContext.WithTraceFlags(trace.FlagsSampled)
+				}
+				ctx = trace.ContextWithRemoteSpanContext(ctx, spanContext)
+			}
+
+			_, span := Start(ctx, "span")
+			End(span, nil)
+
+			assert.Len(t, exporter.GetSpans(), tt.exported)
+		})
+	}
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	shutdown, err := Setup(context.Background(), config.TracingCfg{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	header := http.Header{}
+	header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	spanContext := trace.SpanContextFromContext(ctx)
+	assert.True(t, spanContext.IsRemote())
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spanContext.TraceID().String())
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"os"
 
 	"github.com/tentens-tech/shared-lock/internal/delivery"
 )
 
 func main() {
 	if err := delivery.Execute(context.Background()); err != nil {
+		var exitErr *delivery.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		log.Fatal(err)
 	}
 }